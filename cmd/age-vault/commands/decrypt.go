@@ -1,23 +1,20 @@
 package commands
 
 import (
+	"bufio"
 	"fmt"
 	"io"
 	"os"
 
 	"github.com/leolimasa/age-vault/config"
 	"github.com/leolimasa/age-vault/keymgmt"
+	"github.com/leolimasa/age-vault/vault"
 )
 
 // RunDecrypt handles the decrypt command.
 // It loads the user's identity, decrypts the vault key, and decrypts data from input to output.
+// Data encrypted for a sub-vault is decrypted with the key of that sub-vault.
 func RunDecrypt(inputPath, outputPath string, cfg *config.Config) error {
-	// Load and decrypt vault key
-	vaultKey, err := keymgmt.VaultKeyFromIdentityFile(cfg.IdentityFile, cfg.VaultKeyFile)
-	if err != nil {
-		return fmt.Errorf("failed to load vault key: %w", err)
-	}
-
 	// Open input (file or stdin)
 	var input io.Reader
 	if inputPath == "" {
@@ -31,6 +28,26 @@ func RunDecrypt(inputPath, outputPath string, cfg *config.Config) error {
 		input = f
 	}
 
+	// Data encrypted for a sub-vault names it in its header
+	bufferedInput := bufio.NewReaderSize(input, vault.MaxHeaderSize)
+	header, _ := bufferedInput.Peek(vault.MaxHeaderSize)
+	input = bufferedInput
+
+	// Load and decrypt vault key
+	var vaultKey *vault.VaultKey
+	var err error
+	if subvault := vault.SubvaultName(header); subvault != "" {
+		vaultKey, err = openSubvault(subvault, cfg)
+		if err != nil {
+			return err
+		}
+	} else {
+		vaultKey, err = keymgmt.VaultKeyFromIdentityFile(cfg.IdentityFile, cfg.VaultKeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load vault key: %w", err)
+		}
+	}
+
 	// Open output (file or stdout)
 	var output io.Writer
 	if outputPath == "" {
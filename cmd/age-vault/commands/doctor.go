@@ -0,0 +1,289 @@
+package commands
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"filippo.io/age/plugin"
+	"github.com/leolimasa/age-vault/config"
+	"github.com/leolimasa/age-vault/keymgmt"
+	"github.com/leolimasa/age-vault/vault"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// doctorEnvVars are the environment variables that override age_vault.yml.
+var doctorEnvVars = []string{
+	"AGE_VAULT_NAME",
+	"AGE_VAULT_KEY_FILE",
+	"AGE_VAULT_IDENTITY_FILE",
+	"AGE_VAULT_SSH_KEYS_DIR",
+	"AGE_VAULT_RECIPIENTS_FILE",
+	"AGE_VAULT_SUBVAULTS_DIR",
+}
+
+// doctorAgentTimeout bounds connecting to and querying an ssh agent socket.
+const doctorAgentTimeout = 2 * time.Second
+
+// doctor prints the result of each check and counts failures and warnings.
+type doctor struct {
+	failures int
+	warnings int
+}
+
+// ok prints a successful check.
+func (d *doctor) ok(format string, args ...any) {
+	fmt.Printf("OK    "+format+"\n", args...)
+}
+
+// warn prints a problem that does not prevent using the vault.
+func (d *doctor) warn(format string, args ...any) {
+	d.warnings++
+	fmt.Printf("WARN  "+format+"\n", args...)
+}
+
+// fail prints a failed check.
+func (d *doctor) fail(format string, args ...any) {
+	d.failures++
+	fmt.Printf("FAIL  "+format+"\n", args...)
+}
+
+// RunDoctor handles the doctor command.
+// It diagnoses the setup end to end: which age_vault.yml is used and why, the permissions
+// of the identity and vault key files, whether the identity loads (and its plugins are on
+// PATH), a round-trip encrypt/decrypt with the vault key, sops availability and running ssh
+// agents. Fails if any check failed; warnings don't make it fail.
+// It loads the configuration of the vault named vaultName (see config.NewConfigForVault)
+// itself, so a configuration that fails to load is reported as a failed check instead of
+// stopping the diagnosis.
+func RunDoctor(vaultName string) error {
+	d := &doctor{}
+
+	if cfg := d.checkConfig(vaultName); cfg != nil {
+		identitiesOK := d.checkIdentity(cfg)
+		vaultKeyFileOK := d.checkFile("Vault key file", cfg.VaultKeyFile, "create one with 'age-vault vault-key encrypt' or get one from a member")
+		if identitiesOK && vaultKeyFileOK {
+			d.checkVaultKey(cfg)
+		}
+	}
+	d.checkSops()
+	d.checkSSHAgents()
+
+	fmt.Printf("%d check(s) failed, %d warning(s)\n", d.failures, d.warnings)
+	if d.failures > 0 {
+		return fmt.Errorf("%d check(s) failed", d.failures)
+	}
+	return nil
+}
+
+// checkConfig reports the config file in use and the environment variables overriding it,
+// and loads the configuration of the named vault. Returns nil if it fails to load.
+func (d *doctor) checkConfig(vaultName string) *config.Config {
+	configPath, reason := config.LocateConfigFile()
+	if configPath == "" {
+		d.ok("Config file: none (%s), using environment variables and defaults", reason)
+	} else {
+		d.ok("Config file: %s (%s)", configPath, reason)
+	}
+
+	var overrides []string
+	for _, name := range doctorEnvVars {
+		if os.Getenv(name) != "" {
+			overrides = append(overrides, name)
+		}
+	}
+	if len(overrides) > 0 {
+		d.ok("Environment overrides: %s", strings.Join(overrides, ", "))
+	}
+
+	var cfg *config.Config
+	var err error
+	if vaultName != "" {
+		cfg, err = config.NewConfigForVault(vaultName)
+	} else {
+		cfg, err = config.NewConfig()
+	}
+	if err != nil {
+		d.fail("Configuration: %v", err)
+		return nil
+	}
+
+	if cfg.VaultName != "" {
+		d.ok("Vault: %s", cfg.VaultName)
+	}
+	return cfg
+}
+
+// checkFile checks that a file exists and is not accessible to other users.
+// Returns false if the file is missing.
+func (d *doctor) checkFile(what, path, hint string) bool {
+	if path == "" {
+		d.fail("%s: not configured", what)
+		return false
+	}
+
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		d.fail("%s: %s does not exist (%s)", what, path, hint)
+		return false
+	} else if err != nil {
+		d.fail("%s: %v", what, err)
+		return false
+	}
+
+	// Unix permission bits are meaningless on Windows
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		d.warn("%s: %s is accessible to other users (mode %04o, fix with chmod 600 %s)", what, path, info.Mode().Perm(), path)
+		return true
+	}
+	d.ok("%s: %s (mode %04o)", what, path, info.Mode().Perm())
+	return true
+}
+
+// checkIdentity checks that the identity file loads and that its plugins are installed.
+// Returns false if the identity cannot be used.
+func (d *doctor) checkIdentity(cfg *config.Config) bool {
+	if !d.checkFile("Identity file", cfg.IdentityFile, "set one with 'age-vault identity set'") {
+		return false
+	}
+
+	identities, err := keymgmt.LoadIdentities(cfg.IdentityFile)
+	if err != nil {
+		d.fail("Identity: %v", err)
+		return false
+	}
+
+	usable := true
+	for _, identity := range identities {
+		if pluginIdentity, ok := identity.(*plugin.Identity); ok {
+			binary := "age-plugin-" + pluginIdentity.Name()
+			path, err := exec.LookPath(binary)
+			if err != nil {
+				d.fail("Identity: plugin %s, but %s is not on PATH", pluginIdentity.Name(), binary)
+				usable = false
+				continue
+			}
+			d.ok("Identity: plugin %s (%s)", pluginIdentity.Name(), path)
+			continue
+		}
+
+		keyType, err := vault.KeyTypeOf(identity)
+		if err != nil {
+			d.fail("Identity: %v", err)
+			usable = false
+			continue
+		}
+		d.ok("Identity: native %s key", keyType)
+	}
+
+	if pubkey, err := keymgmt.ExtractRecipientString(cfg.IdentityFile); err != nil {
+		d.warn("Public key: %v", err)
+	} else {
+		d.ok("Public key: %s", pubkey)
+	}
+
+	return usable
+}
+
+// checkVaultKey opens the vault key with the identity and round-trips data through it.
+func (d *doctor) checkVaultKey(cfg *config.Config) {
+	if data, err := os.ReadFile(cfg.VaultKeyFile); err == nil {
+		if envelope, _, err := vault.OpenEnvelope(data); err != nil {
+			d.warn("Vault key envelope: %v", err)
+		} else if envelope != nil {
+			d.ok("Vault key envelope: vault %s, generation %d, label %q", envelope.VaultID, envelope.Generation, envelope.Label)
+		}
+	}
+
+	// Plugin identities may ask for a PIN or a touch here, but never for the recovery passphrase
+	vaultKey, err := openVaultKeyWithoutPrompt(cfg)
+	if err != nil {
+		d.fail("Vault key: %v", err)
+		return
+	}
+	d.ok("Vault key: opens with the identity (vault %s, generation %d, %d retired key(s))",
+		vaultKey.ID(), vaultKey.Generation(), len(vaultKey.Identities())-1)
+
+	probe := make([]byte, 32)
+	if _, err := rand.Read(probe); err != nil {
+		d.fail("Round trip: failed to generate data: %v", err)
+		return
+	}
+	var encrypted, decrypted bytes.Buffer
+	if err := vaultKey.Encrypt(bytes.NewReader(probe), &encrypted); err != nil {
+		d.fail("Round trip: failed to encrypt: %v", err)
+		return
+	}
+	if err := vaultKey.Decrypt(&encrypted, &decrypted); err != nil {
+		d.fail("Round trip: failed to decrypt: %v", err)
+		return
+	}
+	if !bytes.Equal(decrypted.Bytes(), probe) {
+		d.fail("Round trip: decrypted data differs from the original")
+		return
+	}
+	d.ok("Round trip: encrypt and decrypt with the vault key work")
+}
+
+// checkSops checks that sops is installed, for the sops command.
+func (d *doctor) checkSops() {
+	path, err := exec.LookPath("sops")
+	if err != nil {
+		d.warn("sops: not on PATH ('age-vault sops' will not work)")
+		return
+	}
+	d.ok("sops: %s", path)
+}
+
+// checkSSHAgents pings the agent in SSH_AUTH_SOCK and the agents started by
+// 'age-vault ssh start-agent'.
+func (d *doctor) checkSSHAgents() {
+	var sockets []string
+	if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" {
+		sockets = append(sockets, socket)
+	}
+	matches, _ := filepath.Glob(filepath.Join(os.TempDir(), "age-vault-ssh-agent-*.sock"))
+	for _, socket := range matches {
+		if socket != os.Getenv("SSH_AUTH_SOCK") {
+			sockets = append(sockets, socket)
+		}
+	}
+
+	if len(sockets) == 0 {
+		d.ok("SSH agent: none running")
+		return
+	}
+	for _, socket := range sockets {
+		keys, err := pingSSHAgent(socket)
+		if err != nil {
+			d.warn("SSH agent: %s does not respond: %v", socket, err)
+			continue
+		}
+		d.ok("SSH agent: %s responds with %d key(s)", socket, keys)
+	}
+}
+
+// pingSSHAgent connects to an ssh agent socket and returns the number of keys it holds.
+func pingSSHAgent(socket string) (int, error) {
+	conn, err := net.DialTimeout("unix", socket, doctorAgentTimeout)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(doctorAgentTimeout)); err != nil {
+		return 0, err
+	}
+	keys, err := agent.NewClient(conn).List()
+	if err != nil {
+		return 0, err
+	}
+	return len(keys), nil
+}
@@ -0,0 +1,104 @@
+package commands
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/leolimasa/age-vault/config"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestRunDoctor(t *testing.T) {
+	tempDir := t.TempDir()
+	t.Setenv("SSH_AUTH_SOCK", "")
+	t.Setenv("HOME", tempDir)
+	t.Chdir(tempDir)
+
+	identityPath := filepath.Join(tempDir, "identity.txt")
+	identity := writeTestIdentity(t, identityPath)
+	vaultKeyPath := filepath.Join(tempDir, "vault_key.age")
+	writeTestVaultKey(t, identity, vaultKeyPath)
+	for name, value := range map[string]string{
+		"AGE_VAULT_NAME":          "",
+		"AGE_VAULT_IDENTITY_FILE": identityPath,
+		"AGE_VAULT_KEY_FILE":      vaultKeyPath,
+	} {
+		t.Setenv(name, value)
+	}
+
+	if err := RunDoctor(""); err != nil {
+		t.Errorf("RunDoctor failed on a working setup: %v", err)
+	}
+
+	// Loose permissions are warnings only
+	if err := os.Chmod(identityPath, 0644); err != nil {
+		t.Fatalf("failed to change permissions: %v", err)
+	}
+	d := &doctor{}
+	if !d.checkFile("Identity file", identityPath, "") || d.warnings != 1 || d.failures != 0 {
+		t.Errorf("expected a warning for loose permissions, got %d warning(s), %d failure(s)", d.warnings, d.failures)
+	}
+
+	// A vault key encrypted for someone else fails
+	otherIdentity := writeTestIdentity(t, filepath.Join(tempDir, "other.txt"))
+	writeTestVaultKey(t, otherIdentity, vaultKeyPath)
+	err := RunDoctor("")
+	if err == nil || !strings.Contains(err.Error(), "1 check(s) failed") {
+		t.Errorf("expected one failed check, got: %v", err)
+	}
+
+	// A configuration that fails to load is a failed check, not the end of the diagnosis
+	t.Setenv("AGE_VAULT_NAME", "bogus")
+	err = RunDoctor("")
+	if err == nil || !strings.Contains(err.Error(), "1 check(s) failed") {
+		t.Errorf("expected one failed check for an unknown vault, got: %v", err)
+	}
+
+	// A missing identity fails without trying the vault key
+	cfg := &config.Config{IdentityFile: filepath.Join(tempDir, "missing.txt"), VaultKeyFile: vaultKeyPath}
+	d = &doctor{}
+	if d.checkIdentity(cfg) || d.failures != 1 {
+		t.Errorf("expected a missing identity to fail once, got %d failure(s)", d.failures)
+	}
+}
+
+func TestPingSSHAgent(t *testing.T) {
+	// Unix socket paths are short, so don't nest them in the test directory
+	socketDir, err := os.MkdirTemp("", "doctor")
+	if err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	defer os.RemoveAll(socketDir)
+	socket := filepath.Join(socketDir, "agent.sock")
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go agent.ServeAgent(agent.NewKeyring(), conn)
+		}
+	}()
+
+	keys, err := pingSSHAgent(socket)
+	if err != nil {
+		t.Fatalf("pingSSHAgent failed: %v", err)
+	}
+	if keys != 0 {
+		t.Errorf("expected 0 keys, got %d", keys)
+	}
+
+	listener.Close()
+	if _, err := pingSSHAgent(socket); err == nil {
+		t.Error("expected an error from a closed agent")
+	}
+}
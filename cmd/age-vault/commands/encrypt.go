@@ -1,21 +1,38 @@
 package commands
 
 import (
+	"bufio"
 	"fmt"
 	"io"
+	"mime"
+	"net/http"
 	"os"
+	"path/filepath"
 
 	"github.com/leolimasa/age-vault/config"
 	"github.com/leolimasa/age-vault/keymgmt"
+	"github.com/leolimasa/age-vault/vault"
 )
 
 // RunEncrypt handles the encrypt command.
 // It loads the user's identity, decrypts the vault key, and encrypts data from input to output.
-func RunEncrypt(inputPath, outputPath string, cfg *config.Config) error {
+// If subvault is set, the key of that sub-vault is used instead. With header, the output
+// starts with a metadata header (see vault.WithFileHeader) recording contentType, detected
+// from the input if empty, and the input file name.
+func RunEncrypt(inputPath, outputPath string, subvault string, header bool, contentType string, cfg *config.Config) error {
 	// Load and decrypt vault key
-	vaultKey, err := keymgmt.VaultKeyFromIdentityFile(cfg.IdentityFile, cfg.VaultKeyFile)
-	if err != nil {
-		return fmt.Errorf("failed to load vault key: %w", err)
+	var vaultKey *vault.VaultKey
+	var err error
+	if subvault != "" {
+		vaultKey, err = openSubvault(subvault, cfg)
+		if err != nil {
+			return err
+		}
+	} else {
+		vaultKey, err = keymgmt.VaultKeyFromIdentityFile(cfg.IdentityFile, cfg.VaultKeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load vault key: %w", err)
+		}
 	}
 
 	// Open input (file or stdin)
@@ -48,10 +65,37 @@ func RunEncrypt(inputPath, outputPath string, cfg *config.Config) error {
 		output = f
 	}
 
+	var options []vault.EncryptOption
+	if subvault != "" {
+		options = append(options, vault.WithSubvault(subvault))
+	}
+	if header {
+		filename := ""
+		if inputPath != "" {
+			filename = filepath.Base(inputPath)
+		}
+		if contentType == "" {
+			bufferedInput := bufio.NewReader(input)
+			contentType = detectContentType(filename, bufferedInput)
+			input = bufferedInput
+		}
+		options = append(options, vault.WithFileHeader(contentType, filename))
+	}
+
 	// Encrypt data
-	if err := vaultKey.Encrypt(input, output); err != nil {
+	if err := vaultKey.Encrypt(input, output, options...); err != nil {
 		return fmt.Errorf("failed to encrypt data: %w", err)
 	}
 
 	return nil
 }
+
+// detectContentType guesses the MIME type of the input from its file name extension, or from
+// its first bytes if the extension is unknown.
+func detectContentType(filename string, input *bufio.Reader) string {
+	if contentType := mime.TypeByExtension(filepath.Ext(filename)); contentType != "" {
+		return contentType
+	}
+	start, _ := input.Peek(512)
+	return http.DetectContentType(start)
+}
@@ -0,0 +1,176 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/leolimasa/age-vault/config"
+	"github.com/leolimasa/age-vault/enroll"
+	"github.com/leolimasa/age-vault/keymgmt"
+	"github.com/leolimasa/age-vault/members"
+)
+
+// RunEnrollRequest handles the enroll request command.
+// It writes an enrollment request containing this machine's public key, hostname and
+// verification code, to be approved by an existing member of the vault.
+func RunEnrollRequest(outputPath string, cfg *config.Config) error {
+	recipient, err := keymgmt.ExtractRecipientString(cfg.IdentityFile)
+	if err != nil {
+		return fmt.Errorf("failed to extract public key from identity: %w", err)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("failed to get hostname: %w", err)
+	}
+
+	if outputPath == "" {
+		outputPath = hostname + ".enroll-request.yml"
+	}
+
+	request := enroll.NewRequest(recipient, hostname)
+	if err := enroll.WriteRequest(outputPath, request); err != nil {
+		return fmt.Errorf("failed to write enrollment request: %w", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Enrollment request saved to %s\n", outputPath)
+	fmt.Fprintf(os.Stderr, "Verification code: %s\n", request.Code)
+	fmt.Fprintf(os.Stderr, "Send the request to an existing member and confirm they see the same code when running 'age-vault enroll approve'.\n")
+	return nil
+}
+
+// RunEnrollApprove handles the enroll approve command.
+// It checks the request's verification code, asks the user to confirm it matches the one shown
+// on the new machine (unless yes is set), and writes a response containing the vault key
+// encrypted for the new machine. If memberName is set, the new machine is first added to the
+// recipients registry, and no response is written if that fails.
+func RunEnrollApprove(requestPath string, outputPath string, memberName string, yes bool, cfg *config.Config) error {
+	request, err := enroll.ReadRequest(requestPath)
+	if err != nil {
+		return fmt.Errorf("failed to read enrollment request: %w", err)
+	}
+
+	if err := request.Verify(); err != nil {
+		return err
+	}
+
+	recipients, err := keymgmt.ParseRecipients([]byte(request.Recipient))
+	if err != nil {
+		return fmt.Errorf("failed to parse public key in enrollment request: %w", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Hostname:          %s\n", request.Hostname)
+	fmt.Fprintf(os.Stderr, "Public key:        %s\n", request.Recipient)
+	fmt.Fprintf(os.Stderr, "Verification code: %s\n", request.Code)
+
+	if !yes {
+		ok, err := confirm("Does the verification code match the one shown on the new machine?")
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("enrollment not approved")
+		}
+	}
+
+	// Encrypt the whole keyring for the new machine
+	vaultKey, err := keymgmt.VaultKeyFromIdentityFile(cfg.IdentityFile, cfg.VaultKeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load vault key: %w", err)
+	}
+
+	encryptedKey, err := encryptVaultKeyring(vaultKey, request.Hostname, cfg, recipients[0])
+	if err != nil {
+		return fmt.Errorf("failed to encrypt vault key for new machine: %w", err)
+	}
+
+	response, err := enroll.NewResponse(request, encryptedKey)
+	if err != nil {
+		return err
+	}
+
+	// Record the new machine in the recipients registry before handing out the vault key,
+	// so a rejected member (e.g. a duplicate name) never gets a response
+	if memberName != "" {
+		if err := registerEnrolledMember(memberName, request.Recipient, cfg); err != nil {
+			return err
+		}
+	}
+
+	if outputPath == "" {
+		outputPath = enrollResponseName(request.Hostname, memberName) + ".enroll-response.yml"
+	}
+	if err := enroll.WriteResponse(outputPath, response); err != nil {
+		return fmt.Errorf("failed to write enrollment response: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "Enrollment response saved to %s\n", outputPath)
+
+	fmt.Fprintf(os.Stderr, "Send the response back and run 'age-vault enroll complete' on the new machine.\n")
+	return nil
+}
+
+// unsafeFileNameChars matches the characters replaced in file names derived from requests.
+var unsafeFileNameChars = regexp.MustCompile(`[^A-Za-z0-9._@-]`)
+
+// enrollResponseName returns the base name of the default response file: the member name if
+// given, otherwise the hostname of the request, which is untrusted, made safe to use as a
+// file name in the current directory.
+func enrollResponseName(hostname string, memberName string) string {
+	name := hostname
+	if memberName != "" {
+		name = memberName
+	}
+	name = strings.TrimLeft(unsafeFileNameChars.ReplaceAllString(name, "_"), ".")
+	if name == "" {
+		return "enroll"
+	}
+	return name
+}
+
+// RunEnrollComplete handles the enroll complete command.
+// It checks that the response was made for this machine and installs the vault key like
+// vault-key set, which verifies that it decrypts with our identity.
+func RunEnrollComplete(responsePath string, force bool, cfg *config.Config) error {
+	response, err := enroll.ReadResponse(responsePath)
+	if err != nil {
+		return fmt.Errorf("failed to read enrollment response: %w", err)
+	}
+
+	recipient, err := keymgmt.ExtractRecipientString(cfg.IdentityFile)
+	if err != nil {
+		return fmt.Errorf("failed to extract public key from identity: %w", err)
+	}
+	if response.Recipient != recipient {
+		return fmt.Errorf("enrollment response was made for public key %s, not for this machine (%s)", response.Recipient, recipient)
+	}
+
+	encryptedKey, err := response.EncryptedVaultKey()
+	if err != nil {
+		return err
+	}
+
+	if err := setVaultKey(encryptedKey, force, cfg); err != nil {
+		return err
+	}
+
+	fmt.Printf("Vault key set to: %s\n", cfg.VaultKeyFile)
+	return nil
+}
+
+// registerEnrolledMember adds a newly enrolled machine to the recipients registry.
+func registerEnrolledMember(name string, recipient string, cfg *config.Config) error {
+	registry, err := members.Load(cfg.RecipientsFile)
+	if err != nil {
+		return fmt.Errorf("failed to load recipients registry: %w", err)
+	}
+	if err := registry.Add(name, recipient); err != nil {
+		return fmt.Errorf("failed to add member: %w", err)
+	}
+	if err := registry.Save(cfg.RecipientsFile); err != nil {
+		return fmt.Errorf("failed to save recipients registry: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "Member %s added to %s\n", name, cfg.RecipientsFile)
+	return nil
+}
@@ -0,0 +1,139 @@
+package commands
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/leolimasa/age-vault/config"
+	"github.com/leolimasa/age-vault/enroll"
+	"github.com/leolimasa/age-vault/keymgmt"
+)
+
+// enrollTimeout bounds how long a network enrollment exchange may take once connected.
+const enrollTimeout = 2 * time.Minute
+
+// RunEnrollServe handles the enroll serve command.
+// It listens on listenAddr for a single machine running enroll join with the printed code,
+// and sends it the vault key encrypted for its public key. If memberName is set, the new
+// machine is also added to the recipients registry.
+func RunEnrollServe(listenAddr string, memberName string, cfg *config.Config) error {
+	code, err := enroll.NewCode()
+	if err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", listenAddr, err)
+	}
+	defer listener.Close()
+
+	fmt.Fprintf(os.Stderr, "Waiting for a new machine on %s\n", listener.Addr())
+	fmt.Fprintf(os.Stderr, "Run this on the new machine:\n")
+	for _, addr := range enrollAddresses(listener.Addr()) {
+		fmt.Fprintf(os.Stderr, "  age-vault enroll join --addr %s %s\n", addr, code)
+	}
+
+	return serveEnrollment(listener, code, memberName, cfg)
+}
+
+// serveEnrollment accepts one connection on listener and runs the serving side of the
+// enrollment. Only a single attempt is allowed, so a wrong code cannot be retried.
+func serveEnrollment(listener net.Listener, code string, memberName string, cfg *config.Config) error {
+	// Load the vault key before accepting connections so configuration errors show up early
+	vaultKey, err := keymgmt.VaultKeyFromIdentityFile(cfg.IdentityFile, cfg.VaultKeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load vault key: %w", err)
+	}
+
+	conn, err := listener.Accept()
+	if err != nil {
+		return fmt.Errorf("failed to accept connection: %w", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(enrollTimeout))
+
+	request, err := enroll.Serve(conn, code, func(request *enroll.Request) ([]byte, error) {
+		recipients, err := keymgmt.ParseRecipients([]byte(request.Recipient))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse public key of new machine: %w", err)
+		}
+		encryptedKey, err := encryptVaultKeyring(vaultKey, request.Hostname, cfg, recipients[0])
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt vault key for new machine: %w", err)
+		}
+		// Register the new machine before the vault key is sent, so a rejected member never gets it
+		if memberName != "" {
+			if err := registerEnrolledMember(memberName, request.Recipient, cfg); err != nil {
+				return nil, err
+			}
+		}
+		return encryptedKey, nil
+	})
+	if err != nil {
+		return fmt.Errorf("enrollment from %s failed: %w", conn.RemoteAddr(), err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Vault key sent to %s (%s)\n", request.Hostname, request.Recipient)
+	return nil
+}
+
+// RunEnrollJoin handles the enroll join command.
+// It connects to a machine running enroll serve, authenticates with the code it printed,
+// and installs the received vault key like vault-key set.
+func RunEnrollJoin(addr string, code string, force bool, cfg *config.Config) error {
+	recipient, err := keymgmt.ExtractRecipientString(cfg.IdentityFile)
+	if err != nil {
+		return fmt.Errorf("failed to extract public key from identity: %w", err)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("failed to get hostname: %w", err)
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, 30*time.Second)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(enrollTimeout))
+
+	encryptedKey, err := enroll.Join(conn, code, enroll.NewRequest(recipient, hostname))
+	if err != nil {
+		return fmt.Errorf("enrollment failed: %w", err)
+	}
+
+	if err := setVaultKey(encryptedKey, force, cfg); err != nil {
+		return err
+	}
+
+	fmt.Printf("Vault key set to: %s\n", cfg.VaultKeyFile)
+	return nil
+}
+
+// enrollAddresses lists the addresses a new machine can use to reach the listener.
+// When listening on all interfaces, every non-loopback interface address is listed.
+func enrollAddresses(listenAddr net.Addr) []string {
+	tcpAddr, ok := listenAddr.(*net.TCPAddr)
+	if !ok || !tcpAddr.IP.IsUnspecified() {
+		return []string{listenAddr.String()}
+	}
+
+	var addrs []string
+	if interfaceAddrs, err := net.InterfaceAddrs(); err == nil {
+		for _, a := range interfaceAddrs {
+			ipNet, ok := a.(*net.IPNet)
+			if !ok || ipNet.IP.IsLoopback() || ipNet.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			addrs = append(addrs, net.JoinHostPort(ipNet.IP.String(), fmt.Sprint(tcpAddr.Port)))
+		}
+	}
+	if len(addrs) == 0 {
+		addrs = append(addrs, net.JoinHostPort("127.0.0.1", fmt.Sprint(tcpAddr.Port)))
+	}
+	return addrs
+}
@@ -0,0 +1,220 @@
+package commands
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+
+	"github.com/leolimasa/age-vault/config"
+	"github.com/leolimasa/age-vault/enroll"
+	"github.com/leolimasa/age-vault/keymgmt"
+	"github.com/leolimasa/age-vault/members"
+)
+
+// writeTestIdentity generates an identity and writes it to path.
+func writeTestIdentity(t *testing.T, path string) *age.X25519Identity {
+	t.Helper()
+
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("failed to generate test identity: %v", err)
+	}
+	if err := config.EnsureParentDir(path); err != nil {
+		t.Fatalf("failed to create identity dir: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(identity.String()+"\n"), 0600); err != nil {
+		t.Fatalf("failed to write identity file: %v", err)
+	}
+	return identity
+}
+
+func TestEnrollWorkflow(t *testing.T) {
+	tempDir := t.TempDir()
+
+	// Existing member with a vault key
+	memberIdentityPath := filepath.Join(tempDir, "member", "identity.txt")
+	memberIdentity := writeTestIdentity(t, memberIdentityPath)
+	memberCfg := &config.Config{
+		IdentityFile:   memberIdentityPath,
+		VaultKeyFile:   filepath.Join(tempDir, "member", "vault_key.age"),
+		RecipientsFile: filepath.Join(tempDir, "member", config.RecipientsFileName),
+	}
+	vaultKey := writeTestVaultKey(t, memberIdentity, memberCfg.VaultKeyFile)
+	vaultKeyIdentity, _ := vaultKey.GetIdentity()
+
+	// New machine with only an identity
+	newIdentityPath := filepath.Join(tempDir, "new", "identity.txt")
+	newIdentity := writeTestIdentity(t, newIdentityPath)
+	newCfg := &config.Config{
+		IdentityFile: newIdentityPath,
+		VaultKeyFile: filepath.Join(tempDir, "new", "vault_key.age"),
+	}
+
+	requestPath := filepath.Join(tempDir, "request.yml")
+	responsePath := filepath.Join(tempDir, "response.yml")
+
+	if err := RunEnrollRequest(requestPath, newCfg); err != nil {
+		t.Fatalf("RunEnrollRequest failed: %v", err)
+	}
+	if err := RunEnrollApprove(requestPath, responsePath, "new@machine", true, memberCfg); err != nil {
+		t.Fatalf("RunEnrollApprove failed: %v", err)
+	}
+	if err := RunEnrollComplete(responsePath, false, newCfg); err != nil {
+		t.Fatalf("RunEnrollComplete failed: %v", err)
+	}
+
+	// The new machine can now load the same vault key
+	installed, err := keymgmt.VaultKeyFromIdentityFile(newCfg.IdentityFile, newCfg.VaultKeyFile)
+	if err != nil {
+		t.Fatalf("failed to load installed vault key: %v", err)
+	}
+	installedIdentity, _ := installed.GetIdentity()
+	if installedIdentity.(*age.X25519Identity).String() != vaultKeyIdentity.(*age.X25519Identity).String() {
+		t.Error("installed vault key differs from the member's vault key")
+	}
+
+	// The new machine was registered
+	registry, err := members.Load(memberCfg.RecipientsFile)
+	if err != nil {
+		t.Fatalf("failed to load registry: %v", err)
+	}
+	member, ok := registry.Get("new@machine")
+	if !ok {
+		t.Fatal("expected new machine to be registered")
+	}
+	if member.Recipient != newIdentity.Recipient().String() {
+		t.Errorf("registered recipient = %s, want %s", member.Recipient, newIdentity.Recipient().String())
+	}
+
+	// A response for another machine is rejected
+	otherIdentityPath := filepath.Join(tempDir, "other-identity.txt")
+	writeTestIdentity(t, otherIdentityPath)
+	otherCfg := &config.Config{
+		IdentityFile: otherIdentityPath,
+		VaultKeyFile: filepath.Join(tempDir, "other_vault_key.age"),
+	}
+	if err := RunEnrollComplete(responsePath, false, otherCfg); err == nil {
+		t.Error("expected response for another machine to be rejected")
+	}
+	if _, err := os.Stat(otherCfg.VaultKeyFile); !os.IsNotExist(err) {
+		t.Error("vault key should not be installed for another machine")
+	}
+}
+
+func TestRunEnrollApprove_RegistrationFails(t *testing.T) {
+	tempDir := t.TempDir()
+
+	memberIdentityPath := filepath.Join(tempDir, "member", "identity.txt")
+	memberIdentity := writeTestIdentity(t, memberIdentityPath)
+	memberCfg := &config.Config{
+		IdentityFile:   memberIdentityPath,
+		VaultKeyFile:   filepath.Join(tempDir, "member", "vault_key.age"),
+		RecipientsFile: filepath.Join(tempDir, "member", config.RecipientsFileName),
+	}
+	writeTestVaultKey(t, memberIdentity, memberCfg.VaultKeyFile)
+
+	// The member name is already taken
+	registry := &members.Registry{}
+	if err := registry.Add("laptop", memberIdentity.Recipient().String()); err != nil {
+		t.Fatalf("failed to add member: %v", err)
+	}
+	if err := registry.Save(memberCfg.RecipientsFile); err != nil {
+		t.Fatalf("failed to save registry: %v", err)
+	}
+
+	newIdentityPath := filepath.Join(tempDir, "new", "identity.txt")
+	writeTestIdentity(t, newIdentityPath)
+	requestPath := filepath.Join(tempDir, "request.yml")
+	if err := RunEnrollRequest(requestPath, &config.Config{IdentityFile: newIdentityPath}); err != nil {
+		t.Fatalf("RunEnrollRequest failed: %v", err)
+	}
+
+	responsePath := filepath.Join(tempDir, "response.yml")
+	if err := RunEnrollApprove(requestPath, responsePath, "laptop", true, memberCfg); err == nil {
+		t.Fatal("expected error for a duplicate member name")
+	}
+	if _, err := os.Stat(responsePath); !os.IsNotExist(err) {
+		t.Error("the vault key was handed out although the member was not registered")
+	}
+}
+
+func TestEnrollResponseName(t *testing.T) {
+	tests := []struct {
+		hostname   string
+		memberName string
+		want       string
+	}{
+		{"laptop", "", "laptop"},
+		{"laptop", "alice@laptop", "alice@laptop"},
+		{"../../etc/cron.d/x", "", "_.._etc_cron.d_x"},
+		{"..", "", "enroll"},
+		{"", "", "enroll"},
+	}
+	for _, tt := range tests {
+		if got := enrollResponseName(tt.hostname, tt.memberName); got != tt.want {
+			t.Errorf("enrollResponseName(%q, %q) = %q, want %q", tt.hostname, tt.memberName, got, tt.want)
+		}
+	}
+}
+
+func TestEnrollServeJoin(t *testing.T) {
+	tempDir := t.TempDir()
+
+	memberIdentity := writeTestIdentity(t, filepath.Join(tempDir, "member", "identity.txt"))
+	memberCfg := &config.Config{
+		IdentityFile:   filepath.Join(tempDir, "member", "identity.txt"),
+		VaultKeyFile:   filepath.Join(tempDir, "member", "vault_key.age"),
+		RecipientsFile: filepath.Join(tempDir, "member", config.RecipientsFileName),
+	}
+	vaultKey := writeTestVaultKey(t, memberIdentity, memberCfg.VaultKeyFile)
+	vaultKeyIdentity, _ := vaultKey.GetIdentity()
+
+	writeTestIdentity(t, filepath.Join(tempDir, "new", "identity.txt"))
+	newCfg := &config.Config{
+		IdentityFile: filepath.Join(tempDir, "new", "identity.txt"),
+		VaultKeyFile: filepath.Join(tempDir, "new", "vault_key.age"),
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	code, err := enroll.NewCode()
+	if err != nil {
+		t.Fatalf("failed to generate code: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- serveEnrollment(listener, code, "new@machine", memberCfg)
+	}()
+
+	if err := RunEnrollJoin(listener.Addr().String(), code, false, newCfg); err != nil {
+		t.Fatalf("RunEnrollJoin failed: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("serveEnrollment failed: %v", err)
+	}
+
+	installed, err := keymgmt.VaultKeyFromIdentityFile(newCfg.IdentityFile, newCfg.VaultKeyFile)
+	if err != nil {
+		t.Fatalf("failed to load installed vault key: %v", err)
+	}
+	installedIdentity, _ := installed.GetIdentity()
+	if installedIdentity.(*age.X25519Identity).String() != vaultKeyIdentity.(*age.X25519Identity).String() {
+		t.Error("installed vault key differs from the member's vault key")
+	}
+
+	registry, err := members.Load(memberCfg.RecipientsFile)
+	if err != nil {
+		t.Fatalf("failed to load registry: %v", err)
+	}
+	if _, ok := registry.Get("new@machine"); !ok {
+		t.Error("expected new machine to be registered")
+	}
+}
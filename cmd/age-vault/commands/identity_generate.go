@@ -0,0 +1,171 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"github.com/leolimasa/age-vault/config"
+	"github.com/leolimasa/age-vault/keymgmt"
+)
+
+// nativeIdentityPlugin selects a native age identity instead of a plugin.
+const nativeIdentityPlugin = "native"
+
+// identityPlugin describes how to generate an identity with an age plugin binary, which
+// prints the identity file on stdout, and how to convert it to its recipient if the identity
+// file doesn't mention it.
+type identityPlugin struct {
+	Name        string
+	Binary      string
+	Args        []string
+	Convert     []string // Reads the identity on stdin and prints its recipient
+	Description string
+}
+
+// identityPlugins are the age plugins that can generate identities, in order of preference.
+var identityPlugins = []identityPlugin{
+	{Name: "tpm", Binary: "age-plugin-tpm", Args: []string{"--generate"}, Convert: []string{"--convert"}, Description: "key sealed in the TPM of this machine"},
+	{Name: "yubikey", Binary: "age-plugin-yubikey", Args: []string{"--generate"}, Description: "key stored on a YubiKey"},
+	{Name: "se", Binary: "age-plugin-se", Args: []string{"keygen"}, Convert: []string{"recipients"}, Description: "key stored in the Secure Enclave of this Mac"},
+}
+
+// findIdentityPlugin returns the identity plugin with the given name.
+func findIdentityPlugin(name string) (identityPlugin, error) {
+	var names []string
+	for _, p := range identityPlugins {
+		if p.Name == name {
+			return p, nil
+		}
+		names = append(names, p.Name)
+	}
+	return identityPlugin{}, fmt.Errorf("unknown identity plugin %q (expected %s or one of %v)", name, nativeIdentityPlugin, names)
+}
+
+// detectIdentityPlugins returns the identity plugins whose binary is on PATH.
+func detectIdentityPlugins() []identityPlugin {
+	var detected []identityPlugin
+	for _, p := range identityPlugins {
+		if _, err := exec.LookPath(p.Binary); err == nil {
+			detected = append(detected, p)
+		}
+	}
+	return detected
+}
+
+// RunIdentityGenerate handles the identity generate command.
+// It generates a new identity with pluginName ("native" or an age plugin such as tpm) and
+// saves it to the configured identity location, with its public key recorded in a
+// "# public key:" comment so identity pubkey works for plugin identities too. An existing
+// identity is only replaced with force, and kept as a backup.
+func RunIdentityGenerate(pluginName string, force bool, cfg *config.Config) error {
+	if pluginName == "" {
+		pluginName = nativeIdentityPlugin
+	}
+
+	_, err := os.Stat(cfg.IdentityFile)
+	exists := err == nil
+	if exists && !force {
+		return fmt.Errorf("identity %s already exists; replacing it loses access to vault keys encrypted for it (use --force to replace it, a backup is kept)", cfg.IdentityFile)
+	}
+
+	content, err := generateIdentity(pluginName)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		backupPath, err := keymgmt.BackupFile(cfg.IdentityFile)
+		if err != nil {
+			return fmt.Errorf("failed to back up identity: %w", err)
+		}
+		fmt.Fprintf(os.Stderr, "Previous identity backed up to %s\n", backupPath)
+	}
+	if err := keymgmt.WriteFileAtomic(cfg.IdentityFile, content); err != nil {
+		return fmt.Errorf("failed to write identity: %w", err)
+	}
+
+	recipient, err := keymgmt.ExtractRecipientString(cfg.IdentityFile)
+	if err != nil {
+		return fmt.Errorf("failed to read public key of generated identity: %w", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Identity (%s) saved to: %s\n", pluginName, cfg.IdentityFile)
+	fmt.Println(recipient)
+	return nil
+}
+
+// generateIdentity generates a new identity with the named plugin, or a native X25519
+// identity for "native", and returns the content of its identity file, which always records
+// the public key. Plugins may prompt for a PIN or a touch on the terminal.
+func generateIdentity(pluginName string) ([]byte, error) {
+	if pluginName == nativeIdentityPlugin {
+		identity, err := age.GenerateX25519Identity()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate identity: %w", err)
+		}
+		content := fmt.Sprintf("# created: %s\n# public key: %s\n%s\n",
+			time.Now().Format(time.RFC3339), identity.Recipient(), identity)
+		return []byte(content), nil
+	}
+
+	p, err := findIdentityPlugin(pluginName)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := exec.LookPath(p.Binary); err != nil {
+		return nil, fmt.Errorf("%s is not on PATH, install it to generate %s identities", p.Binary, p.Name)
+	}
+
+	var stdout bytes.Buffer
+	cmd := exec.Command(p.Binary, p.Args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("failed to run %s: %w", p.Binary, err)
+	}
+	if stdout.Len() == 0 {
+		return nil, fmt.Errorf("%s did not output an identity", p.Binary)
+	}
+
+	content := stdout.Bytes()
+	if keymgmt.RecipientFromComments(content) != "" {
+		return content, nil
+	}
+
+	// Record the public key, which the plugin didn't mention
+	recipient, err := convertIdentity(p, content)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte("# public key: "+recipient+"\n"), content...), nil
+}
+
+// convertIdentity returns the recipient of an identity generated by a plugin.
+func convertIdentity(p identityPlugin, identity []byte) (string, error) {
+	if len(p.Convert) == 0 {
+		return "", fmt.Errorf("%s did not output the public key of the identity", p.Binary)
+	}
+
+	var stdout bytes.Buffer
+	cmd := exec.Command(p.Binary, p.Convert...)
+	cmd.Stdin = bytes.NewReader(identity)
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("failed to get the public key with %s: %w", p.Binary, err)
+	}
+
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "age1") {
+			return line, nil
+		}
+	}
+	return "", fmt.Errorf("%s did not output a public key", p.Binary)
+}
@@ -0,0 +1,94 @@
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"filippo.io/age/plugin"
+
+	"github.com/leolimasa/age-vault/config"
+	"github.com/leolimasa/age-vault/keymgmt"
+)
+
+func TestRunIdentityGenerate_Native(t *testing.T) {
+	identityPath := filepath.Join(t.TempDir(), "identity.txt")
+	cfg := &config.Config{IdentityFile: identityPath}
+
+	if err := RunIdentityGenerate("", false, cfg); err != nil {
+		t.Fatalf("RunIdentityGenerate failed: %v", err)
+	}
+	first, err := keymgmt.ExtractRecipientString(identityPath)
+	if err != nil {
+		t.Fatalf("failed to read public key: %v", err)
+	}
+
+	// An existing identity is only replaced with force, and backed up
+	if err := RunIdentityGenerate("native", false, cfg); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("expected error for an existing identity, got: %v", err)
+	}
+	if err := RunIdentityGenerate("native", true, cfg); err != nil {
+		t.Fatalf("RunIdentityGenerate with force failed: %v", err)
+	}
+	second, err := keymgmt.ExtractRecipientString(identityPath)
+	if err != nil {
+		t.Fatalf("failed to read public key: %v", err)
+	}
+	if first == second {
+		t.Error("identity was not replaced")
+	}
+	backups, _ := filepath.Glob(identityPath + ".*.bak")
+	if len(backups) != 1 {
+		t.Errorf("expected one backup, got %v", backups)
+	}
+
+	if err := RunIdentityGenerate("unknown", true, cfg); err == nil || !strings.Contains(err.Error(), "unknown identity plugin") {
+		t.Errorf("expected error for an unknown plugin, got: %v", err)
+	}
+}
+
+func TestRunIdentityGenerate_Plugin(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake plugin is a shell script")
+	}
+
+	// A fake TPM plugin whose identity file doesn't mention the public key
+	identity := plugin.EncodeIdentity("tpm", []byte("identity data"))
+	recipient := plugin.EncodeRecipient("tpm", []byte("recipient data"))
+	binDir := t.TempDir()
+	script := "#!/bin/sh\ncase \"$1\" in\n" +
+		"--generate) echo '# Created: today'; echo '" + identity + "' ;;\n" +
+		"--convert) grep -q '" + identity + "' && echo '" + recipient + "' ;;\n" +
+		"esac\n"
+	if err := os.WriteFile(filepath.Join(binDir, "age-plugin-tpm"), []byte(script), 0700); err != nil {
+		t.Fatalf("failed to write fake plugin: %v", err)
+	}
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	if detected := detectIdentityPlugins(); len(detected) == 0 || detected[0].Name != "tpm" {
+		t.Errorf("expected the tpm plugin to be detected, got %v", detected)
+	}
+
+	identityPath := filepath.Join(t.TempDir(), "identity.txt")
+	if err := RunIdentityGenerate("tpm", false, &config.Config{IdentityFile: identityPath}); err != nil {
+		t.Fatalf("RunIdentityGenerate failed: %v", err)
+	}
+
+	// The public key was recorded, so it can be extracted
+	got, err := keymgmt.ExtractRecipientString(identityPath)
+	if err != nil {
+		t.Fatalf("ExtractRecipientString failed: %v", err)
+	}
+	if got != recipient {
+		t.Errorf("expected %s, got %s", recipient, got)
+	}
+	content, err := os.ReadFile(identityPath)
+	if err != nil {
+		t.Fatalf("failed to read identity: %v", err)
+	}
+	if !strings.Contains(string(content), identity) {
+		t.Errorf("identity missing from the identity file:\n%s", content)
+	}
+}
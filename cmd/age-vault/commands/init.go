@@ -0,0 +1,187 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/leolimasa/age-vault/config"
+	"github.com/leolimasa/age-vault/fingerprint"
+	"github.com/leolimasa/age-vault/keymgmt"
+	"golang.org/x/term"
+	"gopkg.in/yaml.v3"
+)
+
+// initVaultKeyFileName is the name of the vault key file created by init in the project.
+const initVaultKeyFileName = "vault_key.age"
+
+// initGitattributes are the .gitattributes entries written by init: encrypted files are
+// binary, and status checks that they stay encrypted.
+var initGitattributes = []string{"*.age binary age-vault"}
+
+// initConfig is the age_vault.yml written by init.
+type initConfig struct {
+	VaultKeyFile string `yaml:"vault_key_file"`
+	IdentityFile string `yaml:"identity_file"`
+}
+
+// RunInit handles the init command.
+// It bootstraps a vault in dir (the current directory by default): it selects the identity
+// at identityPath (the configured identity by default), or creates it with pluginName
+// ("native" or an age plugin, asked interactively if empty), creates the vault key, writes
+// age_vault.yml with paths relative to dir, adds the per-user files to .gitignore and the
+// encrypted files to .gitattributes, and prints the vault key fingerprint.
+func RunInit(dir string, identityPath string, pluginName string, keyType string, cfg *config.Config) error {
+	if dir == "" {
+		dir = "."
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", dir, err)
+	}
+
+	configPath := filepath.Join(dir, "age_vault.yml")
+	if _, err := os.Stat(configPath); err == nil {
+		return fmt.Errorf("%s already exists", configPath)
+	}
+
+	// Select or create the identity
+	if identityPath == "" {
+		identityPath = cfg.IdentityFile
+	}
+	identityPath, err = filepath.Abs(identityPath)
+	if err != nil {
+		return fmt.Errorf("failed to resolve identity path: %w", err)
+	}
+	if _, err := os.Stat(identityPath); err == nil {
+		if pluginName != "" {
+			return fmt.Errorf("identity %s already exists (omit --plugin to use it)", identityPath)
+		}
+		fmt.Fprintf(os.Stderr, "Using identity: %s\n", identityPath)
+	} else if os.IsNotExist(err) {
+		if err := createInitIdentity(identityPath, pluginName); err != nil {
+			return err
+		}
+	} else {
+		return fmt.Errorf("failed to access identity: %w", err)
+	}
+	if _, err := keymgmt.LoadIdentities(identityPath); err != nil {
+		return fmt.Errorf("failed to load identity: %w", err)
+	}
+
+	// The vault of the project, independent of any other age_vault.yml
+	projectCfg := &config.Config{
+		ConfigDir:      dir,
+		IdentityFile:   identityPath,
+		VaultKeyFile:   filepath.Join(dir, initVaultKeyFileName),
+		RecipientsFile: filepath.Join(dir, config.RecipientsFileName),
+		SubvaultsDir:   filepath.Join(dir, config.SubvaultsDirName),
+	}
+	if _, err := loadOrCreateVaultKey(keyType, projectCfg); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Vault key saved to: %s\n", projectCfg.VaultKeyFile)
+
+	content, err := yaml.Marshal(initConfig{
+		VaultKeyFile: config.RelativeConfigPath(projectCfg.VaultKeyFile, dir),
+		IdentityFile: config.RelativeConfigPath(identityPath, dir),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to serialize config: %w", err)
+	}
+	if err := os.WriteFile(configPath, content, 0644); err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "Config saved to: %s\n", configPath)
+
+	// The vault key (and its backups) and an identity inside the project are per user
+	ignored := []string{"/" + initVaultKeyFileName, "/" + initVaultKeyFileName + ".*.bak"}
+	if rel, err := filepath.Rel(dir, identityPath); err == nil && !strings.HasPrefix(rel, "..") {
+		ignored = append(ignored, "/"+filepath.ToSlash(rel))
+	}
+	if err := appendGitEntries(filepath.Join(dir, ".gitignore"), ignored); err != nil {
+		return err
+	}
+	if err := appendGitEntries(filepath.Join(dir, ".gitattributes"), initGitattributes); err != nil {
+		return err
+	}
+
+	recipient, err := vaultKeyRecipientString(projectCfg)
+	if err != nil {
+		return err
+	}
+	f := fingerprint.Of(recipient)
+	fmt.Fprintf(os.Stderr, "Vault key fingerprint (members can check it with 'age-vault vault-key verify'):\n")
+	fmt.Printf("Hex:   %s\n", f.Hex())
+	fmt.Printf("Words: %s\n", f.Words())
+
+	return nil
+}
+
+// createInitIdentity generates an identity at path with pluginName, asking which plugin to
+// use on a terminal if pluginName is empty and plugins are installed.
+func createInitIdentity(path string, pluginName string) error {
+	if pluginName == "" {
+		pluginName = nativeIdentityPlugin
+
+		detected := detectIdentityPlugins()
+		if len(detected) > 0 && term.IsTerminal(int(os.Stdin.Fd())) {
+			options := []string{"native: age key stored in " + path}
+			for _, p := range detected {
+				options = append(options, fmt.Sprintf("%s: %s (%s)", p.Name, p.Description, p.Binary))
+			}
+			choice, err := choose("Create a new identity:", options)
+			if err != nil {
+				return err
+			}
+			if choice > 0 {
+				pluginName = detected[choice-1].Name
+			}
+		}
+	}
+
+	content, err := generateIdentity(pluginName)
+	if err != nil {
+		return err
+	}
+	if err := keymgmt.WriteFileAtomic(path, content); err != nil {
+		return fmt.Errorf("failed to write identity: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "Identity (%s) created at: %s\n", pluginName, path)
+	return nil
+}
+
+// appendGitEntries appends the entries missing from a .gitignore or .gitattributes file,
+// creating it if needed.
+func appendGitEntries(path string, entries []string) error {
+	existing, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	present := map[string]bool{}
+	for _, line := range strings.Split(string(existing), "\n") {
+		present[strings.TrimSpace(line)] = true
+	}
+	var missing []string
+	for _, entry := range entries {
+		if !present[entry] {
+			missing = append(missing, entry)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	content := string(existing)
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	content += "# age-vault\n" + strings.Join(missing, "\n") + "\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	fmt.Fprintf(os.Stderr, "Updated %s\n", path)
+	return nil
+}
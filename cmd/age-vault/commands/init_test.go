@@ -0,0 +1,99 @@
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/leolimasa/age-vault/config"
+)
+
+func TestRunInit(t *testing.T) {
+	for _, name := range []string{"AGE_VAULT_KEY_FILE", "AGE_VAULT_IDENTITY_FILE", "AGE_VAULT_NAME", "AGE_VAULT_RECIPIENTS_FILE", "AGE_VAULT_SUBVAULTS_DIR"} {
+		t.Setenv(name, "")
+	}
+
+	tempDir := t.TempDir()
+	projectDir := filepath.Join(tempDir, "project")
+	if err := os.MkdirAll(projectDir, 0700); err != nil {
+		t.Fatalf("failed to create project: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(projectDir, ".gitignore"), []byte("node_modules"), 0644); err != nil {
+		t.Fatalf("failed to write .gitignore: %v", err)
+	}
+	identityPath := filepath.Join(tempDir, "keys", "identity.txt")
+
+	if err := RunInit(projectDir, identityPath, "native", "", &config.Config{}); err != nil {
+		t.Fatalf("RunInit failed: %v", err)
+	}
+
+	// The config resolves to the files created by init
+	oldWd, _ := os.Getwd()
+	if err := os.Chdir(projectDir); err != nil {
+		t.Fatalf("failed to change directory: %v", err)
+	}
+	defer os.Chdir(oldWd)
+
+	cfg, err := config.NewConfig()
+	if err != nil {
+		t.Fatalf("NewConfig failed: %v", err)
+	}
+	if cfg.IdentityFile != identityPath {
+		t.Errorf("expected identity %s, got %s", identityPath, cfg.IdentityFile)
+	}
+	if cfg.VaultKeyFile != filepath.Join(projectDir, "vault_key.age") {
+		t.Errorf("unexpected vault key file %s", cfg.VaultKeyFile)
+	}
+	content, err := os.ReadFile(filepath.Join(projectDir, "age_vault.yml"))
+	if err != nil {
+		t.Fatalf("failed to read config: %v", err)
+	}
+	if !strings.Contains(string(content), "vault_key_file: vault_key.age\n") {
+		t.Errorf("expected a relative vault key path, got:\n%s", content)
+	}
+
+	// The new vault works
+	if err := RunDoctor(""); err != nil {
+		t.Errorf("RunDoctor failed on a new vault: %v", err)
+	}
+	if err := RunStatus("", cfg); err != nil {
+		t.Errorf("RunStatus failed on a new vault: %v", err)
+	}
+
+	gitignore, err := os.ReadFile(filepath.Join(projectDir, ".gitignore"))
+	if err != nil {
+		t.Fatalf("failed to read .gitignore: %v", err)
+	}
+	if string(gitignore) != "node_modules\n# age-vault\n/vault_key.age\n/vault_key.age.*.bak\n" {
+		t.Errorf("unexpected .gitignore:\n%s", gitignore)
+	}
+
+	// Entries are not added twice
+	if err := appendGitEntries(filepath.Join(projectDir, ".gitattributes"), initGitattributes); err != nil {
+		t.Fatalf("appendGitEntries failed: %v", err)
+	}
+	gitattributes, err := os.ReadFile(filepath.Join(projectDir, ".gitattributes"))
+	if err != nil {
+		t.Fatalf("failed to read .gitattributes: %v", err)
+	}
+	if string(gitattributes) != "# age-vault\n*.age binary age-vault\n" {
+		t.Errorf("unexpected .gitattributes:\n%s", gitattributes)
+	}
+
+	// An existing vault is not overwritten
+	err = RunInit(projectDir, identityPath, "", "", cfg)
+	if err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("expected error for an existing age_vault.yml, got: %v", err)
+	}
+
+	// An existing identity is used as is, and cannot be replaced by a plugin
+	otherDir := filepath.Join(tempDir, "other")
+	err = RunInit(otherDir, identityPath, "native", "", cfg)
+	if err == nil || !strings.Contains(err.Error(), "omit --plugin") {
+		t.Errorf("expected error for an existing identity, got: %v", err)
+	}
+	if err := RunInit(otherDir, identityPath, "", "hybrid", cfg); err != nil {
+		t.Errorf("RunInit with an existing identity failed: %v", err)
+	}
+}
@@ -0,0 +1,348 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/leolimasa/age-vault/config"
+	"github.com/leolimasa/age-vault/keymgmt"
+	"github.com/leolimasa/age-vault/vault"
+)
+
+// inspectReport describes the headers of an encrypted file, as printed by inspect.
+type inspectReport struct {
+	Path        string             `json:"path"`
+	Kind        string             `json:"kind,omitempty"` // "vault key" or "encrypted file"
+	Armored     bool               `json:"armored"`
+	PayloadSize int64              `json:"payload_size"`
+	Stanzas     []inspectStanza    `json:"stanzas,omitempty"`
+	Envelope    *inspectEnvelope   `json:"envelope,omitempty"`
+	FileHeader  *inspectFileHeader `json:"file_header,omitempty"`
+	Subvault    string             `json:"subvault,omitempty"`
+	VaultKey    string             `json:"vault_key,omitempty"` // Whether the vault key can open it
+	Identity    string             `json:"identity,omitempty"`  // Whether our identity can open it
+	Error       string             `json:"error,omitempty"`
+}
+
+// inspectStanza is a stanza of an age header.
+type inspectStanza struct {
+	Type        string   `json:"type"`
+	Args        []string `json:"args,omitempty"`
+	Description string   `json:"description"`
+}
+
+// inspectEnvelope is the envelope of a vault key file (see vault.Envelope).
+type inspectEnvelope struct {
+	VaultID    string     `json:"vault_id,omitempty"`
+	Generation int        `json:"generation,omitempty"`
+	Created    *time.Time `json:"created,omitempty"`
+	Label      string     `json:"label,omitempty"`
+}
+
+// inspectFileHeader is the metadata header of an encrypted file (see vault.FileHeader).
+type inspectFileHeader struct {
+	VaultID     string `json:"vault_id,omitempty"`
+	Generation  int    `json:"generation,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Filename    string `json:"filename,omitempty"`
+}
+
+// inspectKeys loads the keys inspect tries on headers, once and only when needed.
+type inspectKeys struct {
+	cfg *config.Config
+
+	identitiesLoaded bool
+	identities       []age.Identity // Native identities, which can be tried without prompting
+	pluginIdentities bool           // Whether some identities are plugins, which are not tried
+	identitiesErr    error
+
+	vaultKeyLoaded bool
+	vaultKey       *vault.VaultKey
+	vaultKeyErr    error
+}
+
+// RunInspect handles the inspect command.
+// It prints the headers of age encrypted files without decrypting them: stanza types,
+// payload size, armor, age-vault envelope or metadata header, and whether the vault key or
+// our identity can open them. Fails if any file could not be inspected.
+func RunInspect(paths []string, jsonOutput bool, cfg *config.Config) error {
+	keys := &inspectKeys{cfg: cfg}
+
+	var reports []inspectReport
+	failed := 0
+	for _, path := range paths {
+		report := inspectFile(path, keys)
+		if report.Error != "" {
+			failed++
+		}
+		reports = append(reports, report)
+	}
+
+	if jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(reports); err != nil {
+			return fmt.Errorf("failed to write JSON: %w", err)
+		}
+	} else {
+		for i, report := range reports {
+			if i > 0 {
+				fmt.Println()
+			}
+			printInspectReport(report)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to inspect %d file(s)", failed)
+	}
+	return nil
+}
+
+// inspectFile builds the report of a single file.
+func inspectFile(path string, keys *inspectKeys) inspectReport {
+	report := inspectReport{Path: path}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		report.Error = fmt.Sprintf("failed to read file: %v", err)
+		return report
+	}
+
+	// Armored files are inspected once decoded
+	if trimmed := bytes.TrimSpace(data); bytes.HasPrefix(trimmed, []byte(armor.Header)) {
+		report.Armored = true
+		data, err = io.ReadAll(armor.NewReader(bytes.NewReader(trimmed)))
+		if err != nil {
+			report.Error = fmt.Sprintf("malformed armor: %v", err)
+			return report
+		}
+	}
+
+	// age-vault metadata ahead of the age header
+	if vault.IsEnvelope(data) {
+		report.Kind = "vault key"
+		envelope, payload, err := vault.OpenEnvelope(data)
+		if err != nil {
+			report.Error = err.Error()
+			return report
+		}
+		report.Envelope = &inspectEnvelope{VaultID: envelope.VaultID, Generation: envelope.Generation, Label: envelope.Label}
+		if !envelope.Created.IsZero() {
+			report.Envelope.Created = &envelope.Created
+		}
+		data = payload
+	} else if vault.HasFileHeader(data) {
+		report.Kind = "encrypted file"
+		header, payload, err := vault.ParseFileHeader(data)
+		if err != nil {
+			report.Error = err.Error()
+			return report
+		}
+		report.FileHeader = &inspectFileHeader{
+			VaultID:     header.VaultID,
+			Generation:  header.Generation,
+			ContentType: header.ContentType,
+			Filename:    header.Filename,
+		}
+		data = payload
+	}
+
+	header, err := age.ExtractHeader(bytes.NewReader(data))
+	if err != nil {
+		report.Error = fmt.Sprintf("not an age encrypted file: %v", err)
+		return report
+	}
+	report.PayloadSize = int64(len(data) - len(header))
+
+	nativeStanzas := false
+	for _, line := range strings.Split(string(header), "\n") {
+		fields := strings.Fields(strings.TrimPrefix(line, "-> "))
+		if !strings.HasPrefix(line, "-> ") || len(fields) == 0 {
+			continue
+		}
+		stanza := inspectStanza{Type: fields[0], Args: fields[1:], Description: describeStanza(fields[0])}
+		report.Stanzas = append(report.Stanzas, stanza)
+
+		switch stanza.Type {
+		case "X25519", "mlkem768x25519":
+			nativeStanzas = true
+		case vault.SubvaultStanzaType:
+			if len(stanza.Args) > 0 {
+				report.Subvault = stanza.Args[0]
+			}
+		}
+	}
+	if report.Kind == "" {
+		// Only vault keys are encrypted to age-vault passphrases and escrow recipients
+		report.Kind = "encrypted file"
+		for _, stanza := range report.Stanzas {
+			if stanza.Type == vault.PassphraseStanzaType || stanza.Type == vault.EscrowStanzaType {
+				report.Kind = "vault key"
+			}
+		}
+	}
+
+	// Try the keys, without decrypting the payload
+	report.Identity = keys.identityMatch(header)
+	if report.Kind == "encrypted file" && nativeStanzas && report.Subvault == "" {
+		report.VaultKey = keys.vaultKeyMatch(header)
+	}
+
+	return report
+}
+
+// describeStanza describes the recipient behind a stanza type.
+func describeStanza(stanzaType string) string {
+	switch stanzaType {
+	case "X25519":
+		return "age public key"
+	case "mlkem768x25519":
+		return "post-quantum hybrid public key"
+	case "scrypt":
+		return "passphrase"
+	case "ssh-ed25519", "ssh-rsa":
+		return "SSH public key"
+	case vault.PassphraseStanzaType:
+		return "age-vault recovery passphrase"
+	case vault.EscrowStanzaType:
+		return "age-vault escrow marker"
+	case vault.SubvaultStanzaType:
+		return "age-vault sub-vault marker"
+	case vault.HeaderStanzaType:
+		return "age-vault metadata header hash"
+	default:
+		return "plugin recipient"
+	}
+}
+
+// identityMatch reports whether our identity can open an age header.
+func (k *inspectKeys) identityMatch(header []byte) string {
+	if !k.identitiesLoaded {
+		k.identitiesLoaded = true
+		identities, err := keymgmt.LoadIdentities(k.cfg.IdentityFile)
+		k.identitiesErr = err
+		for _, identity := range identities {
+			if _, err := vault.KeyTypeOf(identity); err == nil {
+				k.identities = append(k.identities, identity)
+			} else {
+				k.pluginIdentities = true
+			}
+		}
+	}
+
+	if k.identitiesErr != nil {
+		return fmt.Sprintf("unknown (failed to load identity: %v)", k.identitiesErr)
+	}
+	if len(k.identities) > 0 {
+		if _, err := age.DecryptHeader(header, k.identities...); err == nil {
+			return "yes"
+		}
+	}
+	if k.pluginIdentities {
+		return "unknown (plugin identities are not tried)"
+	}
+	return "no"
+}
+
+// vaultKeyMatch reports whether the vault key, current or retired, can open an age header.
+func (k *inspectKeys) vaultKeyMatch(header []byte) string {
+	if !k.vaultKeyLoaded {
+		k.vaultKeyLoaded = true
+		k.vaultKey, k.vaultKeyErr = openVaultKeyWithoutPrompt(k.cfg)
+	}
+
+	if k.vaultKeyErr != nil {
+		return fmt.Sprintf("unknown (%v)", k.vaultKeyErr)
+	}
+	generations := k.vaultKey.KeyGenerations()
+	for i, identity := range k.vaultKey.Identities() {
+		if _, err := age.DecryptHeader(header, identity); err == nil {
+			generation := generations[i]
+			if i == 0 {
+				return fmt.Sprintf("current (generation %d)", generation)
+			}
+			return fmt.Sprintf("retired (generation %d)", generation)
+		}
+	}
+	return "no"
+}
+
+// openVaultKeyWithoutPrompt decrypts the vault key with the configured identity, without
+// falling back to the recovery passphrase.
+func openVaultKeyWithoutPrompt(cfg *config.Config) (*vault.VaultKey, error) {
+	encryptedKey, err := os.ReadFile(cfg.VaultKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vault key: %w", err)
+	}
+	identities, err := keymgmt.LoadIdentities(cfg.IdentityFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load identity: %w", err)
+	}
+	vaultKey, err := vault.DecryptVaultKey(encryptedKey, identities...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt vault key: %w", err)
+	}
+	return vaultKey, nil
+}
+
+// printInspectReport prints a report as text.
+func printInspectReport(report inspectReport) {
+	fmt.Printf("%s\n", report.Path)
+	if report.Error != "" {
+		fmt.Printf("  Error:        %s\n", report.Error)
+		return
+	}
+
+	format := "age"
+	if report.Armored {
+		format = "age (armored)"
+	}
+	fmt.Printf("  Kind:         %s\n", report.Kind)
+	fmt.Printf("  Format:       %s\n", format)
+	fmt.Printf("  Payload:      %d bytes\n", report.PayloadSize)
+
+	if e := report.Envelope; e != nil {
+		fmt.Printf("  Vault ID:     %s\n", e.VaultID)
+		fmt.Printf("  Generation:   %d\n", e.Generation)
+		if e.Created != nil {
+			fmt.Printf("  Created:      %s\n", e.Created.Format(time.RFC3339))
+		}
+		fmt.Printf("  Label:        %s\n", e.Label)
+	}
+	if h := report.FileHeader; h != nil {
+		fmt.Printf("  Vault ID:     %s\n", h.VaultID)
+		fmt.Printf("  Generation:   %d\n", h.Generation)
+		if h.ContentType != "" {
+			fmt.Printf("  Content type: %s\n", h.ContentType)
+		}
+		if h.Filename != "" {
+			fmt.Printf("  Filename:     %s\n", h.Filename)
+		}
+	}
+	if report.Subvault != "" {
+		fmt.Printf("  Sub-vault:    %s\n", report.Subvault)
+	}
+
+	fmt.Printf("  Stanzas:\n")
+	for _, stanza := range report.Stanzas {
+		// The arguments of markers are meaningful, those of recipients are not
+		if stanza.Type == vault.EscrowStanzaType || stanza.Type == vault.SubvaultStanzaType {
+			fmt.Printf("    - %s %s (%s)\n", stanza.Type, strings.Join(stanza.Args, " "), stanza.Description)
+			continue
+		}
+		fmt.Printf("    - %s (%s)\n", stanza.Type, stanza.Description)
+	}
+
+	if report.VaultKey != "" {
+		fmt.Printf("  Vault key:    %s\n", report.VaultKey)
+	}
+	fmt.Printf("  Identity:     %s\n", report.Identity)
+}
@@ -0,0 +1,115 @@
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+
+	"github.com/leolimasa/age-vault/config"
+)
+
+func TestInspectFile(t *testing.T) {
+	tempDir := t.TempDir()
+
+	identityPath := filepath.Join(tempDir, "identity.txt")
+	identity := writeTestIdentity(t, identityPath)
+	vaultKeyPath := filepath.Join(tempDir, "vault_key.age")
+	vaultKey := writeTestVaultKey(t, identity, vaultKeyPath)
+	cfg := &config.Config{IdentityFile: identityPath, VaultKeyFile: vaultKeyPath}
+
+	// A vault key file for another machine, with an envelope
+	other, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("failed to generate identity: %v", err)
+	}
+	otherKeyPath := filepath.Join(tempDir, "other.age")
+	if err := RunVaultKeyEncrypt(other.Recipient().String(), "", nil, otherKeyPath, false, false, false, "", cfg); err != nil {
+		t.Fatalf("RunVaultKeyEncrypt failed: %v", err)
+	}
+
+	// A secret with a metadata header, and one without
+	plainPath := filepath.Join(tempDir, "secret.txt")
+	if err := os.WriteFile(plainPath, []byte("secret"), 0600); err != nil {
+		t.Fatalf("failed to write plaintext: %v", err)
+	}
+	headerPath := filepath.Join(tempDir, "secret.txt.age")
+	if err := RunEncrypt(plainPath, headerPath, "", true, "", cfg); err != nil {
+		t.Fatalf("RunEncrypt failed: %v", err)
+	}
+	rawPath := filepath.Join(tempDir, "raw.age")
+	writeTestSecret(t, vaultKey, rawPath, "secret")
+
+	keys := &inspectKeys{cfg: cfg}
+
+	report := inspectFile(otherKeyPath, keys)
+	if report.Error != "" {
+		t.Fatalf("inspecting vault key file failed: %s", report.Error)
+	}
+	if report.Kind != "vault key" || report.Envelope == nil || report.Envelope.Label != other.Recipient().String() {
+		t.Errorf("unexpected vault key report: %+v", report)
+	}
+	if report.Envelope.VaultID != vaultKey.ID() || report.Identity != "no" {
+		t.Errorf("unexpected vault key report: %+v", report)
+	}
+
+	report = inspectFile(vaultKeyPath, keys)
+	if report.Identity != "yes" {
+		t.Errorf("expected own vault key file to match identity, got %q", report.Identity)
+	}
+
+	report = inspectFile(headerPath, keys)
+	if report.Error != "" {
+		t.Fatalf("inspecting secret failed: %s", report.Error)
+	}
+	if report.FileHeader == nil || report.FileHeader.Filename != "secret.txt" || !strings.HasPrefix(report.FileHeader.ContentType, "text/plain") {
+		t.Errorf("unexpected file header: %+v", report.FileHeader)
+	}
+	if report.VaultKey != "current (generation 1)" {
+		t.Errorf("expected secret to match the current vault key, got %q", report.VaultKey)
+	}
+	if len(report.Stanzas) != 2 || report.Stanzas[0].Type != "X25519" {
+		t.Errorf("unexpected stanzas: %+v", report.Stanzas)
+	}
+
+	report = inspectFile(rawPath, keys)
+	if report.Kind != "encrypted file" || report.FileHeader != nil || report.VaultKey != "current (generation 1)" {
+		t.Errorf("unexpected report for a plain age file: %+v", report)
+	}
+	if report.PayloadSize <= int64(len("secret")) {
+		t.Errorf("unexpected payload size %d", report.PayloadSize)
+	}
+
+	report = inspectFile(plainPath, keys)
+	if report.Error == "" {
+		t.Error("expected error inspecting a plaintext file")
+	}
+
+	if err := RunInspect([]string{headerPath, plainPath}, true, cfg); err == nil {
+		t.Error("expected RunInspect to fail with a plaintext file")
+	}
+}
+
+func TestInspectFile_Armored(t *testing.T) {
+	tempDir := t.TempDir()
+
+	identityPath := filepath.Join(tempDir, "identity.txt")
+	identity := writeTestIdentity(t, identityPath)
+	cfg := &config.Config{IdentityFile: identityPath, VaultKeyFile: filepath.Join(tempDir, "vault_key.age")}
+
+	encrypted, err := encryptShare([]byte("share"), identity.Recipient())
+	if err != nil {
+		t.Fatalf("encryptShare failed: %v", err)
+	}
+	path := filepath.Join(tempDir, "share.age")
+	if err := os.WriteFile(path, encrypted, 0600); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	report := inspectFile(path, &inspectKeys{cfg: cfg})
+	if report.Error != "" || !report.Armored || report.Identity != "yes" {
+		t.Errorf("unexpected report for an armored file: %+v", report)
+	}
+}
@@ -0,0 +1,86 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/leolimasa/age-vault/config"
+	"github.com/leolimasa/age-vault/members"
+)
+
+// RunMembersAdd handles the members add command.
+// It registers a new member in the recipients registry, taking the public key
+// either from --pubkey or from --pubkey-file.
+func RunMembersAdd(name string, pubkey string, pubkeyFile string, cfg *config.Config) error {
+	if pubkeyFile != "" {
+		content, err := os.ReadFile(pubkeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to read public key file: %w", err)
+		}
+		pubkey = string(content)
+	}
+
+	if strings.TrimSpace(pubkey) == "" {
+		return fmt.Errorf("exactly one of --pubkey or --pubkey-file must be provided")
+	}
+
+	registry, err := members.Load(cfg.RecipientsFile)
+	if err != nil {
+		return fmt.Errorf("failed to load recipients registry: %w", err)
+	}
+
+	if err := registry.Add(name, pubkey); err != nil {
+		return fmt.Errorf("failed to add member: %w", err)
+	}
+
+	if err := registry.Save(cfg.RecipientsFile); err != nil {
+		return fmt.Errorf("failed to save recipients registry: %w", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Member %s added to %s\n", name, cfg.RecipientsFile)
+	return nil
+}
+
+// RunMembersList handles the members list command.
+// It prints every registered member and its public key.
+func RunMembersList(cfg *config.Config) error {
+	registry, err := members.Load(cfg.RecipientsFile)
+	if err != nil {
+		return fmt.Errorf("failed to load recipients registry: %w", err)
+	}
+
+	if len(registry.Members) == 0 {
+		fmt.Printf("No members registered in %s\n", cfg.RecipientsFile)
+		return nil
+	}
+
+	fmt.Printf("Found %d member(s) in %s:\n", len(registry.Members), cfg.RecipientsFile)
+	for _, m := range registry.Members {
+		fmt.Printf("  - %s: %s\n", m.Name, m.Recipient)
+	}
+
+	return nil
+}
+
+// RunMembersRemove handles the members remove command.
+// It removes a member from the recipients registry. Note that the member keeps access
+// to the current vault key until the vault key is rotated.
+func RunMembersRemove(name string, cfg *config.Config) error {
+	registry, err := members.Load(cfg.RecipientsFile)
+	if err != nil {
+		return fmt.Errorf("failed to load recipients registry: %w", err)
+	}
+
+	if err := registry.Remove(name); err != nil {
+		return fmt.Errorf("failed to remove member: %w", err)
+	}
+
+	if err := registry.Save(cfg.RecipientsFile); err != nil {
+		return fmt.Errorf("failed to save recipients registry: %w", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Member %s removed from %s\n", name, cfg.RecipientsFile)
+	fmt.Fprintf(os.Stderr, "Run 'age-vault vault-key rotate' to revoke its access to the vault key\n")
+	return nil
+}
@@ -0,0 +1,94 @@
+package commands
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/leolimasa/age-vault/keymgmt"
+)
+
+// minPassphraseLength is the minimum length accepted for new passphrases.
+const minPassphraseLength = 12
+
+// confirm asks the user a yes/no question on stderr and reads the answer from stdin.
+// Anything other than "y" or "yes" is treated as no.
+func confirm(prompt string) (bool, error) {
+	fmt.Fprintf(os.Stderr, "%s [y/N]: ", prompt)
+
+	reader := bufio.NewReader(os.Stdin)
+	response, err := reader.ReadString('\n')
+	if err != nil && response == "" {
+		return false, fmt.Errorf("failed to read confirmation: %w", err)
+	}
+
+	response = strings.ToLower(strings.TrimSpace(response))
+	return response == "y" || response == "yes", nil
+}
+
+// choose asks the user to pick one of options by number on stderr and reads the answer from
+// stdin. An empty answer picks the first option.
+func choose(prompt string, options []string) (int, error) {
+	fmt.Fprintf(os.Stderr, "%s\n", prompt)
+	for i, option := range options {
+		fmt.Fprintf(os.Stderr, "  %d) %s\n", i+1, option)
+	}
+	fmt.Fprintf(os.Stderr, "Choice [1]: ")
+
+	reader := bufio.NewReader(os.Stdin)
+	response, err := reader.ReadString('\n')
+	if err != nil && response == "" {
+		return 0, fmt.Errorf("failed to read choice: %w", err)
+	}
+
+	response = strings.TrimSpace(response)
+	if response == "" {
+		return 0, nil
+	}
+	choice, err := strconv.Atoi(response)
+	if err != nil || choice < 1 || choice > len(options) {
+		return 0, fmt.Errorf("invalid choice %q (expected 1 to %d)", response, len(options))
+	}
+	return choice - 1, nil
+}
+
+// readNewPassphrase prompts for a new passphrase twice and checks its strength.
+func readNewPassphrase() (string, error) {
+	passphrase, err := keymgmt.ReadPassphrase("Enter passphrase")
+	if err != nil {
+		return "", err
+	}
+	if err := checkPassphraseStrength(passphrase); err != nil {
+		return "", err
+	}
+
+	confirmation, err := keymgmt.ReadPassphrase("Confirm passphrase")
+	if err != nil {
+		return "", err
+	}
+	if confirmation != passphrase {
+		return "", fmt.Errorf("passphrases do not match")
+	}
+
+	return passphrase, nil
+}
+
+// checkPassphraseStrength rejects passphrases that are too short or too repetitive
+// to protect a vault key stored offline.
+func checkPassphraseStrength(passphrase string) error {
+	if len([]rune(passphrase)) < minPassphraseLength {
+		return fmt.Errorf("passphrase is too short (at least %d characters required)", minPassphraseLength)
+	}
+
+	unique := map[rune]bool{}
+	for _, r := range passphrase {
+		unique[r] = true
+	}
+	if len(unique) < minPassphraseLength/2 {
+		return fmt.Errorf("passphrase is too repetitive (use at least %d different characters)", minPassphraseLength/2)
+	}
+
+	return nil
+}
@@ -0,0 +1,216 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/leolimasa/age-vault/config"
+	"github.com/leolimasa/age-vault/keymgmt"
+	"github.com/leolimasa/age-vault/vault"
+)
+
+// RunRekey handles the rekey command.
+// It re-encrypts vault-encrypted files from retired vault keys to the current vault key.
+// By default the retired keys of the current keyring are used; oldVaultKeyFile can point to
+// an older encrypted vault key file instead (e.g. the backup left behind by vault-key rotate).
+// Paths can be files or directories; directories are walked recursively for .age files.
+// Encrypted SSH keys in the configured SSH keys directory are included unless skipSSHKeys is set.
+// Files are replaced atomically, and files that cannot be decrypted are reported at the end.
+// Files of sub-vaults are skipped: their keys are not rotated with the vault key. So are
+// encrypted vault keys, including the sub-vault keys in the configured sub-vaults directory.
+func RunRekey(paths []string, oldVaultKeyFile string, skipSSHKeys bool, cfg *config.Config) error {
+	// Load the current keyring
+	vaultKey, err := keymgmt.VaultKeyFromIdentityFile(cfg.IdentityFile, cfg.VaultKeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load vault key: %w", err)
+	}
+
+	// Files are always re-encrypted with the current key only
+	newVaultKey := vaultKey.DropRetired()
+
+	// Load the old vault key, falling back to the retired keys of the current keyring
+	oldVaultKey := vaultKey
+	if oldVaultKeyFile != "" {
+		oldVaultKey, err = keymgmt.VaultKeyFromIdentityFile(cfg.IdentityFile, oldVaultKeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load old vault key: %w", err)
+		}
+	}
+
+	// Include the encrypted SSH keys consumed by the ssh agent
+	if !skipSSHKeys && cfg.SSHKeysDir != "" {
+		paths = append(paths, cfg.SSHKeysDir)
+	}
+
+	if len(paths) == 0 {
+		return fmt.Errorf("no files to re-encrypt (provide paths or set AGE_VAULT_SSH_KEYS_DIR)")
+	}
+
+	// Never touch the vault key files themselves, they are encrypted for identities, not the vault key.
+	// The sub-vault keys are left out too: those wrapped with --parent decrypt with the vault key,
+	// but re-encrypting them as data would drop their members and escrow recipients.
+	excluded := map[string]bool{}
+	for _, path := range []string{cfg.VaultKeyFile, oldVaultKeyFile, cfg.SubvaultsDir} {
+		if path == "" {
+			continue
+		}
+		if absPath, err := filepath.Abs(path); err == nil {
+			excluded[absPath] = true
+		}
+	}
+
+	files, err := collectVaultFiles(paths, excluded)
+	if err != nil {
+		return err
+	}
+
+	rekeyed := 0
+	upToDate := 0
+	skipped := 0
+	var failed []string
+	for _, path := range files {
+		result, err := rekeyFile(path, oldVaultKey, newVaultKey)
+		if err != nil {
+			failed = append(failed, path)
+			fmt.Fprintf(os.Stderr, "Failed: %s: %v\n", path, err)
+			continue
+		}
+		switch result {
+		case rekeyReencrypted:
+			rekeyed++
+			fmt.Fprintf(os.Stderr, "Re-encrypted: %s\n", path)
+		case rekeySkippedSubvault:
+			skipped++
+			fmt.Fprintf(os.Stderr, "Skipped (sub-vault): %s\n", path)
+		case rekeySkippedVaultKey:
+			skipped++
+			fmt.Fprintf(os.Stderr, "Skipped (vault key): %s\n", path)
+		default:
+			upToDate++
+			fmt.Fprintf(os.Stderr, "Already up to date: %s\n", path)
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "%d file(s) re-encrypted, %d already up to date, %d skipped, %d failed\n", rekeyed, upToDate, skipped, len(failed))
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to re-encrypt %d file(s)", len(failed))
+	}
+
+	return nil
+}
+
+// rekeyResult is the outcome of rekeying a single file.
+type rekeyResult int
+
+const (
+	rekeyUpToDate        rekeyResult = iota // Already encrypted with the new vault key
+	rekeyReencrypted                        // Re-encrypted from the old vault key
+	rekeySkippedSubvault                    // Encrypted for a sub-vault, left alone
+	rekeySkippedVaultKey                    // An encrypted vault key, left alone
+)
+
+// rekeyFile re-encrypts a single file from the old vault key to the new one.
+// Files encrypted for a sub-vault are left alone, since sub-vault keys are not rotated with
+// the vault key and re-encrypting them with it would widen their access. So are encrypted
+// vault keys (see isVaultKeyFile), such as sub-vault keys or files left by vault-key encrypt.
+func rekeyFile(path string, oldVaultKey, newVaultKey *vault.VaultKey) (rekeyResult, error) {
+	encrypted, err := os.ReadFile(path)
+	if err != nil {
+		return rekeyUpToDate, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	if vault.SubvaultName(encrypted) != "" {
+		return rekeySkippedSubvault, nil
+	}
+	if isVaultKeyFile(encrypted) {
+		return rekeySkippedVaultKey, nil
+	}
+
+	// Skip files that were already re-encrypted
+	var plaintext bytes.Buffer
+	if err := newVaultKey.Decrypt(bytes.NewReader(encrypted), &plaintext); err == nil {
+		return rekeyUpToDate, nil
+	}
+
+	plaintext.Reset()
+	if err := oldVaultKey.Decrypt(bytes.NewReader(encrypted), &plaintext); err != nil {
+		return rekeyUpToDate, fmt.Errorf("cannot decrypt with old vault key: %w", err)
+	}
+
+	// Keep the metadata header, updated to the new key generation
+	var options []vault.EncryptOption
+	if header, _, err := vault.ParseFileHeader(encrypted); err == nil && header != nil {
+		options = append(options, vault.WithFileHeader(header.ContentType, header.Filename))
+	}
+
+	var reencrypted bytes.Buffer
+	if err := newVaultKey.Encrypt(&plaintext, &reencrypted, options...); err != nil {
+		return rekeyUpToDate, fmt.Errorf("failed to encrypt with new vault key: %w", err)
+	}
+
+	if err := keymgmt.WriteFileAtomic(path, reencrypted.Bytes()); err != nil {
+		return rekeyUpToDate, err
+	}
+
+	return rekeyReencrypted, nil
+}
+
+// collectVaultFiles expands the given paths into a deduplicated list of files.
+// Files are taken as-is; directories are walked recursively for .age files,
+// skipping hidden directories such as .git. Paths in excluded, files or directories, are left out.
+func collectVaultFiles(paths []string, excluded map[string]bool) ([]string, error) {
+	seen := map[string]bool{}
+	var files []string
+
+	add := func(path string) {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			absPath = path
+		}
+		if excluded[absPath] || seen[absPath] {
+			return
+		}
+		seen[absPath] = true
+		files = append(files, path)
+	}
+
+	for _, root := range paths {
+		info, err := os.Stat(root)
+		if err != nil {
+			return nil, fmt.Errorf("failed to access %s: %w", root, err)
+		}
+
+		if !info.IsDir() {
+			add(root)
+			continue
+		}
+
+		err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				if path != root && len(d.Name()) > 1 && d.Name()[0] == '.' {
+					return filepath.SkipDir
+				}
+				if absPath, err := filepath.Abs(path); err == nil && excluded[absPath] {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if d.Type().IsRegular() && filepath.Ext(path) == ".age" {
+				add(path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error scanning %s: %w", root, err)
+		}
+	}
+
+	return files, nil
+}
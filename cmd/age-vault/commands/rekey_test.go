@@ -0,0 +1,269 @@
+package commands
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+
+	"github.com/leolimasa/age-vault/config"
+	"github.com/leolimasa/age-vault/vault"
+)
+
+// writeTestVaultKey generates a vault key, encrypts it for identity and writes it to path.
+func writeTestVaultKey(t *testing.T, identity *age.X25519Identity, path string) *vault.VaultKey {
+	t.Helper()
+
+	vaultKeyIdentity, err := vault.GenerateVaultKey()
+	if err != nil {
+		t.Fatalf("failed to generate vault key: %v", err)
+	}
+	encryptedKey, err := vault.EncryptVaultKey(vaultKeyIdentity, identity.Recipient())
+	if err != nil {
+		t.Fatalf("failed to encrypt vault key: %v", err)
+	}
+	if err := os.WriteFile(path, encryptedKey, 0600); err != nil {
+		t.Fatalf("failed to write vault key file: %v", err)
+	}
+
+	vaultKey, err := vault.DecryptVaultKey(encryptedKey, identity)
+	if err != nil {
+		t.Fatalf("failed to decrypt vault key: %v", err)
+	}
+	return vaultKey
+}
+
+// writeTestSecret encrypts plaintext with the vault key and writes it to path.
+func writeTestSecret(t *testing.T, vaultKey *vault.VaultKey, path string, plaintext string) {
+	t.Helper()
+
+	var encrypted bytes.Buffer
+	if err := vaultKey.Encrypt(strings.NewReader(plaintext), &encrypted); err != nil {
+		t.Fatalf("failed to encrypt secret: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(path, encrypted.Bytes(), 0600); err != nil {
+		t.Fatalf("failed to write secret: %v", err)
+	}
+}
+
+func TestRunRekey(t *testing.T) {
+	tempDir := t.TempDir()
+
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("failed to generate test identity: %v", err)
+	}
+	identityPath := filepath.Join(tempDir, "identity.txt")
+	if err := os.WriteFile(identityPath, []byte(identity.String()+"\n"), 0600); err != nil {
+		t.Fatalf("failed to write identity file: %v", err)
+	}
+
+	oldVaultKeyPath := filepath.Join(tempDir, "vault_key.age.old")
+	vaultKeyPath := filepath.Join(tempDir, "vault_key.age")
+	oldVaultKey := writeTestVaultKey(t, identity, oldVaultKeyPath)
+	newVaultKey := writeTestVaultKey(t, identity, vaultKeyPath)
+
+	// Secrets encrypted with the old vault key, plus one SSH key
+	secretsDir := filepath.Join(tempDir, "secrets")
+	sshKeysDir := filepath.Join(tempDir, "ssh_keys")
+	secrets := map[string]string{
+		filepath.Join(secretsDir, "a.txt.age"):       "secret a",
+		filepath.Join(secretsDir, "nested", "b.age"): "secret b",
+		filepath.Join(sshKeysDir, "id_ed25519.age"):  "ssh key",
+	}
+	for path, plaintext := range secrets {
+		writeTestSecret(t, oldVaultKey, path, plaintext)
+	}
+
+	// A file already encrypted with the new vault key must be left alone
+	upToDatePath := filepath.Join(secretsDir, "c.age")
+	writeTestSecret(t, newVaultKey, upToDatePath, "secret c")
+	upToDateBefore, _ := os.ReadFile(upToDatePath)
+
+	// A file of a sub-vault must be left alone, not reported as failed
+	subvaultKey := writeTestVaultKey(t, identity, filepath.Join(tempDir, "ci.age"))
+	var subvaultSecret bytes.Buffer
+	if err := subvaultKey.Encrypt(strings.NewReader("ci token"), &subvaultSecret, vault.WithSubvault("ci")); err != nil {
+		t.Fatalf("failed to encrypt sub-vault secret: %v", err)
+	}
+	subvaultPath := filepath.Join(secretsDir, "ci_token.age")
+	if err := os.WriteFile(subvaultPath, subvaultSecret.Bytes(), 0600); err != nil {
+		t.Fatalf("failed to write sub-vault secret: %v", err)
+	}
+
+	cfg := &config.Config{
+		IdentityFile: identityPath,
+		VaultKeyFile: vaultKeyPath,
+		SSHKeysDir:   sshKeysDir,
+	}
+
+	if err := RunRekey([]string{secretsDir}, oldVaultKeyPath, false, cfg); err != nil {
+		t.Fatalf("RunRekey failed: %v", err)
+	}
+
+	for path, plaintext := range secrets {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", path, err)
+		}
+		var decrypted bytes.Buffer
+		if err := newVaultKey.Decrypt(bytes.NewReader(data), &decrypted); err != nil {
+			t.Fatalf("%s was not re-encrypted with the new vault key: %v", path, err)
+		}
+		if decrypted.String() != plaintext {
+			t.Errorf("%s content mismatch: got %q, want %q", path, decrypted.String(), plaintext)
+		}
+	}
+
+	upToDateAfter, _ := os.ReadFile(upToDatePath)
+	if !bytes.Equal(upToDateBefore, upToDateAfter) {
+		t.Error("file already encrypted with the new vault key was rewritten")
+	}
+
+	subvaultAfter, _ := os.ReadFile(subvaultPath)
+	if !bytes.Equal(subvaultSecret.Bytes(), subvaultAfter) {
+		t.Error("file of a sub-vault was rewritten")
+	}
+}
+
+func TestRunRekey_ReportsUndecryptableFiles(t *testing.T) {
+	tempDir := t.TempDir()
+
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("failed to generate test identity: %v", err)
+	}
+	identityPath := filepath.Join(tempDir, "identity.txt")
+	if err := os.WriteFile(identityPath, []byte(identity.String()+"\n"), 0600); err != nil {
+		t.Fatalf("failed to write identity file: %v", err)
+	}
+
+	oldVaultKeyPath := filepath.Join(tempDir, "vault_key.age.old")
+	vaultKeyPath := filepath.Join(tempDir, "vault_key.age")
+	writeTestVaultKey(t, identity, oldVaultKeyPath)
+	writeTestVaultKey(t, identity, vaultKeyPath)
+
+	// A file encrypted with an unrelated vault key
+	otherVaultKey := writeTestVaultKey(t, identity, filepath.Join(tempDir, "other.key"))
+	foreignPath := filepath.Join(tempDir, "secrets", "foreign.age")
+	writeTestSecret(t, otherVaultKey, foreignPath, "foreign")
+
+	cfg := &config.Config{
+		IdentityFile: identityPath,
+		VaultKeyFile: vaultKeyPath,
+	}
+
+	err = RunRekey([]string{filepath.Join(tempDir, "secrets")}, oldVaultKeyPath, false, cfg)
+	if err == nil {
+		t.Fatal("expected error for file that cannot be decrypted, got nil")
+	}
+}
+
+func TestRunRekey_RetiredKeys(t *testing.T) {
+	tempDir := t.TempDir()
+
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("failed to generate test identity: %v", err)
+	}
+	identityPath := filepath.Join(tempDir, "identity.txt")
+	if err := os.WriteFile(identityPath, []byte(identity.String()+"\n"), 0600); err != nil {
+		t.Fatalf("failed to write identity file: %v", err)
+	}
+
+	// Write a keyring with a current and a retired key
+	oldIdentity, err := vault.GenerateVaultKey()
+	if err != nil {
+		t.Fatalf("failed to generate vault key: %v", err)
+	}
+	newIdentity, err := vault.GenerateVaultKey()
+	if err != nil {
+		t.Fatalf("failed to generate vault key: %v", err)
+	}
+	encryptedKey, err := vault.EncryptVaultKeyring(vault.NewVaultKey(newIdentity, oldIdentity), identity.Recipient())
+	if err != nil {
+		t.Fatalf("failed to encrypt vault keyring: %v", err)
+	}
+	vaultKeyPath := filepath.Join(tempDir, "vault_key.age")
+	if err := os.WriteFile(vaultKeyPath, encryptedKey, 0600); err != nil {
+		t.Fatalf("failed to write vault key file: %v", err)
+	}
+
+	secretPath := filepath.Join(tempDir, "secrets", "a.age")
+	writeTestSecret(t, vault.NewVaultKey(oldIdentity), secretPath, "secret a")
+
+	cfg := &config.Config{
+		IdentityFile: identityPath,
+		VaultKeyFile: vaultKeyPath,
+	}
+
+	if err := RunRekey([]string{filepath.Join(tempDir, "secrets")}, "", false, cfg); err != nil {
+		t.Fatalf("RunRekey failed: %v", err)
+	}
+
+	data, err := os.ReadFile(secretPath)
+	if err != nil {
+		t.Fatalf("failed to read secret: %v", err)
+	}
+	var decrypted bytes.Buffer
+	if err := vault.NewVaultKey(newIdentity).Decrypt(bytes.NewReader(data), &decrypted); err != nil {
+		t.Fatalf("secret was not re-encrypted with the current key: %v", err)
+	}
+}
+
+func TestRunRekey_SkipsVaultKeys(t *testing.T) {
+	tempDir := t.TempDir()
+
+	identityPath := filepath.Join(tempDir, "identity.txt")
+	identity := writeTestIdentity(t, identityPath)
+	oldVaultKeyPath := filepath.Join(tempDir, "vault_key.age.old")
+	vaultKeyPath := filepath.Join(tempDir, "vault_key.age")
+	writeTestVaultKey(t, identity, oldVaultKeyPath)
+
+	// A sub-vault wrapped with the old vault key, checked in next to the secrets
+	repoDir := filepath.Join(tempDir, "repo")
+	cfg := &config.Config{
+		IdentityFile: identityPath,
+		VaultKeyFile: oldVaultKeyPath,
+		SubvaultsDir: filepath.Join(repoDir, config.SubvaultsDirName),
+	}
+	if err := RunSubvaultCreate("prod", nil, true, "", cfg); err != nil {
+		t.Fatalf("RunSubvaultCreate failed: %v", err)
+	}
+	subvaultKeyPath := subvaultKeyPath("prod", cfg)
+	subvaultKeyBefore, err := os.ReadFile(subvaultKeyPath)
+	if err != nil {
+		t.Fatalf("failed to read sub-vault key: %v", err)
+	}
+
+	// A copy of it outside the sub-vaults directory is recognized as a vault key too
+	copyPath := filepath.Join(repoDir, "backup", "prod.age")
+	if err := os.MkdirAll(filepath.Dir(copyPath), 0700); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(copyPath, subvaultKeyBefore, 0600); err != nil {
+		t.Fatalf("failed to write copy: %v", err)
+	}
+
+	writeTestVaultKey(t, identity, vaultKeyPath)
+	cfg.VaultKeyFile = vaultKeyPath
+	if err := RunRekey([]string{repoDir}, oldVaultKeyPath, false, cfg); err != nil {
+		t.Fatalf("RunRekey failed: %v", err)
+	}
+
+	for _, path := range []string{subvaultKeyPath, copyPath} {
+		after, _ := os.ReadFile(path)
+		if !bytes.Equal(subvaultKeyBefore, after) {
+			t.Errorf("vault key file %s was rewritten", path)
+		}
+	}
+	if _, err := openSubvault("prod", &config.Config{IdentityFile: identityPath, VaultKeyFile: oldVaultKeyPath, SubvaultsDir: cfg.SubvaultsDir}); err != nil {
+		t.Errorf("sub-vault no longer opens with its parent key: %v", err)
+	}
+}
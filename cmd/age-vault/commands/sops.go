@@ -1,52 +1,33 @@
 package commands
 
 import (
-	"filippo.io/age"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 
 	"github.com/leolimasa/age-vault/config"
 	"github.com/leolimasa/age-vault/keymgmt"
-	"github.com/leolimasa/age-vault/vault"
 )
 
 // RunSops handles the sops passthrough command.
 // It decrypts the vault key and makes it available to sops via environment variable,
 // then executes sops with the provided arguments.
 func RunSops(sopsArgs []string, cfg *config.Config) error {
-	// Load user's identity
-	userIdentity, err := keymgmt.LoadIdentity(cfg.IdentityFile)
+	// Load and decrypt vault key
+	vaultKey, err := keymgmt.VaultKeyFromIdentityFile(cfg.IdentityFile, cfg.VaultKeyFile)
 	if err != nil {
-		return fmt.Errorf("failed to load identity: %w", err)
+		return fmt.Errorf("failed to load vault key: %w", err)
 	}
 
-	// Read encrypted vault key
-	encryptedVaultKey, err := os.ReadFile(cfg.VaultKeyFile)
+	// Serialize every key of the keyring, so sops can also decrypt files encrypted with
+	// retired vault keys
+	keyring, err := vaultKey.Marshal()
 	if err != nil {
-		return fmt.Errorf("failed to read vault key file: %w", err)
+		return fmt.Errorf("failed to serialize vault key: %w", err)
 	}
 
-	// Decrypt vault key
-	vaultKey, err := vault.DecryptVaultKey(encryptedVaultKey, userIdentity)
-	if err != nil {
-		return fmt.Errorf("failed to decrypt vault key: %w", err)
-	}
-
-	// Get the vault key identity
-	vaultKeyIdentity, err := vaultKey.GetIdentity()
-	if err != nil {
-		return fmt.Errorf("failed to get vault key identity: %w", err)
-	}
-
-	// Convert to X25519Identity to get the string representation
-	x25519Identity, ok := vaultKeyIdentity.(*age.X25519Identity)
-	if !ok {
-		return fmt.Errorf("vault key must be an X25519Identity")
-	}
-	vaultKeyStr := x25519Identity.String()
-
 	// Create a temporary file to hold the vault key
 	// (sops needs a file path, we can't use a pipe directly)
 	tempDir, err := os.MkdirTemp("", "age-vault-sops-*")
@@ -56,7 +37,7 @@ func RunSops(sopsArgs []string, cfg *config.Config) error {
 	defer os.RemoveAll(tempDir) // Clean up after sops finishes
 
 	keyFile := filepath.Join(tempDir, "key.txt")
-	if err := os.WriteFile(keyFile, []byte(vaultKeyStr), 0600); err != nil {
+	if err := os.WriteFile(keyFile, keyring, 0600); err != nil {
 		return fmt.Errorf("failed to write vault key to temp file: %w", err)
 	}
 
@@ -79,3 +60,32 @@ func RunSops(sopsArgs []string, cfg *config.Config) error {
 
 	return nil
 }
+
+// SplitVaultFlag takes the global --vault (-V) flag out of the arguments of a command that
+// passes its flags through, such as sops, since cobra doesn't parse them. It returns the
+// selected vault name, empty if none, and the remaining arguments. Arguments after "--" are
+// left alone.
+func SplitVaultFlag(args []string) (string, []string, error) {
+	name := ""
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			return name, append(rest, args[i:]...), nil
+		case arg == "--vault" || arg == "-V":
+			if i+1 >= len(args) {
+				return "", nil, fmt.Errorf("flag needs an argument: %s", arg)
+			}
+			i++
+			name = args[i]
+		case strings.HasPrefix(arg, "--vault="):
+			name = strings.TrimPrefix(arg, "--vault=")
+		case strings.HasPrefix(arg, "-V") && len(arg) > 2:
+			name = strings.TrimPrefix(strings.TrimPrefix(arg, "-V"), "=")
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	return name, rest, nil
+}
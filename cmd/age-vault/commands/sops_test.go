@@ -0,0 +1,35 @@
+package commands
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitVaultFlag(t *testing.T) {
+	tests := []struct {
+		args     []string
+		wantName string
+		wantRest []string
+	}{
+		{[]string{"-d", "secrets.enc.yaml"}, "", []string{"-d", "secrets.enc.yaml"}},
+		{[]string{"--vault", "team", "-d", "secrets.enc.yaml"}, "team", []string{"-d", "secrets.enc.yaml"}},
+		{[]string{"-d", "--vault=team", "secrets.enc.yaml"}, "team", []string{"-d", "secrets.enc.yaml"}},
+		{[]string{"-V", "team", "-d", "secrets.enc.yaml"}, "team", []string{"-d", "secrets.enc.yaml"}},
+		{[]string{"-Vteam", "-d", "secrets.enc.yaml"}, "team", []string{"-d", "secrets.enc.yaml"}},
+		{[]string{"exec-env", "--", "cmd", "--vault", "x"}, "", []string{"exec-env", "--", "cmd", "--vault", "x"}},
+	}
+	for _, tt := range tests {
+		name, rest, err := SplitVaultFlag(tt.args)
+		if err != nil {
+			t.Errorf("SplitVaultFlag(%q) failed: %v", tt.args, err)
+			continue
+		}
+		if name != tt.wantName || !reflect.DeepEqual(rest, tt.wantRest) {
+			t.Errorf("SplitVaultFlag(%q) = %q, %q, want %q, %q", tt.args, name, rest, tt.wantName, tt.wantRest)
+		}
+	}
+
+	if _, _, err := SplitVaultFlag([]string{"-d", "--vault"}); err == nil {
+		t.Error("expected --vault without a name to fail")
+	}
+}
@@ -8,7 +8,6 @@ import (
 	"github.com/leolimasa/age-vault/config"
 	"github.com/leolimasa/age-vault/keymgmt"
 	"github.com/leolimasa/age-vault/sshagent"
-	"github.com/leolimasa/age-vault/vault"
 )
 
 // RunSSHStartAgent handles the ssh start-agent command.
@@ -28,22 +27,10 @@ func RunSSHStartAgent(keysDir string, cfg *config.Config) error {
 		return fmt.Errorf("SSH keys directory does not exist: %s", keysDir)
 	}
 
-	// Load user's identity
-	userIdentity, err := keymgmt.LoadIdentity(cfg.IdentityFile)
+	// Load the vault key, with compound identities and the recovery passphrase fallback
+	vaultKey, err := keymgmt.VaultKeyFromIdentityFile(cfg.IdentityFile, cfg.VaultKeyFile)
 	if err != nil {
-		return fmt.Errorf("failed to load identity: %w", err)
-	}
-
-	// Read encrypted vault key
-	encryptedVaultKey, err := os.ReadFile(cfg.VaultKeyFile)
-	if err != nil {
-		return fmt.Errorf("failed to read vault key file: %w", err)
-	}
-
-	// Decrypt vault key
-	vaultKey, err := vault.DecryptVaultKey(encryptedVaultKey, userIdentity)
-	if err != nil {
-		return fmt.Errorf("failed to decrypt vault key: %w", err)
+		return fmt.Errorf("failed to load vault key: %w", err)
 	}
 
 	// Create VaultSSHAgent
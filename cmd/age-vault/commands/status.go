@@ -0,0 +1,456 @@
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/leolimasa/age-vault/config"
+	"github.com/leolimasa/age-vault/keymgmt"
+	"github.com/leolimasa/age-vault/vault"
+)
+
+// gitattributesValue marks files that must be vault-encrypted in .gitattributes,
+// either as an attribute of its own or as the value of one (e.g. filter=age-vault).
+const gitattributesValue = "age-vault"
+
+// File states reported by status.
+const (
+	statusCurrent  = "current"   // Encrypted to the current vault key
+	statusRetired  = "retired"   // Encrypted to a retired key generation
+	statusFailed   = "failed"    // Looks encrypted but cannot be decrypted
+	statusSkipped  = "skipped"   // Encrypted to a sub-vault we are not a member of
+	statusVaultKey = "vault key" // An encrypted vault key, not reported
+	statusPlain    = "plaintext" // Not encrypted but looks like it should be
+)
+
+// statusResult is the state of one file.
+type statusResult struct {
+	Path   string
+	State  string
+	Detail string
+}
+
+// statusChecker checks files against the vault key and the sub-vault keys, opening each
+// sub-vault once.
+type statusChecker struct {
+	cfg       *config.Config
+	vaultKey  *vault.VaultKey
+	subvaults map[string]*vault.VaultKey
+	errors    map[string]error
+}
+
+// RunStatus handles the status command.
+// It scans dir (by default the directory of age_vault.yml) for vault-encrypted files, found
+// by .age extension, by content or by an age-vault attribute in .gitattributes, and reports
+// which are encrypted to the current vault key, which to retired key generations and which
+// cannot be decrypted. Plaintext files that look like secrets are reported too. Inside a git
+// work tree only files git would track are scanned. Fails if any problem is found.
+func RunStatus(dir string, cfg *config.Config) error {
+	if dir == "" {
+		dir = cfg.ConfigDir
+	}
+	if dir == "" {
+		dir = "."
+	}
+
+	files, err := listStatusFiles(dir)
+	if err != nil {
+		return err
+	}
+
+	vaultKey, err := keymgmt.VaultKeyFromIdentityFile(cfg.IdentityFile, cfg.VaultKeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load vault key: %w", err)
+	}
+	checker := &statusChecker{
+		cfg:       cfg,
+		vaultKey:  vaultKey,
+		subvaults: map[string]*vault.VaultKey{},
+		errors:    map[string]error{},
+	}
+
+	rules, err := loadGitattributes(dir, files)
+	if err != nil {
+		return err
+	}
+
+	// Vault key files are encrypted for identities, not the vault key
+	excluded := map[string]bool{}
+	for _, path := range []string{cfg.VaultKeyFile, cfg.SubvaultsDir} {
+		if absPath, err := filepath.Abs(path); err == nil && path != "" {
+			excluded[absPath] = true
+		}
+	}
+
+	counts := map[string]int{}
+	for _, file := range files {
+		if isExcluded(filepath.Join(dir, filepath.FromSlash(file)), excluded) {
+			continue
+		}
+		result, ok := checker.check(dir, file, rules)
+		if !ok || result.State == statusVaultKey {
+			continue
+		}
+		counts[result.State]++
+
+		label := strings.ToUpper(result.State)
+		if result.Detail != "" {
+			fmt.Printf("%-9s %s (%s)\n", label, result.Path, result.Detail)
+		} else {
+			fmt.Printf("%-9s %s\n", label, result.Path)
+		}
+	}
+
+	fmt.Printf("%d current, %d retired, %d failed, %d plaintext, %d skipped\n",
+		counts[statusCurrent], counts[statusRetired], counts[statusFailed], counts[statusPlain], counts[statusSkipped])
+
+	problems := counts[statusRetired] + counts[statusFailed] + counts[statusPlain]
+	if problems > 0 {
+		hint := ""
+		if counts[statusRetired] > 0 {
+			hint = " (re-encrypt retired files with 'age-vault rekey')"
+		}
+		return fmt.Errorf("%d problem(s) found in %s%s", problems, dir, hint)
+	}
+	return nil
+}
+
+// listStatusFiles lists the files under dir, relative to it. Inside a git work tree the
+// files git tracks or would track are listed, so ignored files are left out; otherwise dir
+// is walked, skipping hidden directories such as .git.
+func listStatusFiles(dir string) ([]string, error) {
+	out, err := exec.Command("git", "-C", dir, "ls-files", "-z", "--cached", "--others", "--exclude-standard").Output()
+	if err == nil {
+		var files []string
+		seen := map[string]bool{}
+		for _, file := range strings.Split(string(out), "\x00") {
+			// Files deleted from the work tree are still listed by git
+			if file == "" || seen[file] {
+				continue
+			}
+			seen[file] = true
+			if info, err := os.Lstat(filepath.Join(dir, file)); err == nil && info.Mode().IsRegular() {
+				files = append(files, filepath.ToSlash(file))
+			}
+		}
+		return files, nil
+	}
+
+	var files []string
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p != dir && len(d.Name()) > 1 && d.Name()[0] == '.' {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Type().IsRegular() {
+			rel, err := filepath.Rel(dir, p)
+			if err != nil {
+				return err
+			}
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error scanning %s: %w", dir, err)
+	}
+	return files, nil
+}
+
+// isExcluded reports whether path or one of its parent directories is in excluded.
+func isExcluded(path string, excluded map[string]bool) bool {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	for {
+		if excluded[absPath] {
+			return true
+		}
+		parent := filepath.Dir(absPath)
+		if parent == absPath {
+			return false
+		}
+		absPath = parent
+	}
+}
+
+// check reports the state of a file, given relative to dir. Returns false for files that
+// are neither encrypted nor suspicious.
+func (c *statusChecker) check(dir, file string, rules []gitattributesRule) (statusResult, bool) {
+	result := statusResult{Path: file}
+
+	data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(file)))
+	if err != nil {
+		result.State = statusFailed
+		result.Detail = fmt.Sprintf("failed to read file: %v", err)
+		return result, true
+	}
+
+	if !looksEncrypted(data) {
+		reason := ""
+		switch {
+		case gitattributesMarked(rules, file):
+			reason = "marked " + gitattributesValue + " in .gitattributes"
+		case path.Ext(file) == ".age":
+			reason = "has the .age extension"
+		default:
+			reason = secretReason(file, data)
+		}
+		if reason == "" {
+			return result, false
+		}
+		result.State = statusPlain
+		result.Detail = "not encrypted, " + reason
+		return result, true
+	}
+
+	result.State, result.Detail = c.checkEncrypted(data)
+	return result, true
+}
+
+// looksEncrypted reports whether data is an age file, armored or not, possibly behind an
+// age-vault envelope or metadata header.
+func looksEncrypted(data []byte) bool {
+	return isAgeEncrypted(data) || vault.IsEnvelope(data) || vault.HasFileHeader(data)
+}
+
+// checkEncrypted decrypts an encrypted file with the key it is meant for and returns its
+// state and a detail.
+func (c *statusChecker) checkEncrypted(data []byte) (string, string) {
+	if vault.IsEnvelope(data) {
+		return statusVaultKey, ""
+	}
+	if trimmed := bytes.TrimSpace(data); bytes.HasPrefix(trimmed, []byte(armor.Header)) {
+		decoded, err := io.ReadAll(armor.NewReader(bytes.NewReader(trimmed)))
+		if err != nil {
+			return statusFailed, fmt.Sprintf("malformed armor: %v", err)
+		}
+		data = decoded
+	}
+
+	payload := data
+	if header, rest, err := vault.ParseFileHeader(data); err != nil {
+		return statusFailed, err.Error()
+	} else if header != nil {
+		payload = rest
+	}
+	header, err := age.ExtractHeader(bytes.NewReader(payload))
+	if err != nil {
+		return statusFailed, fmt.Sprintf("not an age encrypted file: %v", err)
+	}
+
+	// Vault keys without an envelope are recognized by their stanzas
+	if hasVaultKeyStanzas(header) {
+		return statusVaultKey, ""
+	}
+
+	key := c.vaultKey
+	name := vault.SubvaultName(payload)
+	if name != "" {
+		subvaultKey, err := c.subvault(name)
+		if err != nil {
+			return statusSkipped, err.Error()
+		}
+		key = subvaultKey
+	}
+
+	if err := key.Decrypt(bytes.NewReader(data), io.Discard); err != nil {
+		return statusFailed, err.Error()
+	}
+
+	detail := ""
+	if name != "" {
+		detail = "sub-vault " + name + ", "
+	}
+	generations := key.KeyGenerations()
+	for i, identity := range key.Identities() {
+		if _, err := age.DecryptHeader(header, identity); err == nil {
+			generation := generations[i]
+			if i == 0 {
+				return statusCurrent, fmt.Sprintf("%sgeneration %d", detail, generation)
+			}
+			return statusRetired, fmt.Sprintf("%sgeneration %d, current is %d", detail, generation, key.Generation())
+		}
+	}
+	return statusFailed, "decrypted with a key not in the keyring"
+}
+
+// subvault opens a sub-vault key, remembering failures so they are reported once per file
+// without retrying.
+func (c *statusChecker) subvault(name string) (*vault.VaultKey, error) {
+	if key, ok := c.subvaults[name]; ok {
+		return key, nil
+	}
+	if err, ok := c.errors[name]; ok {
+		return nil, err
+	}
+	key, err := openSubvault(name, c.cfg)
+	if err != nil {
+		c.errors[name] = err
+		return nil, err
+	}
+	c.subvaults[name] = key
+	return key, nil
+}
+
+// secretNames are base names of files that usually hold secrets.
+var secretNames = map[string]bool{
+	".env":       true,
+	".netrc":     true,
+	"id_rsa":     true,
+	"id_dsa":     true,
+	"id_ecdsa":   true,
+	"id_ed25519": true,
+}
+
+// secretExtensions are extensions of files that usually hold secrets.
+var secretExtensions = map[string]bool{
+	".key":      true,
+	".p12":      true,
+	".pfx":      true,
+	".jks":      true,
+	".keystore": true,
+}
+
+// secretReason returns why a plaintext file looks like it should be encrypted, or "" if it
+// does not.
+func secretReason(file string, data []byte) string {
+	base := path.Base(file)
+	if secretNames[base] || secretExtensions[path.Ext(base)] {
+		return "file name suggests a secret"
+	}
+	if strings.HasPrefix(base, ".env.") {
+		switch path.Ext(base) {
+		case ".example", ".sample", ".template", ".dist":
+		default:
+			return "file name suggests a secret"
+		}
+	}
+
+	// Only lines starting with a key count, so code mentioning them is not reported
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "AGE-SECRET-KEY-"):
+			return "contains an age secret key"
+		case strings.HasPrefix(line, "-----BEGIN ") && strings.HasSuffix(line, "PRIVATE KEY-----"):
+			return "contains a private key"
+		}
+	}
+	return ""
+}
+
+// gitattributesRule is a line of a .gitattributes file that sets or unsets the age-vault
+// attribute value.
+type gitattributesRule struct {
+	dir     string // Directory of the .gitattributes file, relative to the scanned root
+	pattern string
+	marked  bool
+}
+
+// loadGitattributes reads the rules of the .gitattributes files among files, in order.
+func loadGitattributes(dir string, files []string) ([]gitattributesRule, error) {
+	var rules []gitattributesRule
+	for _, file := range files {
+		if path.Base(file) != ".gitattributes" {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(file)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", file, err)
+		}
+		rules = append(rules, parseGitattributes(path.Dir(file), string(content))...)
+	}
+	return rules, nil
+}
+
+// parseGitattributes parses the content of a .gitattributes file found in dir.
+func parseGitattributes(dir string, content string) []gitattributesRule {
+	var rules []gitattributesRule
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		for _, attr := range fields[1:] {
+			switch {
+			case attr == gitattributesValue || strings.HasSuffix(attr, "="+gitattributesValue):
+				rules = append(rules, gitattributesRule{dir: dir, pattern: fields[0], marked: true})
+			case attr == "-"+gitattributesValue || attr == "!"+gitattributesValue:
+				rules = append(rules, gitattributesRule{dir: dir, pattern: fields[0], marked: false})
+			default:
+				continue
+			}
+			break
+		}
+	}
+	return rules
+}
+
+// gitattributesMarked reports whether file is marked age-vault, the last matching rule
+// winning as in git.
+func gitattributesMarked(rules []gitattributesRule, file string) bool {
+	marked := false
+	for _, rule := range rules {
+		rel := file
+		if rule.dir != "." {
+			if !strings.HasPrefix(file, rule.dir+"/") {
+				continue
+			}
+			rel = strings.TrimPrefix(file, rule.dir+"/")
+		}
+		if matchGitattributesPattern(rule.pattern, rel) {
+			marked = rule.marked
+		}
+	}
+	return marked
+}
+
+// matchGitattributesPattern matches a path against a .gitattributes pattern. Patterns
+// without a slash match the base name at any depth, others match the whole path relative to
+// the .gitattributes file, with ** matching any number of directories.
+func matchGitattributesPattern(pattern, rel string) bool {
+	if !strings.Contains(pattern, "/") {
+		matched, _ := path.Match(pattern, path.Base(rel))
+		return matched
+	}
+	return matchSegments(strings.Split(strings.TrimPrefix(pattern, "/"), "/"), strings.Split(rel, "/"))
+}
+
+// matchSegments matches path segments against pattern segments.
+func matchSegments(pattern, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchSegments(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	matched, _ := path.Match(pattern[0], segments[0])
+	return matched && matchSegments(pattern[1:], segments[1:])
+}
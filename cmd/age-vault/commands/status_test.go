@@ -0,0 +1,153 @@
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/leolimasa/age-vault/config"
+	"github.com/leolimasa/age-vault/vault"
+)
+
+func TestRunStatus(t *testing.T) {
+	tempDir := t.TempDir()
+	repoDir := filepath.Join(tempDir, "repo")
+
+	identityPath := filepath.Join(tempDir, "identity.txt")
+	identity := writeTestIdentity(t, identityPath)
+
+	// A keyring with a current and a retired key, stored in the repository
+	oldIdentity, err := vault.GenerateVaultKey()
+	if err != nil {
+		t.Fatalf("failed to generate vault key: %v", err)
+	}
+	newIdentity, err := vault.GenerateVaultKey()
+	if err != nil {
+		t.Fatalf("failed to generate vault key: %v", err)
+	}
+	vaultKey := vault.NewVaultKey(newIdentity, oldIdentity)
+	encryptedKey, err := vault.EncryptVaultKeyring(vaultKey, identity.Recipient())
+	if err != nil {
+		t.Fatalf("failed to encrypt vault keyring: %v", err)
+	}
+	vaultKeyPath := filepath.Join(repoDir, "vault_key.age")
+	if err := os.MkdirAll(repoDir, 0700); err != nil {
+		t.Fatalf("failed to create repository: %v", err)
+	}
+	if err := os.WriteFile(vaultKeyPath, encryptedKey, 0600); err != nil {
+		t.Fatalf("failed to write vault key file: %v", err)
+	}
+
+	writeTestSecret(t, vaultKey, filepath.Join(repoDir, "secrets", "current.age"), "current")
+	writeTestSecret(t, vault.NewVaultKey(oldIdentity), filepath.Join(repoDir, "secrets", "old.age"), "old")
+
+	corruptPath := filepath.Join(repoDir, "secrets", "corrupt.bin")
+	writeTestSecret(t, vaultKey, corruptPath, strings.Repeat("corrupt", 100))
+	corrupt, err := os.ReadFile(corruptPath)
+	if err != nil {
+		t.Fatalf("failed to read secret: %v", err)
+	}
+	if err := os.WriteFile(corruptPath, corrupt[:len(corrupt)-10], 0600); err != nil {
+		t.Fatalf("failed to truncate secret: %v", err)
+	}
+
+	writeFile := func(name, content string) {
+		t.Helper()
+		path := filepath.Join(repoDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	writeFile(".gitattributes", "*.env filter=age-vault diff=age-vault\nconfig/public.env -filter !age-vault\n")
+	writeFile("config/app.env", "PASSWORD=hunter2\n")
+	writeFile("config/public.env", "COLOR=blue\n")
+	writeFile("secrets/plain.age", "not encrypted\n")
+	writeFile("deploy/id_ed25519", "key\n")
+	writeFile("deploy/identity.txt", "# created: today\n"+identity.String()+"\n")
+	writeFile("src/keys_test.go", "var key = \"AGE-SECRET-KEY-1EXAMPLE\"\n")
+	writeFile("README.md", "# Project\n")
+	writeFile(".hidden/secret.key", "hidden directories are skipped\n")
+
+	cfg := &config.Config{IdentityFile: identityPath, VaultKeyFile: vaultKeyPath, ConfigDir: repoDir}
+
+	files, err := listStatusFiles(repoDir)
+	if err != nil {
+		t.Fatalf("listStatusFiles failed: %v", err)
+	}
+	rules, err := loadGitattributes(repoDir, files)
+	if err != nil {
+		t.Fatalf("loadGitattributes failed: %v", err)
+	}
+	checker := &statusChecker{cfg: cfg, vaultKey: vaultKey, subvaults: map[string]*vault.VaultKey{}, errors: map[string]error{}}
+
+	states := map[string]string{}
+	for _, file := range files {
+		if result, ok := checker.check(repoDir, file, rules); ok {
+			states[file] = result.State
+		}
+	}
+
+	expected := map[string]string{
+		"vault_key.age":       statusFailed, // Excluded by RunStatus, not by check
+		"secrets/current.age": statusCurrent,
+		"secrets/old.age":     statusRetired,
+		"secrets/corrupt.bin": statusFailed,
+		"secrets/plain.age":   statusPlain,
+		"config/app.env":      statusPlain,
+		"deploy/id_ed25519":   statusPlain,
+		"deploy/identity.txt": statusPlain,
+	}
+	for file, state := range expected {
+		if states[file] != state {
+			t.Errorf("expected %s to be %q, got %q", file, state, states[file])
+		}
+	}
+	for file, state := range states {
+		if _, ok := expected[file]; !ok {
+			t.Errorf("unexpected report for %s: %q", file, state)
+		}
+	}
+
+	// Problems make the command fail
+	err = RunStatus("", cfg)
+	if err == nil || !strings.Contains(err.Error(), "6 problem(s)") {
+		t.Errorf("expected 6 problems, got: %v", err)
+	}
+
+	// Once fixed, it succeeds
+	for _, file := range []string{"secrets/old.age", "secrets/corrupt.bin", "secrets/plain.age", "config/app.env", "deploy/id_ed25519", "deploy/identity.txt"} {
+		if err := os.Remove(filepath.Join(repoDir, filepath.FromSlash(file))); err != nil {
+			t.Fatalf("failed to remove %s: %v", file, err)
+		}
+	}
+	if err := RunStatus(repoDir, cfg); err != nil {
+		t.Errorf("RunStatus failed on a clean repository: %v", err)
+	}
+}
+
+func TestMatchGitattributesPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"*.env", "app.env", true},
+		{"*.env", "config/app.env", true},
+		{"*.env", "app.env.example", false},
+		{"/secrets/*", "secrets/a.txt", true},
+		{"secrets/*", "secrets/sub/a.txt", false},
+		{"secrets/**", "secrets/sub/a.txt", true},
+		{"**/secrets/*.json", "a/b/secrets/db.json", true},
+		{"**/secrets/*.json", "secrets/db.json", true},
+		{"config/prod.yml", "other/config/prod.yml", false},
+	}
+	for _, tt := range tests {
+		if got := matchGitattributesPattern(tt.pattern, tt.path); got != tt.want {
+			t.Errorf("matchGitattributesPattern(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
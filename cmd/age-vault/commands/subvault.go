@@ -0,0 +1,182 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/leolimasa/age-vault/config"
+	"github.com/leolimasa/age-vault/keymgmt"
+	"github.com/leolimasa/age-vault/members"
+	"github.com/leolimasa/age-vault/vault"
+)
+
+// RunSubvaultCreate handles the subvault create command.
+// It generates the vault key of a new sub-vault and encrypts it to the given registered
+// members and, with parent, to the key of its parent (the vault key, or the parent sub-vault
+// for nested names like prod/db), which gives every member of the parent access.
+// keyType selects the type of the sub-vault key ("x25519" or "hybrid"); by default it is the
+// type of the parent key, so a post-quantum vault keeps post-quantum sub-vaults.
+func RunSubvaultCreate(name string, memberNames []string, parent bool, keyType string, cfg *config.Config) error {
+	if err := vault.ValidateSubvaultName(name); err != nil {
+		return err
+	}
+	if len(memberNames) == 0 && !parent {
+		return fmt.Errorf("at least one --member or --parent must be provided")
+	}
+
+	path := subvaultKeyPath(name, cfg)
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("sub-vault %s already exists at %s", name, path)
+	}
+	if parentName := vault.SubvaultParent(name); parentName != "" {
+		if _, err := os.Stat(subvaultKeyPath(parentName, cfg)); err != nil {
+			return fmt.Errorf("parent sub-vault %s does not exist", parentName)
+		}
+	}
+
+	// Resolve the public keys of the members, named for errors about mixing kinds
+	var recipients []age.Recipient
+	var recipientNames []string
+	if len(memberNames) > 0 {
+		registry, err := members.Load(cfg.RecipientsFile)
+		if err != nil {
+			return fmt.Errorf("failed to load recipients registry: %w", err)
+		}
+		for _, memberName := range memberNames {
+			member, ok := registry.Get(memberName)
+			if !ok {
+				return fmt.Errorf("%s is not a registered member", memberName)
+			}
+			memberRecipients, err := keymgmt.ParseRecipients([]byte(member.Recipient))
+			if err != nil {
+				return fmt.Errorf("failed to parse public key of member %s: %w", memberName, err)
+			}
+			for range memberRecipients {
+				recipientNames = append(recipientNames, "member "+memberName)
+			}
+			recipients = append(recipients, memberRecipients...)
+		}
+	}
+
+	// The parent key gives the default key type, and access to the members of the parent
+	var parentKey *vault.VaultKey
+	var err error
+	if parent || keyType == "" {
+		parentKey, err = openSubvaultParent(name, cfg)
+		if err != nil {
+			if parent {
+				return err
+			}
+			return fmt.Errorf("cannot open the parent key to get its type, give --key-type: %w", err)
+		}
+	}
+	if parent {
+		parentRecipient, _, err := parentKey.Recipient()
+		if err != nil {
+			return fmt.Errorf("failed to get parent public key: %w", err)
+		}
+		recipients = append(recipients, parentRecipient)
+		recipientNames = append(recipientNames, "the parent key")
+	}
+
+	// age refuses to encrypt to post-quantum and classic recipients at once
+	if err := vault.CheckRecipientKinds(recipients); err != nil {
+		var postQuantum, classic []string
+		for i, r := range recipients {
+			if vault.IsPostQuantum(r) {
+				postQuantum = append(postQuantum, recipientNames[i])
+			} else {
+				classic = append(classic, recipientNames[i])
+			}
+		}
+		return fmt.Errorf("cannot give access to both post-quantum (hybrid) recipients (%s) and classic recipients (%s): age refuses to mix them, create the sub-vault for recipients of a single kind",
+			strings.Join(postQuantum, ", "), strings.Join(classic, ", "))
+	}
+
+	var subvaultKeyType vault.KeyType
+	if keyType != "" {
+		subvaultKeyType, err = vault.ParseKeyType(keyType)
+	} else {
+		var parentIdentity age.Identity
+		parentIdentity, err = parentKey.GetIdentity()
+		if err == nil {
+			subvaultKeyType, err = vault.KeyTypeOf(parentIdentity)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	subvaultIdentity, err := vault.GenerateVaultKeyOfType(subvaultKeyType)
+	if err != nil {
+		return fmt.Errorf("failed to generate sub-vault key: %w", err)
+	}
+	encryptedKey, err := encryptVaultKeyring(vault.NewVaultKey(subvaultIdentity), "sub-vault "+name, cfg, recipients...)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt sub-vault key: %w", err)
+	}
+	if err := keymgmt.WriteFileAtomic(path, encryptedKey); err != nil {
+		return fmt.Errorf("failed to save sub-vault key: %w", err)
+	}
+
+	fmt.Printf("Sub-vault key saved to: %s\n", path)
+	return nil
+}
+
+// subvaultKeyPath returns the path of the encrypted key of the sub-vault name.
+func subvaultKeyPath(name string, cfg *config.Config) string {
+	return filepath.Join(cfg.SubvaultsDir, filepath.FromSlash(name)+".age")
+}
+
+// openSubvault decrypts the key of the sub-vault name. Members open it with their own
+// identity; otherwise the chain of parent keys it may be encrypted to is unwrapped, up to
+// the vault key.
+func openSubvault(name string, cfg *config.Config) (*vault.VaultKey, error) {
+	if err := vault.ValidateSubvaultName(name); err != nil {
+		return nil, err
+	}
+
+	encryptedKey, err := os.ReadFile(subvaultKeyPath(name, cfg))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("sub-vault %s does not exist", name)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read sub-vault key: %w", err)
+	}
+
+	// Members of the sub-vault
+	identities, err := keymgmt.LoadIdentities(cfg.IdentityFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load identity: %w", err)
+	}
+	if subvaultKey, err := vault.DecryptVaultKey(encryptedKey, identities...); err == nil {
+		return subvaultKey, nil
+	}
+
+	// Members of the parent, if the sub-vault key is encrypted to it
+	parentKey, err := openSubvaultParent(name, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("not a member of sub-vault %s", name)
+	}
+	subvaultKey, err := vault.DecryptVaultKey(encryptedKey, parentKey)
+	if err != nil {
+		return nil, fmt.Errorf("not a member of sub-vault %s", name)
+	}
+	return subvaultKey, nil
+}
+
+// openSubvaultParent decrypts the key of the parent of the sub-vault name: the parent
+// sub-vault for nested names, the vault key otherwise.
+func openSubvaultParent(name string, cfg *config.Config) (*vault.VaultKey, error) {
+	if parentName := vault.SubvaultParent(name); parentName != "" {
+		return openSubvault(parentName, cfg)
+	}
+
+	vaultKey, err := keymgmt.VaultKeyFromIdentityFile(cfg.IdentityFile, cfg.VaultKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load vault key: %w", err)
+	}
+	return vaultKey, nil
+}
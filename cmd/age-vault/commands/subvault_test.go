@@ -0,0 +1,167 @@
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/leolimasa/age-vault/config"
+	"github.com/leolimasa/age-vault/members"
+	"github.com/leolimasa/age-vault/vault"
+)
+
+func TestSubvaults(t *testing.T) {
+	tempDir := t.TempDir()
+
+	// A vault member and a CI machine without the vault key
+	identityPath := filepath.Join(tempDir, "identity.txt")
+	identity := writeTestIdentity(t, identityPath)
+	vaultKeyPath := filepath.Join(tempDir, "vault_key.age")
+	writeTestVaultKey(t, identity, vaultKeyPath)
+	ciIdentityPath := filepath.Join(tempDir, "ci", "identity.txt")
+	ciIdentity := writeTestIdentity(t, ciIdentityPath)
+
+	recipientsPath := filepath.Join(tempDir, "age_vault_recipients.yml")
+	registry := &members.Registry{}
+	if err := registry.Add("ci", ciIdentity.Recipient().String()); err != nil {
+		t.Fatalf("failed to add member: %v", err)
+	}
+	if err := registry.Save(recipientsPath); err != nil {
+		t.Fatalf("failed to save registry: %v", err)
+	}
+
+	cfg := &config.Config{
+		IdentityFile:   identityPath,
+		VaultKeyFile:   vaultKeyPath,
+		RecipientsFile: recipientsPath,
+		SubvaultsDir:   filepath.Join(tempDir, "subvaults"),
+	}
+	ciCfg := &config.Config{
+		IdentityFile:   ciIdentityPath,
+		VaultKeyFile:   filepath.Join(tempDir, "ci", "vault_key.age"),
+		RecipientsFile: recipientsPath,
+		SubvaultsDir:   cfg.SubvaultsDir,
+	}
+
+	// ci is only open to the CI machine, prod to the vault and prod/db to prod
+	if err := RunSubvaultCreate("ci", []string{"ci"}, false, "", cfg); err != nil {
+		t.Fatalf("RunSubvaultCreate(ci) failed: %v", err)
+	}
+	if err := RunSubvaultCreate("prod", nil, true, "", cfg); err != nil {
+		t.Fatalf("RunSubvaultCreate(prod) failed: %v", err)
+	}
+	if err := RunSubvaultCreate("prod/db", nil, true, "", cfg); err != nil {
+		t.Fatalf("RunSubvaultCreate(prod/db) failed: %v", err)
+	}
+	if err := RunSubvaultCreate("prod", nil, true, "", cfg); err == nil {
+		t.Error("expected error creating an existing sub-vault")
+	}
+	if err := RunSubvaultCreate("staging/db", nil, true, "", cfg); err == nil {
+		t.Error("expected error creating a sub-vault without its parent")
+	}
+
+	encryptDecrypt := func(subvault string, encryptCfg, decryptCfg *config.Config) error {
+		t.Helper()
+		plainPath := filepath.Join(tempDir, "plain.txt")
+		if err := os.WriteFile(plainPath, []byte("secret"), 0600); err != nil {
+			t.Fatalf("failed to write plaintext: %v", err)
+		}
+		encryptedPath := filepath.Join(tempDir, "secret.age")
+		if err := RunEncrypt(plainPath, encryptedPath, subvault, false, "", encryptCfg); err != nil {
+			t.Fatalf("RunEncrypt(%s) failed: %v", subvault, err)
+		}
+		decryptedPath := filepath.Join(tempDir, "decrypted.txt")
+		if err := RunDecrypt(encryptedPath, decryptedPath, decryptCfg); err != nil {
+			return err
+		}
+		decrypted, err := os.ReadFile(decryptedPath)
+		if err != nil {
+			t.Fatalf("failed to read decrypted file: %v", err)
+		}
+		if string(decrypted) != "secret" {
+			t.Errorf("decrypted %q, want secret", decrypted)
+		}
+		return nil
+	}
+
+	// The vault member unwraps the chain of parents
+	if err := encryptDecrypt("prod/db", cfg, cfg); err != nil {
+		t.Errorf("decrypting prod/db as vault member failed: %v", err)
+	}
+
+	// The CI machine opens its sub-vault without the vault key, and nothing else
+	if err := encryptDecrypt("ci", ciCfg, ciCfg); err != nil {
+		t.Errorf("decrypting ci as CI machine failed: %v", err)
+	}
+	if err := encryptDecrypt("ci", ciCfg, cfg); err == nil || !strings.Contains(err.Error(), "not a member of sub-vault ci") {
+		t.Errorf("expected not a member error for the vault member, got: %v", err)
+	}
+	if err := RunEncrypt("", filepath.Join(tempDir, "out.age"), "prod", false, "", ciCfg); err == nil || !strings.Contains(err.Error(), "not a member of sub-vault prod") {
+		t.Errorf("expected not a member error for the CI machine, got: %v", err)
+	}
+}
+
+func TestSubvaults_HybridParent(t *testing.T) {
+	tempDir := t.TempDir()
+
+	// A post-quantum vault
+	identityPath := filepath.Join(tempDir, "identity.txt")
+	identity := writeTestIdentity(t, identityPath)
+	hybridKey, err := vault.GenerateVaultKeyOfType(vault.KeyTypeHybrid)
+	if err != nil {
+		t.Fatalf("failed to generate vault key: %v", err)
+	}
+	encryptedKey, err := vault.EncryptVaultKey(hybridKey, identity.Recipient())
+	if err != nil {
+		t.Fatalf("failed to encrypt vault key: %v", err)
+	}
+	vaultKeyPath := filepath.Join(tempDir, "vault_key.age")
+	if err := os.WriteFile(vaultKeyPath, encryptedKey, 0600); err != nil {
+		t.Fatalf("failed to write vault key file: %v", err)
+	}
+
+	ciIdentityPath := filepath.Join(tempDir, "ci", "identity.txt")
+	ciIdentity := writeTestIdentity(t, ciIdentityPath)
+	recipientsPath := filepath.Join(tempDir, "age_vault_recipients.yml")
+	registry := &members.Registry{}
+	if err := registry.Add("ci", ciIdentity.Recipient().String()); err != nil {
+		t.Fatalf("failed to add member: %v", err)
+	}
+	if err := registry.Save(recipientsPath); err != nil {
+		t.Fatalf("failed to save registry: %v", err)
+	}
+
+	cfg := &config.Config{
+		IdentityFile:   identityPath,
+		VaultKeyFile:   vaultKeyPath,
+		RecipientsFile: recipientsPath,
+		SubvaultsDir:   filepath.Join(tempDir, "subvaults"),
+	}
+
+	// Sub-vaults keep the post-quantum key type of their parent
+	if err := RunSubvaultCreate("prod", nil, true, "", cfg); err != nil {
+		t.Fatalf("RunSubvaultCreate(prod) failed: %v", err)
+	}
+	if err := RunSubvaultCreate("ci", []string{"ci"}, false, "", cfg); err != nil {
+		t.Fatalf("RunSubvaultCreate(ci) failed: %v", err)
+	}
+	ciCfg := *cfg
+	ciCfg.IdentityFile = ciIdentityPath
+	for name, memberCfg := range map[string]*config.Config{"prod": cfg, "ci": &ciCfg} {
+		subvaultKey, err := openSubvault(name, memberCfg)
+		if err != nil {
+			t.Fatalf("failed to open sub-vault %s: %v", name, err)
+		}
+		subvaultIdentity, _ := subvaultKey.GetIdentity()
+		if keyType, err := vault.KeyTypeOf(subvaultIdentity); err != nil || keyType != vault.KeyTypeHybrid {
+			t.Errorf("sub-vault %s has key type %q (%v), want hybrid", name, keyType, err)
+		}
+	}
+
+	// A classic member and the post-quantum parent key cannot be mixed
+	err = RunSubvaultCreate("mixed", []string{"ci"}, true, "", cfg)
+	if err == nil || !strings.Contains(err.Error(), "member ci") || !strings.Contains(err.Error(), "the parent key") {
+		t.Errorf("expected an error naming the mixed recipients, got: %v", err)
+	}
+}
@@ -0,0 +1,153 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/leolimasa/age-vault/config"
+	"github.com/leolimasa/age-vault/keymgmt"
+	"github.com/leolimasa/age-vault/vault"
+)
+
+// encryptVaultKeyring encrypts a vault key for the given recipients and for every configured
+// escrow recipient, in an envelope labeled with label (see keymgmt.EncryptVaultKeyringLayers).
+func encryptVaultKeyring(vaultKey *vault.VaultKey, label string, cfg *config.Config, recipients ...age.Recipient) ([]byte, error) {
+	return keymgmt.EncryptVaultKeyringLayers(vaultKey, label, cfg, recipients)
+}
+
+// selfLabel labels the vault key files of our own identity with the hostname.
+func selfLabel() string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		return "self"
+	}
+	return hostname
+}
+
+// RunVaultKeyAudit handles the vault-key audit command.
+// It checks that the encrypted vault key files in paths (files, or directories scanned
+// recursively), or the configured vault key file if no paths are given, are encrypted to
+// every configured escrow recipient. Fails if any file is missing one.
+func RunVaultKeyAudit(paths []string, cfg *config.Config) error {
+	if len(cfg.EscrowRecipients) == 0 {
+		return fmt.Errorf("no escrow_recipients configured in age_vault.yml")
+	}
+	if len(paths) == 0 {
+		paths = []string{cfg.VaultKeyFile}
+	}
+
+	files, err := vaultKeyFiles(paths)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no encrypted vault key files found")
+	}
+
+	failed := 0
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", file, err)
+		}
+
+		present := map[string]bool{}
+		for _, id := range vault.EscrowIDs(data) {
+			present[id] = true
+		}
+
+		var missing []string
+		for _, pubkey := range cfg.EscrowRecipients {
+			if !present[keymgmt.EscrowID(pubkey)] {
+				missing = append(missing, pubkey)
+			}
+		}
+
+		if len(missing) == 0 {
+			fmt.Printf("OK       %s\n", file)
+			continue
+		}
+		failed++
+		for _, pubkey := range missing {
+			fmt.Printf("MISSING  %s (escrow recipient %s)\n", file, pubkey)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d vault key file(s) are not encrypted to every escrow recipient (re-encrypt them with 'age-vault vault-key encrypt')", failed, len(files))
+	}
+	return nil
+}
+
+// vaultKeyFiles expands paths into the vault key files they contain. Files are taken as-is;
+// directories are scanned recursively for vault key files (see isVaultKeyFile), skipping
+// hidden directories such as .git.
+func vaultKeyFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to access %s: %w", path, err)
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				if p != path && len(d.Name()) > 1 && d.Name()[0] == '.' {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			data, err := os.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			if isVaultKeyFile(data) {
+				files = append(files, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan %s: %w", path, err)
+		}
+	}
+	return files, nil
+}
+
+// isVaultKeyFile reports whether data is an encrypted vault key rather than data encrypted
+// with the vault key: it has an envelope, or, for vault keys written before envelopes, a
+// passphrase or escrow stanza.
+func isVaultKeyFile(data []byte) bool {
+	if vault.IsEnvelope(data) {
+		return true
+	}
+	header, err := age.ExtractHeader(bytes.NewReader(data))
+	if err != nil {
+		return false
+	}
+	return hasVaultKeyStanzas(header)
+}
+
+// hasVaultKeyStanzas reports whether an age header has the stanzas only vault keys carry.
+func hasVaultKeyStanzas(header []byte) bool {
+	for _, line := range strings.Split(string(header), "\n") {
+		if strings.HasPrefix(line, "-> "+vault.PassphraseStanzaType) || strings.HasPrefix(line, "-> "+vault.EscrowStanzaType) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,135 @@
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+
+	"github.com/leolimasa/age-vault/config"
+	"github.com/leolimasa/age-vault/vault"
+)
+
+func TestRunVaultKeyAudit(t *testing.T) {
+	tempDir := t.TempDir()
+
+	identityPath := filepath.Join(tempDir, "identity.txt")
+	identity := writeTestIdentity(t, identityPath)
+	vaultKeyPath := filepath.Join(tempDir, "vault_key.age")
+	vaultKey := writeTestVaultKey(t, identity, vaultKeyPath)
+
+	escrowIdentity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("failed to generate escrow identity: %v", err)
+	}
+
+	cfg := &config.Config{
+		IdentityFile:     identityPath,
+		VaultKeyFile:     vaultKeyPath,
+		EscrowRecipients: []string{escrowIdentity.Recipient().String()},
+	}
+
+	// The vault key was written before escrow was configured
+	if err := RunVaultKeyAudit(nil, cfg); err == nil {
+		t.Fatal("expected audit to fail for a vault key without escrow")
+	}
+
+	// Re-encrypting the vault key adds the escrow recipient
+	if err := RunVaultKeyEncrypt(identity.Recipient().String(), "", nil, "", true, false, false, "", cfg); err != nil {
+		t.Fatalf("RunVaultKeyEncrypt failed: %v", err)
+	}
+	member, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("failed to generate member identity: %v", err)
+	}
+	memberKeyPath := filepath.Join(tempDir, "out", "member.age")
+	if err := RunVaultKeyEncrypt(member.Recipient().String(), "", nil, memberKeyPath, false, false, false, "", cfg); err != nil {
+		t.Fatalf("RunVaultKeyEncrypt failed: %v", err)
+	}
+
+	if err := RunVaultKeyAudit(nil, cfg); err != nil {
+		t.Errorf("audit of the configured vault key failed: %v", err)
+	}
+
+	// Data encrypted with the vault key and hidden directories are not audited
+	writeTestSecret(t, vaultKey, filepath.Join(tempDir, "out", "secrets", "token.age"), "token")
+	withoutEscrow, err := encryptVaultKeyring(vaultKey, "old", &config.Config{}, member.Recipient())
+	if err != nil {
+		t.Fatalf("failed to encrypt vault key: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(tempDir, "out", ".git"), 0700); err != nil {
+		t.Fatalf("failed to create .git: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, "out", ".git", "old.age"), withoutEscrow, 0600); err != nil {
+		t.Fatalf("failed to write old vault key: %v", err)
+	}
+	if err := RunVaultKeyAudit([]string{filepath.Join(tempDir, "out")}, cfg); err != nil {
+		t.Errorf("audit of the output directory failed: %v", err)
+	}
+
+	// The escrow identity can open the vault key
+	data, err := os.ReadFile(memberKeyPath)
+	if err != nil {
+		t.Fatalf("failed to read member vault key: %v", err)
+	}
+	if _, err := vault.DecryptVaultKey(data, escrowIdentity); err != nil {
+		t.Errorf("escrow identity cannot decrypt vault key: %v", err)
+	}
+
+	// A new escrow recipient is reported missing
+	other, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("failed to generate escrow identity: %v", err)
+	}
+	cfg.EscrowRecipients = append(cfg.EscrowRecipients, other.Recipient().String())
+	if err := RunVaultKeyAudit(nil, cfg); err == nil {
+		t.Error("expected audit to fail for a missing escrow recipient")
+	}
+}
+
+func TestRunVaultKeyAudit_NoEscrow(t *testing.T) {
+	cfg := &config.Config{VaultKeyFile: filepath.Join(t.TempDir(), "vault_key.age")}
+	if err := RunVaultKeyAudit(nil, cfg); err == nil {
+		t.Fatal("expected error without escrow recipients, got nil")
+	}
+}
+
+func TestEncryptVaultKeyringLayers_EscrowKind(t *testing.T) {
+	vaultKeyIdentity, err := vault.GenerateVaultKey()
+	if err != nil {
+		t.Fatalf("failed to generate vault key: %v", err)
+	}
+	vaultKey := vault.NewVaultKey(vaultKeyIdentity)
+
+	hybrid, err := age.GenerateHybridIdentity()
+	if err != nil {
+		t.Fatalf("failed to generate hybrid identity: %v", err)
+	}
+	classicEscrow, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("failed to generate escrow identity: %v", err)
+	}
+	hybridEscrow, err := age.GenerateHybridIdentity()
+	if err != nil {
+		t.Fatalf("failed to generate escrow identity: %v", err)
+	}
+
+	// A classic escrow key cannot be added next to a hybrid identity
+	cfg := &config.Config{EscrowRecipients: []string{classicEscrow.Recipient().String()}}
+	_, err = encryptVaultKeyring(vaultKey, "hybrid", cfg, hybrid.Recipient())
+	if err == nil || !strings.Contains(err.Error(), "escrow_recipients") {
+		t.Errorf("expected an escrow kind error, got: %v", err)
+	}
+
+	// A hybrid escrow key can
+	cfg.EscrowRecipients = []string{hybridEscrow.Recipient().String()}
+	encryptedKey, err := encryptVaultKeyring(vaultKey, "hybrid", cfg, hybrid.Recipient())
+	if err != nil {
+		t.Fatalf("encryptVaultKeyring failed: %v", err)
+	}
+	if _, err := vault.DecryptVaultKey(encryptedKey, hybridEscrow); err != nil {
+		t.Errorf("escrow identity cannot decrypt vault key: %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"filippo.io/age"
+	"github.com/leolimasa/age-vault/config"
+	"github.com/leolimasa/age-vault/keymgmt"
+	"github.com/leolimasa/age-vault/vault"
+)
+
+// RunVaultKeyBackup handles the vault-key backup command.
+// It decrypts the vault key and re-encrypts the whole keyring with a passphrase, in-process,
+// so the plaintext vault key never touches disk or a pipe.
+func RunVaultKeyBackup(outputPath string, cfg *config.Config) error {
+	vaultKey, err := keymgmt.VaultKeyFromIdentityFile(cfg.IdentityFile, cfg.VaultKeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load vault key: %w", err)
+	}
+
+	encryptedKey, err := encryptVaultKeyWithPassphrase(vaultKey)
+	if err != nil {
+		return err
+	}
+
+	if err := keymgmt.WriteFileAtomic(outputPath, encryptedKey); err != nil {
+		return fmt.Errorf("failed to write vault key backup: %w", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Vault key backup saved to %s\n", outputPath)
+	return nil
+}
+
+// RunVaultKeyRestore handles the vault-key restore command.
+// It decrypts a passphrase-protected backup made by vault-key backup and re-encrypts the vault
+// key for the configured identity, installing it like vault-key set.
+func RunVaultKeyRestore(backupPath string, force bool, cfg *config.Config) error {
+	backup, err := os.ReadFile(backupPath)
+	if err != nil {
+		return fmt.Errorf("failed to read vault key backup: %w", err)
+	}
+
+	vaultKey, err := decryptVaultKeyWithPassphrase(backup)
+	if err != nil {
+		return err
+	}
+
+	if err := saveVaultKeyForSelf(vaultKey, force, cfg); err != nil {
+		return err
+	}
+
+	fmt.Printf("Vault key restored to: %s\n", cfg.VaultKeyFile)
+	return nil
+}
+
+// encryptVaultKeyWithPassphrase asks for a new passphrase and encrypts the keyring with it.
+func encryptVaultKeyWithPassphrase(vaultKey *vault.VaultKey) ([]byte, error) {
+	passphrase, err := readNewPassphrase()
+	if err != nil {
+		return nil, err
+	}
+
+	recipient, err := age.NewScryptRecipient(passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create passphrase recipient: %w", err)
+	}
+
+	encryptedKey, err := vault.EncryptVaultKeyring(vaultKey, recipient)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt vault key with passphrase: %w", err)
+	}
+	return encryptedKey, nil
+}
+
+// decryptVaultKeyWithPassphrase asks for the passphrase of a backup and decrypts it.
+func decryptVaultKeyWithPassphrase(encryptedKey []byte) (*vault.VaultKey, error) {
+	passphrase, err := keymgmt.ReadPassphrase("Enter backup passphrase")
+	if err != nil {
+		return nil, err
+	}
+
+	identity, err := age.NewScryptIdentity(passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create passphrase identity: %w", err)
+	}
+
+	vaultKey, err := vault.DecryptVaultKey(encryptedKey, identity)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt vault key backup (wrong passphrase?): %w", err)
+	}
+	return vaultKey, nil
+}
+
+// saveVaultKeyForSelf encrypts a recovered vault key for the configured identity and installs
+// it with keymgmt.SaveVaultKeyForIdentity, after the same checks as vault-key set.
+func saveVaultKeyForSelf(vaultKey *vault.VaultKey, force bool, cfg *config.Config) error {
+	if err := guardVaultKeyReplacement(checkSameVault(vaultKey, cfg), force, cfg); err != nil {
+		return err
+	}
+	if err := backupVaultKey(cfg); err != nil {
+		return err
+	}
+	return keymgmt.SaveVaultKeyForIdentity(vaultKey, selfLabel(), cfg.VaultKeyFile, cfg)
+}
@@ -0,0 +1,116 @@
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+
+	"github.com/leolimasa/age-vault/config"
+	"github.com/leolimasa/age-vault/keymgmt"
+)
+
+// withPassphrases makes keymgmt.ReadPassphrase return the given passphrases in order for the rest of the test.
+func withPassphrases(t *testing.T, passphrases ...string) {
+	t.Helper()
+
+	original := keymgmt.ReadPassphrase
+	t.Cleanup(func() { keymgmt.ReadPassphrase = original })
+	keymgmt.ReadPassphrase = func(prompt string) (string, error) {
+		if len(passphrases) == 0 {
+			t.Fatalf("unexpected passphrase prompt: %s", prompt)
+		}
+		passphrase := passphrases[0]
+		passphrases = passphrases[1:]
+		return passphrase, nil
+	}
+}
+
+func TestRunVaultKeyBackupRestore(t *testing.T) {
+	tempDir := t.TempDir()
+	const passphrase = "correct horse battery staple"
+
+	identityPath := filepath.Join(tempDir, "identity.txt")
+	identity := writeTestIdentity(t, identityPath)
+	vaultKeyPath := filepath.Join(tempDir, "vault_key.age")
+	vaultKey := writeTestVaultKey(t, identity, vaultKeyPath)
+	vaultKeyIdentity, _ := vaultKey.GetIdentity()
+
+	cfg := &config.Config{
+		IdentityFile: identityPath,
+		VaultKeyFile: vaultKeyPath,
+	}
+
+	backupPath := filepath.Join(tempDir, "backup.age")
+	withPassphrases(t, passphrase, passphrase)
+	if err := RunVaultKeyBackup(backupPath, cfg); err != nil {
+		t.Fatalf("RunVaultKeyBackup failed: %v", err)
+	}
+
+	// Restore on a new machine with another identity
+	newIdentityPath := filepath.Join(tempDir, "new", "identity.txt")
+	writeTestIdentity(t, newIdentityPath)
+	newCfg := &config.Config{
+		IdentityFile: newIdentityPath,
+		VaultKeyFile: filepath.Join(tempDir, "new", "vault_key.age"),
+	}
+
+	withPassphrases(t, "wrong passphrase")
+	if err := RunVaultKeyRestore(backupPath, false, newCfg); err == nil {
+		t.Fatal("expected restore with a wrong passphrase to fail")
+	}
+
+	withPassphrases(t, passphrase)
+	if err := RunVaultKeyRestore(backupPath, false, newCfg); err != nil {
+		t.Fatalf("RunVaultKeyRestore failed: %v", err)
+	}
+
+	restored, err := keymgmt.VaultKeyFromIdentityFile(newCfg.IdentityFile, newCfg.VaultKeyFile)
+	if err != nil {
+		t.Fatalf("failed to load restored vault key: %v", err)
+	}
+	restoredIdentity, _ := restored.GetIdentity()
+	if restoredIdentity.(*age.X25519Identity).String() != vaultKeyIdentity.(*age.X25519Identity).String() {
+		t.Error("restored vault key differs from the original")
+	}
+}
+
+func TestRunVaultKeyBackup_RejectsBadPassphrases(t *testing.T) {
+	tempDir := t.TempDir()
+
+	identityPath := filepath.Join(tempDir, "identity.txt")
+	identity := writeTestIdentity(t, identityPath)
+	vaultKeyPath := filepath.Join(tempDir, "vault_key.age")
+	writeTestVaultKey(t, identity, vaultKeyPath)
+
+	cfg := &config.Config{
+		IdentityFile: identityPath,
+		VaultKeyFile: vaultKeyPath,
+	}
+
+	tests := []struct {
+		name        string
+		passphrases []string
+		wantErr     string
+	}{
+		{"too short", []string{"short"}, "too short"},
+		{"too repetitive", []string{"aaaaaaaaaaaaaaaaaaaa"}, "too repetitive"},
+		{"mismatch", []string{"correct horse battery staple", "correct horse battery stapler"}, "do not match"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backupPath := filepath.Join(tempDir, "backup.age")
+			withPassphrases(t, tt.passphrases...)
+
+			err := RunVaultKeyBackup(backupPath, cfg)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error containing %q, got: %v", tt.wantErr, err)
+			}
+			if _, err := os.Stat(backupPath); !os.IsNotExist(err) {
+				t.Error("backup should not be written")
+			}
+		})
+	}
+}
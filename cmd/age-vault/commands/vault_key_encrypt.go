@@ -1,13 +1,15 @@
 package commands
 
 import (
-	"bytes"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"filippo.io/age"
 	"github.com/leolimasa/age-vault/config"
 	"github.com/leolimasa/age-vault/keymgmt"
+	"github.com/leolimasa/age-vault/members"
 	"github.com/leolimasa/age-vault/vault"
 )
 
@@ -16,7 +18,14 @@ import (
 // then encrypts it for a new recipient (user's public key).
 // Supports two ways to specify the recipient: --pubkey or --pubkey-file.
 // Outputs to stdout by default, unless --save or -o is specified.
-func RunVaultKeyEncrypt(pubkey string, pubkeyFile string, outputPath string, save bool, cfg *config.Config) error {
+// With --save, the configured vault key is only replaced if we can still decrypt the result,
+// unless force is set (see setVaultKey).
+// With recoveryPassphrase, the vault key is also encrypted to a passphrase the user is
+// prompted for, so it can still be decrypted if the recipient's identity is lost.
+// Each of andPubkeys adds an inner layer of encryption, so the result can only be decrypted
+// by a compound identity holding the recipient's identity and every andPubkeys identity.
+// keyType selects the type of the vault key if one is created ("x25519" or "hybrid").
+func RunVaultKeyEncrypt(pubkey string, pubkeyFile string, andPubkeys []string, outputPath string, save bool, force bool, recoveryPassphrase bool, keyType string, cfg *config.Config) error {
 	// Validate that exactly one recipient source is provided
 	providedCount := 0
 	if pubkey != "" {
@@ -28,72 +37,23 @@ func RunVaultKeyEncrypt(pubkey string, pubkeyFile string, outputPath string, sav
 	if providedCount != 1 {
 		return fmt.Errorf("exactly one of --pubkey or --pubkey-file must be provided")
 	}
-	var vaultKeyIdentity age.Identity
-
-	// Check if vault key exists
-	if _, err := os.Stat(cfg.VaultKeyFile); os.IsNotExist(err) {
-		// Vault key doesn't exist, generate a new one
-		identity, err := vault.GenerateVaultKey()
-		if err != nil {
-			return fmt.Errorf("failed to generate vault key: %w", err)
-		}
-
-		// Save the vault key encrypted for ourselves first
-		// Load our identity
-		userIdentity, err := keymgmt.LoadIdentity(cfg.IdentityFile)
-		if err != nil {
-			return fmt.Errorf("failed to load identity: %w", err)
-		}
-
-		// Get our recipient (public key) from our identity
-		userRecipient, err := keymgmt.ExtractRecipient(userIdentity)
-		if err != nil {
-			return fmt.Errorf("failed to extract recipient from identity: %w", err)
-		}
-
-		// Encrypt vault key for ourselves
-		encryptedForUs, err := vault.EncryptVaultKey(identity, userRecipient)
-		if err != nil {
-			return fmt.Errorf("failed to encrypt vault key for self: %w", err)
-		}
-
-		// Ensure parent directory exists
-		if err := config.EnsureParentDir(cfg.VaultKeyFile); err != nil {
-			return err
-		}
-
-		// Save the encrypted vault key
-		if err := os.WriteFile(cfg.VaultKeyFile, encryptedForUs, 0600); err != nil {
-			return fmt.Errorf("failed to save vault key: %w", err)
-		}
-
-		vaultKeyIdentity = identity
-	} else {
-		// Vault key exists, load and decrypt it using helper function
-		vaultKey, err := keymgmt.VaultKeyFromIdentityFile(cfg.IdentityFile, cfg.VaultKeyFile)
-		if err != nil {
-			return fmt.Errorf("failed to load vault key: %w", err)
-		}
-
-		// Get the identity from the vault key
-		vaultKeyIdentity, err = vaultKey.GetIdentity()
-		if err != nil {
-			return fmt.Errorf("failed to get vault key identity: %w", err)
-		}
+	// Load the vault key, creating it if it doesn't exist yet
+	vaultKey, err := loadOrCreateVaultKey(keyType, cfg)
+	if err != nil {
+		return err
 	}
 
-	// Get the recipient based on which flag was provided
+	// Get the recipient based on which flag was provided, labeling the file with the public
+	// key or the name of its file
 	var recipient age.Recipient
+	label := strings.TrimSpace(pubkey)
 
 	if pubkey != "" {
 		// Parse recipient from string
-		recipients, parseErr := age.ParseRecipients(bytes.NewReader([]byte(pubkey)))
+		recipients, parseErr := keymgmt.ParseRecipients([]byte(pubkey))
 		if parseErr != nil {
 			return fmt.Errorf("failed to parse public key: %w", parseErr)
 		}
-		if len(recipients) == 0 {
-			return fmt.Errorf("no recipient found in provided public key")
-		}
 		recipient = recipients[0]
 	} else if pubkeyFile != "" {
 		// Read and parse recipient from file
@@ -101,18 +61,43 @@ func RunVaultKeyEncrypt(pubkey string, pubkeyFile string, outputPath string, sav
 		if readErr != nil {
 			return fmt.Errorf("failed to read public key file: %w", readErr)
 		}
-		recipients, parseErr := age.ParseRecipients(bytes.NewReader(content))
+		recipients, parseErr := keymgmt.ParseRecipients(content)
 		if parseErr != nil {
 			return fmt.Errorf("failed to parse public key file: %w", parseErr)
 		}
-		if len(recipients) == 0 {
-			return fmt.Errorf("no recipient found in public key file")
-		}
 		recipient = recipients[0]
+		label = strings.TrimSuffix(filepath.Base(pubkeyFile), filepath.Ext(pubkeyFile))
+	}
+
+	// Each additional public key is an inner layer, unwrapped after the recipient's
+	layers := [][]age.Recipient{{recipient}}
+	for _, andPubkey := range andPubkeys {
+		recipients, err := keymgmt.ParseRecipients([]byte(andPubkey))
+		if err != nil {
+			return fmt.Errorf("failed to parse public key %s: %w", andPubkey, err)
+		}
+		layers = append(layers, recipients[:1])
 	}
 
-	// Encrypt vault key for the recipient
-	encryptedKey, err := vault.EncryptVaultKey(vaultKeyIdentity, recipient)
+	// Add a recovery passphrase stanza alongside the recipient, on every layer
+	if recoveryPassphrase {
+		fmt.Fprintf(os.Stderr, "Choose a recovery passphrase for the vault key\n")
+		passphrase, err := readNewPassphrase()
+		if err != nil {
+			return err
+		}
+		passphraseRecipient, err := vault.NewPassphraseRecipient(passphrase)
+		if err != nil {
+			return fmt.Errorf("failed to create recovery passphrase recipient: %w", err)
+		}
+		for i := range layers {
+			layers[i] = append(layers[i], passphraseRecipient)
+		}
+	}
+
+	// Encrypt the whole keyring for the recipient, so they can also decrypt data
+	// encrypted with retired vault keys
+	encryptedKey, err := keymgmt.EncryptVaultKeyringLayers(vaultKey, label, cfg, layers...)
 	if err != nil {
 		return fmt.Errorf("failed to encrypt vault key for recipient: %w", err)
 	}
@@ -128,13 +113,10 @@ func RunVaultKeyEncrypt(pubkey string, pubkeyFile string, outputPath string, sav
 		}
 		fmt.Fprintf(os.Stderr, "Vault key encrypted and saved to %s\n", outputPath)
 	} else if save {
-		// Save to config vault key file
-		if err := config.EnsureParentDir(cfg.VaultKeyFile); err != nil {
+		// Save to config vault key file, making sure we don't lock ourselves out
+		if err := setVaultKey(encryptedKey, force, cfg); err != nil {
 			return err
 		}
-		if err := os.WriteFile(cfg.VaultKeyFile, encryptedKey, 0600); err != nil {
-			return fmt.Errorf("failed to write encrypted vault key: %w", err)
-		}
 		fmt.Fprintf(os.Stderr, "Vault key encrypted and saved to %s\n", cfg.VaultKeyFile)
 	} else {
 		// Output to stdout
@@ -145,3 +127,103 @@ func RunVaultKeyEncrypt(pubkey string, pubkeyFile string, outputPath string, sav
 
 	return nil
 }
+
+// RunVaultKeyEncryptAll handles the vault-key encrypt --all command.
+// It creates a new vault key if none exists, or loads the existing one, then encrypts it
+// for every member of the recipients registry, writing one <member>.age file per member
+// into outputDir. keyType selects the type of the vault key if one is created.
+func RunVaultKeyEncryptAll(outputDir string, keyType string, cfg *config.Config) error {
+	if outputDir == "" {
+		return fmt.Errorf("an output directory (-o) is required with --all")
+	}
+
+	registry, err := members.Load(cfg.RecipientsFile)
+	if err != nil {
+		return fmt.Errorf("failed to load recipients registry: %w", err)
+	}
+	if len(registry.Members) == 0 {
+		return fmt.Errorf("no members registered in %s (use 'age-vault members add')", cfg.RecipientsFile)
+	}
+
+	// Load the vault key, creating it if it doesn't exist yet
+	vaultKey, err := loadOrCreateVaultKey(keyType, cfg)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range registry.Members {
+		recipients, err := keymgmt.ParseRecipients([]byte(m.Recipient))
+		if err != nil {
+			return fmt.Errorf("failed to parse public key of member %s: %w", m.Name, err)
+		}
+
+		encryptedKey, err := encryptVaultKeyring(vaultKey, m.Name, cfg, recipients[0])
+		if err != nil {
+			return fmt.Errorf("failed to encrypt vault key for member %s: %w", m.Name, err)
+		}
+
+		outputPath := filepath.Join(outputDir, m.Name+".age")
+		if err := keymgmt.WriteFileAtomic(outputPath, encryptedKey); err != nil {
+			return fmt.Errorf("failed to write encrypted vault key for member %s: %w", m.Name, err)
+		}
+		fmt.Fprintf(os.Stderr, "Vault key encrypted for %s and saved to %s\n", m.Name, outputPath)
+	}
+
+	return nil
+}
+
+// loadOrCreateVaultKey loads the configured vault key. If no vault key exists yet, a new one
+// of keyType (default x25519) is generated and saved encrypted for our own identity first.
+// An existing vault key must be of keyType, if given.
+func loadOrCreateVaultKey(keyType string, cfg *config.Config) (*vault.VaultKey, error) {
+	requestedType := vault.KeyTypeX25519
+	if keyType != "" {
+		parsed, err := vault.ParseKeyType(keyType)
+		if err != nil {
+			return nil, err
+		}
+		requestedType = parsed
+	}
+
+	// Check if vault key exists
+	if _, err := os.Stat(cfg.VaultKeyFile); os.IsNotExist(err) {
+		// Vault key doesn't exist, generate a new one
+		identity, err := vault.GenerateVaultKeyOfType(requestedType)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate vault key: %w", err)
+		}
+
+		// Save the vault key encrypted for ourselves first
+		vaultKey := vault.NewVaultKey(identity)
+		if err := keymgmt.SaveVaultKeyForIdentity(vaultKey, selfLabel(), cfg.VaultKeyFile, cfg); err != nil {
+			return nil, fmt.Errorf("failed to save vault key: %w", err)
+		}
+
+		return vaultKey, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to check vault key file: %w", err)
+	}
+
+	// Vault key exists, load and decrypt it using helper function
+	vaultKey, err := keymgmt.VaultKeyFromIdentityFile(cfg.IdentityFile, cfg.VaultKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load vault key: %w", err)
+	}
+
+	// The type of an existing vault key can only be changed by rotating it
+	if keyType != "" {
+		identity, err := vaultKey.GetIdentity()
+		if err != nil {
+			return nil, err
+		}
+		currentType, err := vault.KeyTypeOf(identity)
+		if err != nil {
+			return nil, err
+		}
+		if currentType != requestedType {
+			return nil, fmt.Errorf("the vault key is a %s key, not %s (use 'age-vault vault-key rotate --key-type %s' to change it)", currentType, requestedType, requestedType)
+		}
+	}
+
+	return vaultKey, nil
+}
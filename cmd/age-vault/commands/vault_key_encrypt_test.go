@@ -0,0 +1,217 @@
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+
+	"github.com/leolimasa/age-vault/config"
+	"github.com/leolimasa/age-vault/members"
+	"github.com/leolimasa/age-vault/vault"
+)
+
+func TestRunVaultKeyEncryptAll(t *testing.T) {
+	tempDir := t.TempDir()
+
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("failed to generate test identity: %v", err)
+	}
+	identityPath := filepath.Join(tempDir, "identity.txt")
+	if err := os.WriteFile(identityPath, []byte(identity.String()+"\n"), 0600); err != nil {
+		t.Fatalf("failed to write identity file: %v", err)
+	}
+
+	vaultKeyPath := filepath.Join(tempDir, "vault_key.age")
+	vaultKey := writeTestVaultKey(t, identity, vaultKeyPath)
+	vaultKeyIdentity, _ := vaultKey.GetIdentity()
+
+	// Register two members
+	registry := &members.Registry{}
+	memberIdentities := map[string]*age.X25519Identity{}
+	for _, name := range []string{"alice@laptop", "bob@desktop"} {
+		member, err := age.GenerateX25519Identity()
+		if err != nil {
+			t.Fatalf("failed to generate member identity: %v", err)
+		}
+		memberIdentities[name] = member
+		if err := registry.Add(name, member.Recipient().String()); err != nil {
+			t.Fatalf("failed to add member: %v", err)
+		}
+	}
+	recipientsPath := filepath.Join(tempDir, config.RecipientsFileName)
+	if err := registry.Save(recipientsPath); err != nil {
+		t.Fatalf("failed to save registry: %v", err)
+	}
+
+	cfg := &config.Config{
+		IdentityFile:   identityPath,
+		VaultKeyFile:   vaultKeyPath,
+		RecipientsFile: recipientsPath,
+	}
+
+	outputDir := filepath.Join(tempDir, "out")
+	if err := RunVaultKeyEncryptAll(outputDir, "", cfg); err != nil {
+		t.Fatalf("RunVaultKeyEncryptAll failed: %v", err)
+	}
+
+	for name, member := range memberIdentities {
+		data, err := os.ReadFile(filepath.Join(outputDir, name+".age"))
+		if err != nil {
+			t.Fatalf("failed to read encrypted vault key for %s: %v", name, err)
+		}
+		memberKey, err := vault.DecryptVaultKey(data, member)
+		if err != nil {
+			t.Fatalf("member %s cannot decrypt vault key: %v", name, err)
+		}
+		memberKeyIdentity, _ := memberKey.GetIdentity()
+		if memberKeyIdentity.(*age.X25519Identity).String() != vaultKeyIdentity.(*age.X25519Identity).String() {
+			t.Errorf("member %s received a different vault key", name)
+		}
+	}
+}
+
+func TestRunVaultKeyEncryptAll_NoMembers(t *testing.T) {
+	tempDir := t.TempDir()
+
+	cfg := &config.Config{
+		IdentityFile:   filepath.Join(tempDir, "identity.txt"),
+		VaultKeyFile:   filepath.Join(tempDir, "vault_key.age"),
+		RecipientsFile: filepath.Join(tempDir, config.RecipientsFileName),
+	}
+
+	if err := RunVaultKeyEncryptAll(filepath.Join(tempDir, "out"), "", cfg); err == nil {
+		t.Fatal("expected error with empty registry, got nil")
+	}
+}
+
+func TestRunVaultKeyEncrypt_RecoveryPassphrase(t *testing.T) {
+	tempDir := t.TempDir()
+	const passphrase = "correct horse battery staple"
+
+	identityPath := filepath.Join(tempDir, "identity.txt")
+	identity := writeTestIdentity(t, identityPath)
+	vaultKeyPath := filepath.Join(tempDir, "vault_key.age")
+	vaultKey := writeTestVaultKey(t, identity, vaultKeyPath)
+	vaultKeyIdentity, _ := vaultKey.GetIdentity()
+
+	cfg := &config.Config{
+		IdentityFile: identityPath,
+		VaultKeyFile: vaultKeyPath,
+	}
+
+	withPassphrases(t, passphrase, passphrase)
+	if err := RunVaultKeyEncrypt(identity.Recipient().String(), "", nil, "", true, false, true, "", cfg); err != nil {
+		t.Fatalf("RunVaultKeyEncrypt failed: %v", err)
+	}
+
+	data, err := os.ReadFile(vaultKeyPath)
+	if err != nil {
+		t.Fatalf("failed to read vault key: %v", err)
+	}
+	if !vault.HasPassphraseStanza(data) {
+		t.Fatal("vault key has no recovery passphrase stanza")
+	}
+
+	// The identity still decrypts the vault key
+	if _, err := vault.DecryptVaultKey(data, identity); err != nil {
+		t.Fatalf("identity cannot decrypt vault key: %v", err)
+	}
+
+	// And so does the recovery passphrase
+	passphraseIdentity, err := vault.NewPassphraseIdentity(passphrase)
+	if err != nil {
+		t.Fatalf("failed to create passphrase identity: %v", err)
+	}
+	recovered, err := vault.DecryptVaultKey(data, passphraseIdentity)
+	if err != nil {
+		t.Fatalf("recovery passphrase cannot decrypt vault key: %v", err)
+	}
+	if !recovered.Contains(vaultKeyIdentity) {
+		t.Error("recovered vault key differs from the original")
+	}
+}
+
+func TestRunVaultKeyEncrypt_AndPubkey(t *testing.T) {
+	tempDir := t.TempDir()
+
+	identityPath := filepath.Join(tempDir, "identity.txt")
+	identity := writeTestIdentity(t, identityPath)
+	vaultKeyPath := filepath.Join(tempDir, "vault_key.age")
+	vaultKey := writeTestVaultKey(t, identity, vaultKeyPath)
+	vaultKeyIdentity, _ := vaultKey.GetIdentity()
+
+	second, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("failed to generate second identity: %v", err)
+	}
+
+	cfg := &config.Config{
+		IdentityFile: identityPath,
+		VaultKeyFile: vaultKeyPath,
+	}
+
+	outputPath := filepath.Join(tempDir, "compound.age")
+	if err := RunVaultKeyEncrypt(identity.Recipient().String(), "", []string{second.Recipient().String()}, outputPath, false, false, false, "", cfg); err != nil {
+		t.Fatalf("RunVaultKeyEncrypt failed: %v", err)
+	}
+	data, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("failed to read encrypted vault key: %v", err)
+	}
+
+	if _, err := vault.DecryptVaultKey(data, identity); err == nil {
+		t.Fatal("vault key decrypted with only the first identity")
+	}
+	decrypted, err := vault.DecryptVaultKey(data, identity, second)
+	if err != nil {
+		t.Fatalf("compound identity cannot decrypt vault key: %v", err)
+	}
+	if !decrypted.Contains(vaultKeyIdentity) {
+		t.Error("decrypted vault key differs from the original")
+	}
+
+	// Installing it requires the identity file to list both identities
+	if err := RunVaultKeySet(outputPath, false, cfg); err == nil {
+		t.Fatal("expected vault-key set to refuse a vault key the identity cannot open")
+	}
+	content := identity.String() + "\n" + second.String() + "\n"
+	if err := os.WriteFile(identityPath, []byte(content), 0600); err != nil {
+		t.Fatalf("failed to write compound identity: %v", err)
+	}
+	if err := RunVaultKeySet(outputPath, false, cfg); err != nil {
+		t.Fatalf("RunVaultKeySet failed with compound identity: %v", err)
+	}
+}
+
+func TestRunVaultKeyEncrypt_HybridKeyType(t *testing.T) {
+	tempDir := t.TempDir()
+
+	identityPath := filepath.Join(tempDir, "identity.txt")
+	identity := writeTestIdentity(t, identityPath)
+	cfg := &config.Config{
+		IdentityFile: identityPath,
+		VaultKeyFile: filepath.Join(tempDir, "vault_key.age"),
+	}
+
+	// A new vault is created with a post-quantum vault key
+	if err := RunVaultKeyEncrypt(identity.Recipient().String(), "", nil, filepath.Join(tempDir, "me.age"), false, false, false, "hybrid", cfg); err != nil {
+		t.Fatalf("RunVaultKeyEncrypt failed: %v", err)
+	}
+
+	pubkey, err := vaultKeyRecipientString(cfg)
+	if err != nil {
+		t.Fatalf("failed to get vault public key: %v", err)
+	}
+	if !strings.HasPrefix(pubkey, "age1pq1") {
+		t.Errorf("expected a hybrid vault public key, got %s", pubkey)
+	}
+
+	// The type of an existing vault key is not changed silently
+	if err := RunVaultKeyEncrypt(identity.Recipient().String(), "", nil, filepath.Join(tempDir, "me2.age"), false, false, false, "x25519", cfg); err == nil {
+		t.Error("expected error when asking for another key type than the existing vault key")
+	}
+}
@@ -0,0 +1,52 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/leolimasa/age-vault/config"
+	"github.com/leolimasa/age-vault/fingerprint"
+)
+
+// RunVaultKeyFingerprint handles the vault-key fingerprint command.
+// It prints a short fingerprint of the vault public key, in hex and word form by default,
+// or only in the form selected by format ("hex" or "words").
+func RunVaultKeyFingerprint(format string, cfg *config.Config) error {
+	recipient, err := vaultKeyRecipientString(cfg)
+	if err != nil {
+		return err
+	}
+	f := fingerprint.Of(recipient)
+
+	switch format {
+	case "":
+		fmt.Printf("Hex:   %s\n", f.Hex())
+		fmt.Printf("Words: %s\n", f.Words())
+	case "hex":
+		fmt.Println(f.Hex())
+	case "words":
+		fmt.Println(f.Words())
+	default:
+		return fmt.Errorf("unknown fingerprint format %q (expected hex or words)", format)
+	}
+
+	return nil
+}
+
+// RunVaultKeyVerify handles the vault-key verify command.
+// It decrypts the local vault key and checks that its fingerprint matches the expected one,
+// given in either hex or word form. A mismatch is returned as an error.
+func RunVaultKeyVerify(expected string, cfg *config.Config) error {
+	recipient, err := vaultKeyRecipientString(cfg)
+	if err != nil {
+		return err
+	}
+	f := fingerprint.Of(recipient)
+
+	if !f.Matches(expected) {
+		return fmt.Errorf("vault key fingerprint mismatch: local vault key is %s (%s)", f.Hex(), f.Words())
+	}
+
+	fmt.Fprintf(os.Stderr, "Vault key fingerprint matches: %s\n", f.Hex())
+	return nil
+}
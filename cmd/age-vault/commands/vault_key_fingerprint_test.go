@@ -0,0 +1,58 @@
+package commands
+
+import (
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+
+	"github.com/leolimasa/age-vault/config"
+	"github.com/leolimasa/age-vault/fingerprint"
+)
+
+func TestRunVaultKeyVerify(t *testing.T) {
+	tempDir := t.TempDir()
+
+	identityPath := filepath.Join(tempDir, "identity.txt")
+	identity := writeTestIdentity(t, identityPath)
+	vaultKeyPath := filepath.Join(tempDir, "vault_key.age")
+	vaultKey := writeTestVaultKey(t, identity, vaultKeyPath)
+
+	vaultKeyIdentity, _ := vaultKey.GetIdentity()
+	f := fingerprint.Of(vaultKeyIdentity.(*age.X25519Identity).Recipient().String())
+
+	cfg := &config.Config{
+		IdentityFile: identityPath,
+		VaultKeyFile: vaultKeyPath,
+	}
+
+	if err := RunVaultKeyVerify(f.Hex(), cfg); err != nil {
+		t.Errorf("expected hex fingerprint to match: %v", err)
+	}
+	if err := RunVaultKeyVerify(f.Words(), cfg); err != nil {
+		t.Errorf("expected word fingerprint to match: %v", err)
+	}
+
+	other := fingerprint.Of("age1someotherkey")
+	if err := RunVaultKeyVerify(other.Hex(), cfg); err == nil {
+		t.Error("expected mismatching fingerprint to fail")
+	}
+}
+
+func TestRunVaultKeyFingerprint_UnknownFormat(t *testing.T) {
+	tempDir := t.TempDir()
+
+	identityPath := filepath.Join(tempDir, "identity.txt")
+	identity := writeTestIdentity(t, identityPath)
+	vaultKeyPath := filepath.Join(tempDir, "vault_key.age")
+	writeTestVaultKey(t, identity, vaultKeyPath)
+
+	cfg := &config.Config{
+		IdentityFile: identityPath,
+		VaultKeyFile: vaultKeyPath,
+	}
+
+	if err := RunVaultKeyFingerprint("emoji", cfg); err == nil {
+		t.Error("expected unknown format to fail")
+	}
+}
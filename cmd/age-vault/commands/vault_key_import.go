@@ -0,0 +1,69 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"filippo.io/age"
+	"github.com/leolimasa/age-vault/config"
+	"github.com/leolimasa/age-vault/keymgmt"
+	"github.com/leolimasa/age-vault/vault"
+)
+
+// RunVaultKeyImport handles the vault-key import command.
+// It adopts the native identities (X25519 or post-quantum hybrid) of an existing age identity
+// file as the vault key, so secrets already encrypted to them (e.g. with plain age or sops)
+// can be decrypted through the vault. The first identity becomes the current vault key and
+// the others retired keys.
+// The vault key is encrypted for the configured identity and installed like vault-key set,
+// through saveVaultKeyForSelf like every restored vault key, so it gets an envelope and the
+// escrow recipients.
+// With deleteSource, the plaintext identity file is shredded afterwards; otherwise a warning
+// reminds the user to delete it.
+func RunVaultKeyImport(sourcePath string, deleteSource bool, force bool, cfg *config.Config) error {
+	content, err := os.ReadFile(sourcePath)
+	if err != nil {
+		return fmt.Errorf("failed to read identity file: %w", err)
+	}
+
+	// Only native identities can be vault keys, the private key of plugins never leaves the device
+	identities, err := age.ParseIdentities(bytes.NewReader(content))
+	if err != nil {
+		return fmt.Errorf("failed to parse identity file %s (only native age identities can be imported): %w", sourcePath, err)
+	}
+
+	for _, identity := range identities {
+		if _, err := vault.KeyTypeOf(identity); err != nil {
+			return fmt.Errorf("failed to import %s: %w", sourcePath, err)
+		}
+	}
+	current, retired := identities[0], identities[1:]
+	vaultKey := vault.NewVaultKey(current, retired...)
+
+	if err := saveVaultKeyForSelf(vaultKey, force, cfg); err != nil {
+		return err
+	}
+
+	_, recipient, err := vaultKey.Recipient()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Vault public key: %s\n", recipient)
+	if len(retired) > 0 {
+		fmt.Fprintf(os.Stderr, "Imported %d additional identities as retired vault keys\n", len(retired))
+	}
+
+	// Don't leave the plaintext vault key around
+	if deleteSource {
+		if err := keymgmt.ShredFile(sourcePath); err != nil {
+			return fmt.Errorf("vault key imported, but failed to delete %s: %w", sourcePath, err)
+		}
+		fmt.Fprintf(os.Stderr, "Overwrote and deleted %s\n", sourcePath)
+	} else {
+		fmt.Fprintf(os.Stderr, "Warning: %s still holds the vault key in plaintext. Delete it (or rerun with --delete-source) once you have checked the import.\n", sourcePath)
+	}
+
+	fmt.Printf("Vault key imported to: %s\n", cfg.VaultKeyFile)
+	return nil
+}
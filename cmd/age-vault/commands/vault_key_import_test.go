@@ -0,0 +1,99 @@
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+
+	"github.com/leolimasa/age-vault/config"
+	"github.com/leolimasa/age-vault/keymgmt"
+)
+
+func TestRunVaultKeyImport(t *testing.T) {
+	tempDir := t.TempDir()
+
+	identityPath := filepath.Join(tempDir, "identity.txt")
+	writeTestIdentity(t, identityPath)
+	cfg := &config.Config{
+		IdentityFile: identityPath,
+		VaultKeyFile: filepath.Join(tempDir, "vault_key.age"),
+	}
+
+	// An existing age key with an older one, as used with sops
+	current, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("failed to generate identity: %v", err)
+	}
+	old, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("failed to generate identity: %v", err)
+	}
+	sourcePath := filepath.Join(tempDir, "keys.txt")
+	content := "# created: 2024-01-01\n" + current.String() + "\n" + old.String() + "\n"
+	if err := os.WriteFile(sourcePath, []byte(content), 0600); err != nil {
+		t.Fatalf("failed to write age key: %v", err)
+	}
+
+	if err := RunVaultKeyImport(sourcePath, false, false, cfg); err != nil {
+		t.Fatalf("RunVaultKeyImport failed: %v", err)
+	}
+
+	vaultKey, err := keymgmt.VaultKeyFromIdentityFile(cfg.IdentityFile, cfg.VaultKeyFile)
+	if err != nil {
+		t.Fatalf("failed to load imported vault key: %v", err)
+	}
+	vaultKeyIdentity, _ := vaultKey.GetIdentity()
+	if vaultKeyIdentity.(*age.X25519Identity).String() != current.String() {
+		t.Error("the first identity is not the current vault key")
+	}
+	if !vaultKey.Contains(old) {
+		t.Error("the second identity was not kept as a retired vault key")
+	}
+
+	// Without --delete-source the plaintext file is left alone
+	if _, err := os.Stat(sourcePath); err != nil {
+		t.Errorf("source file was removed: %v", err)
+	}
+
+	// Importing another key would move us to another vault
+	other, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("failed to generate identity: %v", err)
+	}
+	otherPath := filepath.Join(tempDir, "other.txt")
+	if err := os.WriteFile(otherPath, []byte(other.String()+"\n"), 0600); err != nil {
+		t.Fatalf("failed to write age key: %v", err)
+	}
+	if err := RunVaultKeyImport(otherPath, true, false, cfg); err == nil {
+		t.Fatal("expected import of another vault's key to be refused")
+	}
+	if _, err := os.Stat(otherPath); err != nil {
+		t.Errorf("source file was removed although the import failed: %v", err)
+	}
+
+	if err := RunVaultKeyImport(otherPath, true, true, cfg); err != nil {
+		t.Fatalf("RunVaultKeyImport with force failed: %v", err)
+	}
+	if _, err := os.Stat(otherPath); !os.IsNotExist(err) {
+		t.Errorf("expected source file to be deleted, got: %v", err)
+	}
+}
+
+func TestRunVaultKeyImport_InvalidSource(t *testing.T) {
+	tempDir := t.TempDir()
+
+	sourcePath := filepath.Join(tempDir, "keys.txt")
+	if err := os.WriteFile(sourcePath, []byte("not an identity\n"), 0600); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	cfg := &config.Config{
+		IdentityFile: filepath.Join(tempDir, "identity.txt"),
+		VaultKeyFile: filepath.Join(tempDir, "vault_key.age"),
+	}
+
+	if err := RunVaultKeyImport(sourcePath, false, false, cfg); err == nil {
+		t.Fatal("expected error for an invalid identity file, got nil")
+	}
+}
@@ -0,0 +1,166 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"filippo.io/age"
+	"github.com/leolimasa/age-vault/config"
+	"github.com/leolimasa/age-vault/keymgmt"
+	"github.com/leolimasa/age-vault/paper"
+	"github.com/leolimasa/age-vault/vault"
+)
+
+// paperBackupTextFile is the name of the printable text document written by paper-backup.
+const paperBackupTextFile = "vault-key-backup.txt"
+
+// RunVaultKeyPaperBackup handles the vault-key paper-backup command.
+// It encrypts the vault key with a passphrase and writes it to outputDir as a printable text
+// document of numbered, checksummed chunks, plus one QR code per chunk in qrFormat
+// ("png", "svg" or "none").
+func RunVaultKeyPaperBackup(outputDir string, qrFormat string, cfg *config.Config) error {
+	if qrFormat != "png" && qrFormat != "svg" && qrFormat != "none" {
+		return fmt.Errorf("unknown QR code format %q (expected png, svg or none)", qrFormat)
+	}
+
+	vaultKey, err := keymgmt.VaultKeyFromIdentityFile(cfg.IdentityFile, cfg.VaultKeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load vault key: %w", err)
+	}
+
+	// The keyring grows with every rotation, check that it fits before asking for a passphrase
+	if err := checkPaperBackupSize(vaultKey); err != nil {
+		return err
+	}
+
+	encryptedKey, err := encryptVaultKeyWithPassphrase(vaultKey)
+	if err != nil {
+		return err
+	}
+
+	chunks, err := paper.Split(encryptedKey, paper.ChunkSize)
+	if err != nil {
+		return fmt.Errorf("failed to split vault key: %w", err)
+	}
+
+	// Printable text document
+	var doc bytes.Buffer
+	instructions := []string{
+		"This is a passphrase-encrypted backup of an age-vault vault key.",
+		"To restore it, type or scan the lines below (in any order) into a file and run:",
+		"",
+		"    age-vault vault-key paper-restore [file]",
+		"",
+		"Each line is checksummed, so typos are detected. All lines are required.",
+	}
+	if err := paper.WriteText(&doc, "age-vault vault key backup", instructions, chunks); err != nil {
+		return fmt.Errorf("failed to write paper backup: %w", err)
+	}
+	textPath := filepath.Join(outputDir, paperBackupTextFile)
+	if err := keymgmt.WriteFileAtomic(textPath, doc.Bytes()); err != nil {
+		return fmt.Errorf("failed to write paper backup: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "Paper backup saved to %s (%d chunks)\n", textPath, len(chunks))
+
+	// One QR code per chunk
+	if qrFormat == "none" {
+		return nil
+	}
+	for _, c := range chunks {
+		var image []byte
+		if qrFormat == "png" {
+			image, err = c.PNG()
+		} else {
+			image, err = c.SVG()
+		}
+		if err != nil {
+			return err
+		}
+
+		imagePath := filepath.Join(outputDir, fmt.Sprintf("vault-key-chunk-%02d.%s", c.Index, qrFormat))
+		if err := keymgmt.WriteFileAtomic(imagePath, image); err != nil {
+			return fmt.Errorf("failed to write QR code: %w", err)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "QR codes saved to %s\n", outputDir)
+
+	return nil
+}
+
+// checkPaperBackupSize returns an error if the passphrase-encrypted vault key is too large
+// for a paper backup. The size is measured by encrypting it with a throwaway passphrase,
+// whose stanza has the same size as the real one.
+func checkPaperBackupSize(vaultKey *vault.VaultKey) error {
+	recipient, err := age.NewScryptRecipient("size check")
+	if err != nil {
+		return fmt.Errorf("failed to create passphrase recipient: %w", err)
+	}
+	recipient.SetWorkFactor(10)
+	encryptedKey, err := vault.EncryptVaultKeyring(vaultKey, recipient)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt vault key: %w", err)
+	}
+
+	if maxSize := paper.MaxSize(paper.ChunkSize); len(encryptedKey) > maxSize {
+		return fmt.Errorf("the vault key and its %d retired key(s) are too large for a paper backup (%d bytes, at most %d): re-encrypt your files with 'age-vault rekey', then drop the retired keys with 'age-vault vault-key rotate --drop-retired'",
+			len(vaultKey.Identities())-1, len(encryptedKey), maxSize)
+	}
+	return nil
+}
+
+// RunVaultKeyPaperRestore handles the vault-key paper-restore command.
+// It reads the chunks of a paper backup from the given files (or stdin if none), in any order,
+// reassembles and decrypts the vault key, and installs it for the configured identity like
+// vault-key set. Lines that are not chunks are ignored, so the whole text document can be used.
+func RunVaultKeyPaperRestore(paths []string, force bool, cfg *config.Config) error {
+	var chunks []paper.Chunk
+	if len(paths) == 0 {
+		fmt.Fprintf(os.Stderr, "Enter the backup lines, then press Ctrl-D:\n")
+		read, err := paper.ReadChunks(os.Stdin)
+		if err != nil {
+			return err
+		}
+		chunks = read
+	}
+	for _, path := range paths {
+		read, err := readChunksFile(path)
+		if err != nil {
+			return err
+		}
+		chunks = append(chunks, read...)
+	}
+
+	encryptedKey, err := paper.Combine(chunks)
+	if err != nil {
+		return fmt.Errorf("failed to reassemble vault key: %w", err)
+	}
+
+	vaultKey, err := decryptVaultKeyWithPassphrase(encryptedKey)
+	if err != nil {
+		return err
+	}
+
+	if err := saveVaultKeyForSelf(vaultKey, force, cfg); err != nil {
+		return err
+	}
+
+	fmt.Printf("Vault key restored to: %s\n", cfg.VaultKeyFile)
+	return nil
+}
+
+// readChunksFile reads the paper backup chunks found in a file.
+func readChunksFile(path string) ([]paper.Chunk, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	chunks, err := paper.ReadChunks(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return chunks, nil
+}
@@ -0,0 +1,138 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+
+	"github.com/leolimasa/age-vault/config"
+	"github.com/leolimasa/age-vault/keymgmt"
+	"github.com/leolimasa/age-vault/vault"
+)
+
+func TestRunVaultKeyPaperBackupRestore(t *testing.T) {
+	tempDir := t.TempDir()
+	const passphrase = "correct horse battery staple"
+
+	identityPath := filepath.Join(tempDir, "identity.txt")
+	identity := writeTestIdentity(t, identityPath)
+	vaultKeyPath := filepath.Join(tempDir, "vault_key.age")
+	vaultKey := writeTestVaultKey(t, identity, vaultKeyPath)
+	vaultKeyIdentity, _ := vaultKey.GetIdentity()
+
+	cfg := &config.Config{
+		IdentityFile: identityPath,
+		VaultKeyFile: vaultKeyPath,
+	}
+
+	outputDir := filepath.Join(tempDir, "paper")
+	withPassphrases(t, passphrase, passphrase)
+	if err := RunVaultKeyPaperBackup(outputDir, "svg", cfg); err != nil {
+		t.Fatalf("RunVaultKeyPaperBackup failed: %v", err)
+	}
+
+	doc, err := os.ReadFile(filepath.Join(outputDir, paperBackupTextFile))
+	if err != nil {
+		t.Fatalf("failed to read paper backup: %v", err)
+	}
+	qrCodes, _ := filepath.Glob(filepath.Join(outputDir, "vault-key-chunk-*.svg"))
+	if len(qrCodes) == 0 {
+		t.Error("expected QR codes to be written")
+	}
+
+	// Type the chunks back in reverse order, split across two files
+	var chunkLines []string
+	for _, line := range strings.Split(string(doc), "\n") {
+		if strings.HasPrefix(line, "AV1 ") {
+			chunkLines = append([]string{line}, chunkLines...)
+		}
+	}
+	if len(chunkLines) != len(qrCodes) {
+		t.Fatalf("expected one QR code per chunk, got %d chunks and %d QR codes", len(chunkLines), len(qrCodes))
+	}
+	half := len(chunkLines) / 2
+	firstPath := filepath.Join(tempDir, "typed-1.txt")
+	secondPath := filepath.Join(tempDir, "typed-2.txt")
+	if err := os.WriteFile(firstPath, []byte(strings.Join(chunkLines[:half], "\n")), 0600); err != nil {
+		t.Fatalf("failed to write typed chunks: %v", err)
+	}
+	if err := os.WriteFile(secondPath, []byte(strings.ToLower(strings.Join(chunkLines[half:], "\n"))), 0600); err != nil {
+		t.Fatalf("failed to write typed chunks: %v", err)
+	}
+
+	// Restore on a new machine
+	newIdentityPath := filepath.Join(tempDir, "new", "identity.txt")
+	writeTestIdentity(t, newIdentityPath)
+	newCfg := &config.Config{
+		IdentityFile: newIdentityPath,
+		VaultKeyFile: filepath.Join(tempDir, "new", "vault_key.age"),
+	}
+
+	// A missing chunk is reported before asking for the passphrase
+	if err := RunVaultKeyPaperRestore([]string{firstPath}, false, newCfg); err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Fatalf("expected missing chunk error, got: %v", err)
+	}
+
+	withPassphrases(t, passphrase)
+	if err := RunVaultKeyPaperRestore([]string{secondPath, firstPath}, false, newCfg); err != nil {
+		t.Fatalf("RunVaultKeyPaperRestore failed: %v", err)
+	}
+
+	restored, err := keymgmt.VaultKeyFromIdentityFile(newCfg.IdentityFile, newCfg.VaultKeyFile)
+	if err != nil {
+		t.Fatalf("failed to load restored vault key: %v", err)
+	}
+	restoredIdentity, _ := restored.GetIdentity()
+	if restoredIdentity.(*age.X25519Identity).String() != vaultKeyIdentity.(*age.X25519Identity).String() {
+		t.Error("restored vault key differs from the original")
+	}
+}
+
+func TestRunVaultKeyPaperBackup_RotatedKeyring(t *testing.T) {
+	tempDir := t.TempDir()
+	const passphrase = "correct horse battery staple"
+
+	identityPath := filepath.Join(tempDir, "identity.txt")
+	identity := writeTestIdentity(t, identityPath)
+	vaultKey := writeTestVaultKey(t, identity, filepath.Join(tempDir, "first.age"))
+
+	// writeRotated rotates the vault key until it has the given number of generations
+	writeRotated := func(generations int) *config.Config {
+		t.Helper()
+		for vaultKey.Generation() < generations {
+			newIdentity, err := vault.GenerateVaultKey()
+			if err != nil {
+				t.Fatalf("failed to generate vault key: %v", err)
+			}
+			vaultKey = vaultKey.Rotate(newIdentity)
+		}
+		encryptedKey, err := vault.EncryptVaultKeyring(vaultKey, identity.Recipient())
+		if err != nil {
+			t.Fatalf("failed to encrypt vault keyring: %v", err)
+		}
+		vaultKeyPath := filepath.Join(tempDir, fmt.Sprintf("vault_key-%d.age", generations))
+		if err := os.WriteFile(vaultKeyPath, encryptedKey, 0600); err != nil {
+			t.Fatalf("failed to write vault key file: %v", err)
+		}
+		return &config.Config{IdentityFile: identityPath, VaultKeyFile: vaultKeyPath}
+	}
+
+	// A few retired keys still fit
+	cfg := writeRotated(5)
+	withPassphrases(t, passphrase, passphrase)
+	if err := RunVaultKeyPaperBackup(filepath.Join(tempDir, "paper-5"), "none", cfg); err != nil {
+		t.Fatalf("RunVaultKeyPaperBackup failed: %v", err)
+	}
+
+	// Too many are reported before asking for a passphrase
+	cfg = writeRotated(100)
+	withPassphrases(t)
+	err := RunVaultKeyPaperBackup(filepath.Join(tempDir, "paper-100"), "none", cfg)
+	if err == nil || !strings.Contains(err.Error(), "99 retired key(s)") || !strings.Contains(err.Error(), "--drop-retired") {
+		t.Fatalf("expected a size error pointing to --drop-retired, got: %v", err)
+	}
+}
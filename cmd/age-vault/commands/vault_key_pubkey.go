@@ -12,28 +12,9 @@ import (
 // RunVaultKeyPubkey handles the vault-key pubkey command.
 // It extracts and outputs the public key from the vault key.
 func RunVaultKeyPubkey(outputPath string, cfg *config.Config) error {
-	// Load and decrypt vault key
-	vaultKey, err := keymgmt.VaultKeyFromIdentityFile(cfg.IdentityFile, cfg.VaultKeyFile)
-	if err != nil {
-		return fmt.Errorf("failed to load vault key: %w", err)
-	}
-
-	// Get the identity from the vault key
-	vaultKeyIdentity, err := vaultKey.GetIdentity()
-	if err != nil {
-		return fmt.Errorf("failed to get vault key identity: %w", err)
-	}
-
-	// Extract the recipient (public key) from the vault key identity
-	recipient, err := keymgmt.ExtractRecipient(vaultKeyIdentity)
-	if err != nil {
-		return fmt.Errorf("failed to extract recipient from vault key: %w", err)
-	}
-
-	// Get the string representation of the public key
-	pubKeyStr, err := keymgmt.RecipientToString(recipient)
+	pubKeyStr, err := vaultKeyRecipientString(cfg)
 	if err != nil {
-		return fmt.Errorf("failed to convert recipient to string: %w", err)
+		return err
 	}
 	pubKeyStr = pubKeyStr + "\n"
 
@@ -60,3 +41,20 @@ func RunVaultKeyPubkey(outputPath string, cfg *config.Config) error {
 
 	return nil
 }
+
+// vaultKeyRecipientString loads the vault key and returns its public key as a string.
+func vaultKeyRecipientString(cfg *config.Config) (string, error) {
+	// Load and decrypt vault key
+	vaultKey, err := keymgmt.VaultKeyFromIdentityFile(cfg.IdentityFile, cfg.VaultKeyFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to load vault key: %w", err)
+	}
+
+	// Get the public key of the current vault key
+	_, pubKeyStr, err := vaultKey.Recipient()
+	if err != nil {
+		return "", fmt.Errorf("failed to extract recipient from vault key: %w", err)
+	}
+
+	return pubKeyStr, nil
+}
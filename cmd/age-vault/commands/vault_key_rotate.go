@@ -0,0 +1,240 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/leolimasa/age-vault/config"
+	"github.com/leolimasa/age-vault/keymgmt"
+	"github.com/leolimasa/age-vault/members"
+	"github.com/leolimasa/age-vault/vault"
+)
+
+// rotatedKey is a vault key encrypted for a single recipient, waiting to be written to disk.
+type rotatedKey struct {
+	outputPath   string
+	encryptedKey []byte
+}
+
+// rotationTarget is a named recipient the rotated vault key is encrypted for.
+type rotationTarget struct {
+	name      string
+	recipient age.Recipient
+}
+
+// RunVaultKeyRotate handles the vault-key rotate command.
+// It generates a brand new vault key, encrypts it for every recipient found in
+// recipientsPath (a public key file or a directory of public key files), or for every
+// member of the recipients registry if recipientsPath is empty, and writes
+// one encrypted vault key file per recipient into outputDir.
+// The previous vault keys are kept as retired keys in the new keyring unless dropRetired is set,
+// so data encrypted before the rotation stays decryptable until it is re-encrypted.
+// The configured vault key file is only replaced once every recipient file was written.
+// The previous vault key file is kept as a timestamped backup.
+// keyType selects the type of the new vault key ("x25519" or "hybrid"); by default it is the
+// type of the current vault key.
+func RunVaultKeyRotate(recipientsPath string, outputDir string, dropRetired bool, keyType string, cfg *config.Config) error {
+	// Load our identity first, so we fail before doing any work if it is missing
+	userIdentities, err := keymgmt.LoadIdentities(cfg.IdentityFile)
+	if err != nil {
+		return fmt.Errorf("failed to load identity: %w", err)
+	}
+
+	userLayers, err := keymgmt.SelfRecipientLayers(cfg.IdentityFile)
+	if err != nil {
+		return err
+	}
+
+	// Collect everyone the new vault key must be encrypted for
+	var targets []rotationTarget
+	if recipientsPath != "" {
+		targets, err = rotationTargetsFromPath(recipientsPath)
+	} else {
+		targets, err = rotationTargetsFromRegistry(cfg)
+	}
+	if err != nil {
+		return err
+	}
+
+	// The current keyring is retired into the new one, and gives the vault ID, the generation
+	// and the default key type. It must load even with dropRetired, or the new keyring would
+	// get a fresh vault ID and orphan every envelope and file header bound to the current one.
+	var currentVaultKey *vault.VaultKey
+	if _, err := os.Stat(cfg.VaultKeyFile); err == nil {
+		currentVaultKey, err = keymgmt.VaultKeyFromIdentityFile(cfg.IdentityFile, cfg.VaultKeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load current vault key: %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to check vault key file: %w", err)
+	}
+
+	newKeyType := vault.KeyTypeX25519
+	if keyType != "" {
+		newKeyType, err = vault.ParseKeyType(keyType)
+		if err != nil {
+			return err
+		}
+	} else if currentVaultKey != nil {
+		if currentIdentity, err := currentVaultKey.GetIdentity(); err == nil {
+			if currentType, err := vault.KeyTypeOf(currentIdentity); err == nil {
+				newKeyType = currentType
+			}
+		}
+	}
+
+	// Generate the new vault key
+	newIdentity, err := vault.GenerateVaultKeyOfType(newKeyType)
+	if err != nil {
+		return fmt.Errorf("failed to generate vault key: %w", err)
+	}
+
+	// Retire the current keyring, if there is one
+	newVaultKey := vault.NewVaultKey(newIdentity)
+	if currentVaultKey != nil {
+		newVaultKey = currentVaultKey.Rotate(newIdentity)
+		if dropRetired {
+			newVaultKey = newVaultKey.DropRetired()
+		}
+	}
+
+	// Encrypt the new vault key for every recipient in memory before touching the disk
+	var rotated []rotatedKey
+	for _, target := range targets {
+		encryptedKey, err := encryptVaultKeyring(newVaultKey, target.name, cfg, target.recipient)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt vault key for %s: %w", target.name, err)
+		}
+		rotated = append(rotated, rotatedKey{
+			outputPath:   filepath.Join(outputDir, target.name+".age"),
+			encryptedKey: encryptedKey,
+		})
+	}
+
+	// Encrypt the new vault key for ourselves
+	encryptedForUs, err := keymgmt.EncryptVaultKeyringLayers(newVaultKey, selfLabel(), cfg, userLayers...)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt vault key for self: %w", err)
+	}
+
+	// Make sure we can actually decrypt what we are about to save
+	if _, err := vault.DecryptVaultKey(encryptedForUs, userIdentities...); err != nil {
+		return fmt.Errorf("failed to verify new vault key with identity: %w", err)
+	}
+
+	// Write one encrypted vault key file per recipient
+	for _, r := range rotated {
+		if err := keymgmt.WriteFileAtomic(r.outputPath, r.encryptedKey); err != nil {
+			return fmt.Errorf("failed to write encrypted vault key: %w", err)
+		}
+		fmt.Fprintf(os.Stderr, "Vault key encrypted and saved to %s\n", r.outputPath)
+	}
+
+	// Everything succeeded, back up and replace our own vault key
+	if _, err := os.Stat(cfg.VaultKeyFile); err == nil {
+		backupPath, err := keymgmt.BackupFile(cfg.VaultKeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to back up previous vault key: %w", err)
+		}
+		fmt.Fprintf(os.Stderr, "Previous vault key backed up to %s\n", backupPath)
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to check vault key file: %w", err)
+	}
+
+	if err := keymgmt.WriteFileAtomic(cfg.VaultKeyFile, encryptedForUs); err != nil {
+		return fmt.Errorf("failed to save vault key: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "Vault key rotated and saved to %s\n", cfg.VaultKeyFile)
+
+	return nil
+}
+
+// rotationTargetsFromPath reads the recipients from a public key file or a directory of
+// public key files. Targets are named after the public key file; files holding several
+// public keys produce one numbered target per key.
+func rotationTargetsFromPath(recipientsPath string) ([]rotationTarget, error) {
+	recipientFiles, err := listRecipientFiles(recipientsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []rotationTarget
+	for _, recipientFile := range recipientFiles {
+		content, err := os.ReadFile(recipientFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read public key file %s: %w", recipientFile, err)
+		}
+		recipients, err := keymgmt.ParseRecipients(content)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse public key file %s: %w", recipientFile, err)
+		}
+
+		baseName := strings.TrimSuffix(filepath.Base(recipientFile), filepath.Ext(recipientFile))
+		for i, recipient := range recipients {
+			name := baseName
+			if len(recipients) > 1 {
+				name = fmt.Sprintf("%s-%d", baseName, i+1)
+			}
+			targets = append(targets, rotationTarget{name: name, recipient: recipient})
+		}
+	}
+
+	return targets, nil
+}
+
+// rotationTargetsFromRegistry returns one target per member of the recipients registry.
+func rotationTargetsFromRegistry(cfg *config.Config) ([]rotationTarget, error) {
+	registry, err := members.Load(cfg.RecipientsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load recipients registry: %w", err)
+	}
+	if len(registry.Members) == 0 {
+		return nil, fmt.Errorf("no recipients given and no members registered in %s", cfg.RecipientsFile)
+	}
+
+	var targets []rotationTarget
+	for _, m := range registry.Members {
+		recipients, err := keymgmt.ParseRecipients([]byte(m.Recipient))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse public key of member %s: %w", m.Name, err)
+		}
+		targets = append(targets, rotationTarget{name: m.Name, recipient: recipients[0]})
+	}
+
+	return targets, nil
+}
+
+// listRecipientFiles returns the public key files found at path.
+// If path is a directory, every regular, non-hidden file inside it is returned.
+func listRecipientFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to access recipients path: %w", err)
+	}
+
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read recipients directory: %w", err)
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		files = append(files, filepath.Join(path, entry.Name()))
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no public key files found in %s", path)
+	}
+
+	return files, nil
+}
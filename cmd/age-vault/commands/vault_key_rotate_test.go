@@ -0,0 +1,258 @@
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+
+	"github.com/leolimasa/age-vault/config"
+	"github.com/leolimasa/age-vault/keymgmt"
+	"github.com/leolimasa/age-vault/vault"
+)
+
+func TestRunVaultKeyRotate(t *testing.T) {
+	tempDir := t.TempDir()
+
+	// Generate our identity and an existing vault key
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("failed to generate test identity: %v", err)
+	}
+	identityPath := filepath.Join(tempDir, "identity.txt")
+	if err := os.WriteFile(identityPath, []byte(identity.String()+"\n"), 0600); err != nil {
+		t.Fatalf("failed to write identity file: %v", err)
+	}
+
+	oldVaultKey, err := vault.GenerateVaultKey()
+	if err != nil {
+		t.Fatalf("failed to generate vault key: %v", err)
+	}
+	encryptedKey, err := vault.EncryptVaultKey(oldVaultKey, identity.Recipient())
+	if err != nil {
+		t.Fatalf("failed to encrypt vault key: %v", err)
+	}
+	vaultKeyPath := filepath.Join(tempDir, "vault_key.age")
+	if err := os.WriteFile(vaultKeyPath, encryptedKey, 0600); err != nil {
+		t.Fatalf("failed to write vault key file: %v", err)
+	}
+
+	// Create a directory with two member public keys
+	recipientsDir := filepath.Join(tempDir, "recipients")
+	if err := os.MkdirAll(recipientsDir, 0700); err != nil {
+		t.Fatalf("failed to create recipients dir: %v", err)
+	}
+	members := map[string]*age.X25519Identity{}
+	for _, name := range []string{"laptop", "desktop"} {
+		member, err := age.GenerateX25519Identity()
+		if err != nil {
+			t.Fatalf("failed to generate member identity: %v", err)
+		}
+		members[name] = member
+		pubkeyPath := filepath.Join(recipientsDir, name+".txt")
+		if err := os.WriteFile(pubkeyPath, []byte(member.Recipient().String()+"\n"), 0600); err != nil {
+			t.Fatalf("failed to write public key file: %v", err)
+		}
+	}
+
+	cfg := &config.Config{
+		IdentityFile: identityPath,
+		VaultKeyFile: vaultKeyPath,
+	}
+
+	outputDir := filepath.Join(tempDir, "out")
+	if err := RunVaultKeyRotate(recipientsDir, outputDir, false, "", cfg); err != nil {
+		t.Fatalf("RunVaultKeyRotate failed: %v", err)
+	}
+
+	// Our vault key must now be a different key
+	newVaultKey, err := keymgmt.VaultKeyFromIdentityFile(identityPath, vaultKeyPath)
+	if err != nil {
+		t.Fatalf("failed to load rotated vault key: %v", err)
+	}
+	newIdentity, err := newVaultKey.GetIdentity()
+	if err != nil {
+		t.Fatalf("failed to get rotated vault key identity: %v", err)
+	}
+	newKeyStr := newIdentity.(*age.X25519Identity).String()
+	if newKeyStr == oldVaultKey.(*age.X25519Identity).String() {
+		t.Error("vault key was not rotated")
+	}
+
+	// Every member must be able to decrypt the same new vault key
+	for name, member := range members {
+		data, err := os.ReadFile(filepath.Join(outputDir, name+".age"))
+		if err != nil {
+			t.Fatalf("failed to read encrypted vault key for %s: %v", name, err)
+		}
+		memberKey, err := vault.DecryptVaultKey(data, member)
+		if err != nil {
+			t.Fatalf("member %s cannot decrypt rotated vault key: %v", name, err)
+		}
+		memberIdentity, _ := memberKey.GetIdentity()
+		if memberIdentity.(*age.X25519Identity).String() != newKeyStr {
+			t.Errorf("member %s received a different vault key", name)
+		}
+	}
+
+	// The previous vault key must be kept as a retired key
+	identities := newVaultKey.Identities()
+	if len(identities) != 2 || identities[1].(*age.X25519Identity).String() != oldVaultKey.(*age.X25519Identity).String() {
+		t.Error("previous vault key was not kept as a retired key")
+	}
+
+	// The previous vault key must have been backed up
+	backups, err := filepath.Glob(vaultKeyPath + ".*.bak")
+	if err != nil || len(backups) != 1 {
+		t.Fatalf("expected one vault key backup, got %v (err: %v)", backups, err)
+	}
+	if _, err := keymgmt.VaultKeyFromIdentityFile(identityPath, backups[0]); err != nil {
+		t.Errorf("backup does not contain the previous vault key: %v", err)
+	}
+}
+
+func TestRunVaultKeyRotate_InvalidRecipientKeepsVaultKey(t *testing.T) {
+	tempDir := t.TempDir()
+
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("failed to generate test identity: %v", err)
+	}
+	identityPath := filepath.Join(tempDir, "identity.txt")
+	if err := os.WriteFile(identityPath, []byte(identity.String()+"\n"), 0600); err != nil {
+		t.Fatalf("failed to write identity file: %v", err)
+	}
+
+	vaultKeyIdentity, err := vault.GenerateVaultKey()
+	if err != nil {
+		t.Fatalf("failed to generate vault key: %v", err)
+	}
+	encryptedKey, err := vault.EncryptVaultKey(vaultKeyIdentity, identity.Recipient())
+	if err != nil {
+		t.Fatalf("failed to encrypt vault key: %v", err)
+	}
+	vaultKeyPath := filepath.Join(tempDir, "vault_key.age")
+	if err := os.WriteFile(vaultKeyPath, encryptedKey, 0600); err != nil {
+		t.Fatalf("failed to write vault key file: %v", err)
+	}
+
+	recipientsFile := filepath.Join(tempDir, "recipients.txt")
+	if err := os.WriteFile(recipientsFile, []byte("not-a-public-key\n"), 0600); err != nil {
+		t.Fatalf("failed to write recipients file: %v", err)
+	}
+
+	cfg := &config.Config{
+		IdentityFile: identityPath,
+		VaultKeyFile: vaultKeyPath,
+	}
+
+	if err := RunVaultKeyRotate(recipientsFile, filepath.Join(tempDir, "out"), false, "", cfg); err == nil {
+		t.Fatal("expected error with invalid recipient, got nil")
+	}
+
+	// The vault key file must be untouched
+	data, err := os.ReadFile(vaultKeyPath)
+	if err != nil {
+		t.Fatalf("failed to read vault key file: %v", err)
+	}
+	if string(data) != string(encryptedKey) {
+		t.Error("vault key file was modified despite the rotation failing")
+	}
+}
+
+func TestRunVaultKeyRotate_UnreadableVaultKey(t *testing.T) {
+	tempDir := t.TempDir()
+
+	identityPath := filepath.Join(tempDir, "identity.txt")
+	writeTestIdentity(t, identityPath)
+
+	// The vault key file belongs to someone else
+	otherIdentity := writeTestIdentity(t, filepath.Join(tempDir, "other.txt"))
+	vaultKeyPath := filepath.Join(tempDir, "vault_key.age")
+	writeTestVaultKey(t, otherIdentity, vaultKeyPath)
+	encryptedKey, err := os.ReadFile(vaultKeyPath)
+	if err != nil {
+		t.Fatalf("failed to read vault key file: %v", err)
+	}
+
+	recipientsFile := filepath.Join(tempDir, "recipients.txt")
+	if err := os.WriteFile(recipientsFile, []byte(otherIdentity.Recipient().String()+"\n"), 0600); err != nil {
+		t.Fatalf("failed to write recipients file: %v", err)
+	}
+
+	cfg := &config.Config{
+		IdentityFile: identityPath,
+		VaultKeyFile: vaultKeyPath,
+	}
+
+	// Dropping retired keys doesn't allow starting a new vault over the current one
+	if err := RunVaultKeyRotate(recipientsFile, filepath.Join(tempDir, "out"), true, "", cfg); err == nil {
+		t.Fatal("expected error with an unreadable vault key, got nil")
+	}
+
+	data, err := os.ReadFile(vaultKeyPath)
+	if err != nil {
+		t.Fatalf("failed to read vault key file: %v", err)
+	}
+	if string(data) != string(encryptedKey) {
+		t.Error("vault key file was modified despite the rotation failing")
+	}
+}
+
+func TestRunVaultKeyRotate_HybridKeyType(t *testing.T) {
+	tempDir := t.TempDir()
+
+	identityPath := filepath.Join(tempDir, "identity.txt")
+	identity := writeTestIdentity(t, identityPath)
+	vaultKeyPath := filepath.Join(tempDir, "vault_key.age")
+	oldVaultKey := writeTestVaultKey(t, identity, vaultKeyPath)
+	oldVaultKeyIdentity, _ := oldVaultKey.GetIdentity()
+
+	recipientsFile := filepath.Join(tempDir, "me.txt")
+	if err := os.WriteFile(recipientsFile, []byte(identity.Recipient().String()+"\n"), 0600); err != nil {
+		t.Fatalf("failed to write recipients file: %v", err)
+	}
+
+	cfg := &config.Config{
+		IdentityFile: identityPath,
+		VaultKeyFile: vaultKeyPath,
+	}
+
+	currentKeyType := func() vault.KeyType {
+		t.Helper()
+		vaultKey, err := keymgmt.VaultKeyFromIdentityFile(identityPath, vaultKeyPath)
+		if err != nil {
+			t.Fatalf("failed to load vault key: %v", err)
+		}
+		if !vaultKey.Contains(oldVaultKeyIdentity) {
+			t.Error("the original vault key was not kept as a retired key")
+		}
+		current, _ := vaultKey.GetIdentity()
+		keyType, err := vault.KeyTypeOf(current)
+		if err != nil {
+			t.Fatalf("failed to get vault key type: %v", err)
+		}
+		return keyType
+	}
+
+	// Migrate to a post-quantum vault key
+	if err := RunVaultKeyRotate(recipientsFile, filepath.Join(tempDir, "out1"), false, "hybrid", cfg); err != nil {
+		t.Fatalf("RunVaultKeyRotate failed: %v", err)
+	}
+	if keyType := currentKeyType(); keyType != vault.KeyTypeHybrid {
+		t.Errorf("expected a hybrid vault key, got %s", keyType)
+	}
+
+	// Later rotations keep the key type
+	if err := RunVaultKeyRotate(recipientsFile, filepath.Join(tempDir, "out2"), false, "", cfg); err != nil {
+		t.Fatalf("RunVaultKeyRotate failed: %v", err)
+	}
+	if keyType := currentKeyType(); keyType != vault.KeyTypeHybrid {
+		t.Errorf("expected the hybrid key type to be kept, got %s", keyType)
+	}
+
+	if err := RunVaultKeyRotate(recipientsFile, filepath.Join(tempDir, "out3"), false, "rsa", cfg); err == nil {
+		t.Error("expected error for an unknown key type")
+	}
+}
@@ -2,18 +2,110 @@ package commands
 
 import (
 	"fmt"
+	"os"
 
 	"github.com/leolimasa/age-vault/config"
 	"github.com/leolimasa/age-vault/keymgmt"
+	"github.com/leolimasa/age-vault/vault"
 )
 
 // RunVaultKeySet handles the vault-key set command.
-// It copies the provided encrypted vault key file to the configured vault key location.
-func RunVaultKeySet(sourcePath string, cfg *config.Config) error {
-	if err := keymgmt.CopyFile(sourcePath, cfg.VaultKeyFile); err != nil {
-		return fmt.Errorf("failed to set vault key: %w", err)
+// It copies the provided encrypted vault key file to the configured vault key location,
+// after checking that it will not lock us out (see setVaultKey).
+func RunVaultKeySet(sourcePath string, force bool, cfg *config.Config) error {
+	encryptedKey, err := os.ReadFile(sourcePath)
+	if err != nil {
+		return fmt.Errorf("failed to set vault key: error reading %s: %w", sourcePath, err)
+	}
+
+	if err := setVaultKey(encryptedKey, force, cfg); err != nil {
+		return err
 	}
 
 	fmt.Printf("Vault key set to: %s\n", cfg.VaultKeyFile)
 	return nil
 }
+
+// setVaultKey saves an encrypted vault key to the configured vault key location.
+// To avoid locking ourselves out, the new vault key must decrypt with our identity and, if a
+// vault key is already configured, it must decrypt too and the new one belong to the same vault
+// (the same key, or a rotation that kept it as a retired key). force skips these checks. The previous file is kept as a
+// timestamped backup next to it.
+func setVaultKey(encryptedKey []byte, force bool, cfg *config.Config) error {
+	if err := guardVaultKeyReplacement(checkVaultKeyReplacement(encryptedKey, cfg), force, cfg); err != nil {
+		return err
+	}
+	if err := backupVaultKey(cfg); err != nil {
+		return err
+	}
+
+	if err := keymgmt.WriteFileAtomic(cfg.VaultKeyFile, encryptedKey); err != nil {
+		return fmt.Errorf("failed to set vault key: %w", err)
+	}
+	return nil
+}
+
+// guardVaultKeyReplacement turns a failed lock-out check into a refusal, or into a warning
+// with force.
+func guardVaultKeyReplacement(checkErr error, force bool, cfg *config.Config) error {
+	if checkErr == nil {
+		return nil
+	}
+	if !force {
+		return fmt.Errorf("refusing to replace %s: %w (use --force to override)", cfg.VaultKeyFile, checkErr)
+	}
+	fmt.Fprintf(os.Stderr, "Warning: %v\n", checkErr)
+	return nil
+}
+
+// backupVaultKey keeps the configured vault key file, if any, as a timestamped backup.
+func backupVaultKey(cfg *config.Config) error {
+	if _, err := os.Stat(cfg.VaultKeyFile); err == nil {
+		backupPath, err := keymgmt.BackupFile(cfg.VaultKeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to back up vault key: %w", err)
+		}
+		fmt.Fprintf(os.Stderr, "Previous vault key backed up to %s\n", backupPath)
+	}
+	return nil
+}
+
+// checkVaultKeyReplacement returns an error if replacing the configured vault key with
+// encryptedKey would lock us out of the vault.
+func checkVaultKeyReplacement(encryptedKey []byte, cfg *config.Config) error {
+	userIdentities, err := keymgmt.LoadIdentities(cfg.IdentityFile)
+	if err != nil {
+		return fmt.Errorf("failed to load identity: %w", err)
+	}
+
+	newVaultKey, err := vault.DecryptVaultKey(encryptedKey, userIdentities...)
+	if err != nil {
+		return fmt.Errorf("the new vault key cannot be decrypted with the identity in %s", cfg.IdentityFile)
+	}
+
+	return checkSameVault(newVaultKey, cfg)
+}
+
+// checkSameVault returns an error if a vault key is configured and newVaultKey does not
+// belong to the same vault.
+func checkSameVault(newVaultKey *vault.VaultKey, cfg *config.Config) error {
+	if _, err := os.Stat(cfg.VaultKeyFile); os.IsNotExist(err) {
+		return nil
+	}
+
+	// The current vault key may only be out of reach for now (e.g. an unplugged hardware
+	// token), so one we cannot decrypt is not replaced without --force
+	currentVaultKey, err := keymgmt.VaultKeyFromIdentityFile(cfg.IdentityFile, cfg.VaultKeyFile)
+	if err != nil {
+		return fmt.Errorf("cannot check that the new vault key belongs to the same vault, the current one cannot be decrypted: %w", err)
+	}
+	currentIdentity, err := currentVaultKey.GetIdentity()
+	if err != nil {
+		return fmt.Errorf("cannot check that the new vault key belongs to the same vault: %w", err)
+	}
+
+	if !newVaultKey.Contains(currentIdentity) {
+		return fmt.Errorf("the new vault key belongs to a different vault than the current one")
+	}
+	return nil
+}
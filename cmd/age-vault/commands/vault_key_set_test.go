@@ -0,0 +1,232 @@
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+
+	"github.com/leolimasa/age-vault/config"
+	"github.com/leolimasa/age-vault/keymgmt"
+	"github.com/leolimasa/age-vault/vault"
+)
+
+// listBackups returns the backups of path made by keymgmt.BackupFile.
+func listBackups(t *testing.T, path string) []string {
+	t.Helper()
+
+	backups, err := filepath.Glob(path + ".*.bak")
+	if err != nil {
+		t.Fatalf("failed to list backups: %v", err)
+	}
+	return backups
+}
+
+func TestRunVaultKeySet_LockoutProtection(t *testing.T) {
+	tempDir := t.TempDir()
+
+	identityPath := filepath.Join(tempDir, "identity.txt")
+	identity := writeTestIdentity(t, identityPath)
+	vaultKeyPath := filepath.Join(tempDir, "vault_key.age")
+	vaultKey := writeTestVaultKey(t, identity, vaultKeyPath)
+	original, err := os.ReadFile(vaultKeyPath)
+	if err != nil {
+		t.Fatalf("failed to read vault key: %v", err)
+	}
+
+	cfg := &config.Config{
+		IdentityFile: identityPath,
+		VaultKeyFile: vaultKeyPath,
+	}
+
+	// Same vault key, encrypted for someone else
+	other, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("failed to generate identity: %v", err)
+	}
+	forOther, err := vault.EncryptVaultKeyring(vaultKey, other.Recipient())
+	if err != nil {
+		t.Fatalf("failed to encrypt vault key: %v", err)
+	}
+	forOtherPath := filepath.Join(tempDir, "other.age")
+	if err := os.WriteFile(forOtherPath, forOther, 0600); err != nil {
+		t.Fatalf("failed to write vault key: %v", err)
+	}
+
+	// Another vault, encrypted for us
+	otherVaultPath := filepath.Join(tempDir, "other_vault.age")
+	writeTestVaultKey(t, identity, otherVaultPath)
+
+	tests := []struct {
+		name string
+		path string
+	}{
+		{"not decryptable", forOtherPath},
+		{"different vault", otherVaultPath},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := RunVaultKeySet(tt.path, false, cfg)
+			if err == nil || !strings.Contains(err.Error(), "--force") {
+				t.Fatalf("expected refusal mentioning --force, got: %v", err)
+			}
+
+			current, err := os.ReadFile(vaultKeyPath)
+			if err != nil {
+				t.Fatalf("failed to read vault key: %v", err)
+			}
+			if string(current) != string(original) {
+				t.Error("vault key file was modified")
+			}
+			if backups := listBackups(t, vaultKeyPath); len(backups) != 0 {
+				t.Errorf("expected no backup, got %v", backups)
+			}
+		})
+	}
+
+	// --force replaces it anyway and keeps a backup
+	if err := RunVaultKeySet(otherVaultPath, true, cfg); err != nil {
+		t.Fatalf("RunVaultKeySet with force failed: %v", err)
+	}
+	backups := listBackups(t, vaultKeyPath)
+	if len(backups) != 1 {
+		t.Fatalf("expected one backup, got %v", backups)
+	}
+	backup, err := os.ReadFile(backups[0])
+	if err != nil {
+		t.Fatalf("failed to read backup: %v", err)
+	}
+	if string(backup) != string(original) {
+		t.Error("backup does not contain the previous vault key")
+	}
+}
+
+func TestRunVaultKeySet_UndecryptableCurrentKey(t *testing.T) {
+	tempDir := t.TempDir()
+
+	// The current vault key was encrypted for an identity we don't have at hand
+	identityPath := filepath.Join(tempDir, "identity.txt")
+	identity := writeTestIdentity(t, identityPath)
+	other, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("failed to generate identity: %v", err)
+	}
+	vaultKeyPath := filepath.Join(tempDir, "vault_key.age")
+	writeTestVaultKey(t, other, vaultKeyPath)
+	original, err := os.ReadFile(vaultKeyPath)
+	if err != nil {
+		t.Fatalf("failed to read vault key: %v", err)
+	}
+
+	cfg := &config.Config{
+		IdentityFile: identityPath,
+		VaultKeyFile: vaultKeyPath,
+	}
+
+	// A key of another vault cannot silently replace it
+	otherVaultPath := filepath.Join(tempDir, "other_vault.age")
+	writeTestVaultKey(t, identity, otherVaultPath)
+	err = RunVaultKeySet(otherVaultPath, false, cfg)
+	if err == nil || !strings.Contains(err.Error(), "cannot be decrypted") || !strings.Contains(err.Error(), "--force") {
+		t.Fatalf("expected refusal mentioning --force, got: %v", err)
+	}
+	current, err := os.ReadFile(vaultKeyPath)
+	if err != nil {
+		t.Fatalf("failed to read vault key: %v", err)
+	}
+	if string(current) != string(original) {
+		t.Error("vault key file was modified")
+	}
+
+	if err := RunVaultKeySet(otherVaultPath, true, cfg); err != nil {
+		t.Fatalf("RunVaultKeySet with force failed: %v", err)
+	}
+	if backups := listBackups(t, vaultKeyPath); len(backups) != 1 {
+		t.Errorf("expected one backup, got %v", backups)
+	}
+}
+
+func TestRunVaultKeySet_AcceptsRotatedKey(t *testing.T) {
+	tempDir := t.TempDir()
+
+	identityPath := filepath.Join(tempDir, "identity.txt")
+	identity := writeTestIdentity(t, identityPath)
+	vaultKeyPath := filepath.Join(tempDir, "vault_key.age")
+	vaultKey := writeTestVaultKey(t, identity, vaultKeyPath)
+
+	cfg := &config.Config{
+		IdentityFile: identityPath,
+		VaultKeyFile: vaultKeyPath,
+	}
+
+	// A rotation made by another member keeps the current key as a retired key
+	newIdentity, err := vault.GenerateVaultKey()
+	if err != nil {
+		t.Fatalf("failed to generate vault key: %v", err)
+	}
+	rotated, err := vault.EncryptVaultKeyring(vaultKey.Rotate(newIdentity), identity.Recipient())
+	if err != nil {
+		t.Fatalf("failed to encrypt rotated vault key: %v", err)
+	}
+	rotatedPath := filepath.Join(tempDir, "rotated.age")
+	if err := os.WriteFile(rotatedPath, rotated, 0600); err != nil {
+		t.Fatalf("failed to write rotated vault key: %v", err)
+	}
+
+	if err := RunVaultKeySet(rotatedPath, false, cfg); err != nil {
+		t.Fatalf("RunVaultKeySet failed: %v", err)
+	}
+
+	loaded, err := keymgmt.VaultKeyFromIdentityFile(identityPath, vaultKeyPath)
+	if err != nil {
+		t.Fatalf("failed to load vault key: %v", err)
+	}
+	current, _ := loaded.GetIdentity()
+	if current.(*age.X25519Identity).String() != newIdentity.(*age.X25519Identity).String() {
+		t.Error("vault key was not replaced with the rotated one")
+	}
+	if backups := listBackups(t, vaultKeyPath); len(backups) != 1 {
+		t.Errorf("expected one backup, got %v", backups)
+	}
+}
+
+func TestRunVaultKeyEncrypt_SaveRefusesLockout(t *testing.T) {
+	tempDir := t.TempDir()
+
+	identityPath := filepath.Join(tempDir, "identity.txt")
+	identity := writeTestIdentity(t, identityPath)
+	vaultKeyPath := filepath.Join(tempDir, "vault_key.age")
+	writeTestVaultKey(t, identity, vaultKeyPath)
+	original, err := os.ReadFile(vaultKeyPath)
+	if err != nil {
+		t.Fatalf("failed to read vault key: %v", err)
+	}
+
+	cfg := &config.Config{
+		IdentityFile: identityPath,
+		VaultKeyFile: vaultKeyPath,
+	}
+
+	other, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("failed to generate identity: %v", err)
+	}
+
+	if err := RunVaultKeyEncrypt(other.Recipient().String(), "", nil, "", true, false, false, "", cfg); err == nil {
+		t.Fatal("expected --save with someone else's public key to be refused")
+	}
+	current, err := os.ReadFile(vaultKeyPath)
+	if err != nil {
+		t.Fatalf("failed to read vault key: %v", err)
+	}
+	if string(current) != string(original) {
+		t.Error("vault key file was modified")
+	}
+
+	// Saving for our own public key is fine
+	if err := RunVaultKeyEncrypt(identity.Recipient().String(), "", nil, "", true, false, false, "", cfg); err != nil {
+		t.Errorf("expected --save with our own public key to succeed: %v", err)
+	}
+}
@@ -0,0 +1,281 @@
+package commands
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/leolimasa/age-vault/config"
+	"github.com/leolimasa/age-vault/keymgmt"
+	"github.com/leolimasa/age-vault/members"
+	"github.com/leolimasa/age-vault/shamir"
+	"github.com/leolimasa/age-vault/vault"
+)
+
+// shareMarker starts the line holding a vault key share.
+const shareMarker = "age-vault-share/v1"
+
+// vaultKeyShare is a Shamir share of a serialized vault key, as stored in share files.
+type vaultKeyShare struct {
+	SetID     string // Identifies the vault key the share belongs to
+	Threshold int
+	Share     shamir.Share
+}
+
+// shareSetID derives the set ID from the serialized vault key, so the result of combining
+// can be verified.
+func shareSetID(secret []byte) string {
+	sum := sha256.Sum256(append([]byte(shareMarker+"\x00"), secret...))
+	return hex.EncodeToString(sum[:4])
+}
+
+// String formats the share as a share file.
+func (s vaultKeyShare) String() string {
+	return fmt.Sprintf("# age-vault vault key share %d (any %d shares restore the vault key)\n%s %s %d %d %s\n",
+		s.Share.X, s.Threshold, shareMarker, s.SetID, s.Threshold, s.Share.X, base64.RawStdEncoding.EncodeToString(s.Share.Y))
+}
+
+// parseVaultKeyShare parses the content of a share file.
+func parseVaultKeyShare(content string) (vaultKeyShare, error) {
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || fields[0] != shareMarker {
+			continue
+		}
+		if len(fields) != 5 {
+			return vaultKeyShare{}, fmt.Errorf("malformed share line")
+		}
+
+		threshold, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return vaultKeyShare{}, fmt.Errorf("malformed share threshold %q", fields[2])
+		}
+		x, err := strconv.Atoi(fields[3])
+		if err != nil || x < 1 || x > 255 {
+			return vaultKeyShare{}, fmt.Errorf("malformed share number %q", fields[3])
+		}
+		y, err := base64.RawStdEncoding.DecodeString(fields[4])
+		if err != nil {
+			return vaultKeyShare{}, fmt.Errorf("malformed share data: %w", err)
+		}
+
+		return vaultKeyShare{
+			SetID:     fields[1],
+			Threshold: threshold,
+			Share:     shamir.Share{X: byte(x), Y: y},
+		}, nil
+	}
+	return vaultKeyShare{}, fmt.Errorf("no vault key share found")
+}
+
+// RunVaultKeySplit handles the vault-key split command.
+// It splits the decrypted vault key into Shamir shares written to outputDir, any threshold of
+// which restore it with vault-key combine. If custodians are given (one per share, as member
+// names from the recipients registry or NAME=PUBKEY), each share is encrypted to its
+// custodian's public key.
+func RunVaultKeySplit(shares int, threshold int, custodians []string, outputDir string, cfg *config.Config) error {
+	if len(custodians) > 0 && len(custodians) != shares {
+		return fmt.Errorf("%d custodian(s) given for %d shares, one custodian per share is required", len(custodians), shares)
+	}
+
+	// Resolve custodian public keys before touching the vault key
+	type custodian struct {
+		name      string
+		recipient age.Recipient
+	}
+	var resolved []custodian
+	if len(custodians) > 0 {
+		registry, err := members.Load(cfg.RecipientsFile)
+		if err != nil {
+			return fmt.Errorf("failed to load recipients registry: %w", err)
+		}
+		for _, c := range custodians {
+			// Custodian names end up in the share file names
+			name, pubkey, found := strings.Cut(c, "=")
+			if err := members.ValidateName(name); err != nil {
+				return fmt.Errorf("invalid custodian: %w", err)
+			}
+			if !found {
+				member, ok := registry.Get(name)
+				if !ok {
+					return fmt.Errorf("custodian %s is not a registered member (use NAME=PUBKEY for others)", name)
+				}
+				pubkey = member.Recipient
+			}
+			recipients, err := keymgmt.ParseRecipients([]byte(pubkey))
+			if err != nil {
+				return fmt.Errorf("failed to parse public key of custodian %s: %w", name, err)
+			}
+			resolved = append(resolved, custodian{name: name, recipient: recipients[0]})
+		}
+	}
+
+	vaultKey, err := keymgmt.VaultKeyFromIdentityFile(cfg.IdentityFile, cfg.VaultKeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load vault key: %w", err)
+	}
+	secret, err := vaultKey.Marshal()
+	if err != nil {
+		return fmt.Errorf("failed to serialize vault key: %w", err)
+	}
+
+	split, err := shamir.Split(secret, shares, threshold)
+	if err != nil {
+		return fmt.Errorf("failed to split vault key: %w", err)
+	}
+
+	setID := shareSetID(secret)
+	for i, s := range split {
+		content := []byte(vaultKeyShare{SetID: setID, Threshold: threshold, Share: s}.String())
+		outputPath := filepath.Join(outputDir, fmt.Sprintf("share-%d.txt", s.X))
+
+		if len(resolved) > 0 {
+			content, err = encryptShare(content, resolved[i].recipient)
+			if err != nil {
+				return fmt.Errorf("failed to encrypt share for custodian %s: %w", resolved[i].name, err)
+			}
+			outputPath = filepath.Join(outputDir, fmt.Sprintf("share-%d.%s.age", s.X, resolved[i].name))
+		}
+
+		if err := keymgmt.WriteFileAtomic(outputPath, content); err != nil {
+			return fmt.Errorf("failed to write share: %w", err)
+		}
+		fmt.Fprintf(os.Stderr, "Share %d saved to %s\n", s.X, outputPath)
+	}
+
+	fmt.Fprintf(os.Stderr, "Any %d of the %d shares restore the vault key with 'age-vault vault-key combine'\n", threshold, shares)
+	return nil
+}
+
+// RunVaultKeyCombine handles the vault-key combine command.
+// It reconstructs the vault key from share files made by vault-key split and installs it for
+// the configured identity like vault-key set. Shares encrypted to a custodian are decrypted
+// with the configured identity or one of identityFiles, which may be compound identity files.
+// All shares must come from the same split: same vault key and same threshold.
+func RunVaultKeyCombine(sharePaths []string, identityFiles []string, force bool, cfg *config.Config) error {
+	var identities []age.Identity
+	loadIdentities := func() ([]age.Identity, error) {
+		if identities != nil {
+			return identities, nil
+		}
+		for _, path := range append([]string{cfg.IdentityFile}, identityFiles...) {
+			loaded, err := keymgmt.LoadIdentities(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load identity %s: %w", path, err)
+			}
+			identities = append(identities, loaded...)
+		}
+		return identities, nil
+	}
+
+	var shares []vaultKeyShare
+	for _, path := range sharePaths {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read share: %w", err)
+		}
+
+		if isAgeEncrypted(content) {
+			ids, err := loadIdentities()
+			if err != nil {
+				return err
+			}
+			content, err = decryptShare(content, ids)
+			if err != nil {
+				return fmt.Errorf("failed to decrypt share %s: %w", path, err)
+			}
+		}
+
+		share, err := parseVaultKeyShare(string(content))
+		if err != nil {
+			return fmt.Errorf("failed to parse share %s: %w", path, err)
+		}
+		if len(shares) > 0 && share.SetID != shares[0].SetID {
+			return fmt.Errorf("share %s belongs to a different vault key than %s", path, sharePaths[0])
+		}
+		if len(shares) > 0 && share.Threshold != shares[0].Threshold {
+			return fmt.Errorf("share %s has threshold %d but %s has threshold %d, they come from different splits",
+				path, share.Threshold, sharePaths[0], shares[0].Threshold)
+		}
+		shares = append(shares, share)
+	}
+
+	if len(shares) == 0 {
+		return fmt.Errorf("no shares provided")
+	}
+	if len(shares) < shares[0].Threshold {
+		return fmt.Errorf("%d share(s) provided, %d required", len(shares), shares[0].Threshold)
+	}
+
+	parts := make([]shamir.Share, len(shares))
+	for i, s := range shares {
+		parts[i] = s.Share
+	}
+	secret, err := shamir.Combine(parts)
+	if err != nil {
+		return fmt.Errorf("failed to combine shares: %w", err)
+	}
+	if shareSetID(secret) != shares[0].SetID {
+		return fmt.Errorf("combined shares do not match the original vault key (corrupted share?)")
+	}
+
+	vaultKey, err := vault.ParseVaultKey(secret)
+	if err != nil {
+		return fmt.Errorf("failed to parse combined vault key: %w", err)
+	}
+
+	if err := saveVaultKeyForSelf(vaultKey, force, cfg); err != nil {
+		return err
+	}
+
+	fmt.Printf("Vault key restored to: %s\n", cfg.VaultKeyFile)
+	return nil
+}
+
+// isAgeEncrypted reports whether content is an age file, binary or armored.
+func isAgeEncrypted(content []byte) bool {
+	return bytes.HasPrefix(content, []byte("age-encryption.org/")) ||
+		bytes.HasPrefix(bytes.TrimSpace(content), []byte(armor.Header))
+}
+
+// encryptShare encrypts a share file for a custodian, armored so it can be sent as text.
+func encryptShare(content []byte, recipient age.Recipient) ([]byte, error) {
+	var buf bytes.Buffer
+	armorWriter := armor.NewWriter(&buf)
+	w, err := age.Encrypt(armorWriter, recipient)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(content); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	if err := armorWriter.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decryptShare decrypts a share file encrypted by encryptShare (or any age file).
+func decryptShare(content []byte, identities []age.Identity) ([]byte, error) {
+	var r io.Reader = bytes.NewReader(content)
+	if bytes.HasPrefix(bytes.TrimSpace(content), []byte(armor.Header)) {
+		r = armor.NewReader(bytes.NewReader(bytes.TrimSpace(content)))
+	}
+	decryptor, err := age.Decrypt(r, identities...)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(decryptor)
+}
@@ -0,0 +1,162 @@
+package commands
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+
+	"github.com/leolimasa/age-vault/config"
+	"github.com/leolimasa/age-vault/keymgmt"
+	"github.com/leolimasa/age-vault/members"
+)
+
+func TestRunVaultKeySplitCombine(t *testing.T) {
+	tempDir := t.TempDir()
+
+	identityPath := filepath.Join(tempDir, "identity.txt")
+	identity := writeTestIdentity(t, identityPath)
+	vaultKeyPath := filepath.Join(tempDir, "vault_key.age")
+	vaultKey := writeTestVaultKey(t, identity, vaultKeyPath)
+	original, err := vaultKey.Marshal()
+	if err != nil {
+		t.Fatalf("failed to serialize vault key: %v", err)
+	}
+
+	cfg := &config.Config{
+		IdentityFile: identityPath,
+		VaultKeyFile: vaultKeyPath,
+	}
+
+	outputDir := filepath.Join(tempDir, "shares")
+	if err := RunVaultKeySplit(5, 3, nil, outputDir, cfg); err != nil {
+		t.Fatalf("RunVaultKeySplit failed: %v", err)
+	}
+
+	newIdentityPath := filepath.Join(tempDir, "new", "identity.txt")
+	writeTestIdentity(t, newIdentityPath)
+	newCfg := &config.Config{
+		IdentityFile: newIdentityPath,
+		VaultKeyFile: filepath.Join(tempDir, "new", "vault_key.age"),
+	}
+
+	share := func(n string) string { return filepath.Join(outputDir, "share-"+n+".txt") }
+
+	if err := RunVaultKeyCombine([]string{share("1"), share("4")}, nil, false, newCfg); err == nil {
+		t.Fatal("expected combine with fewer shares than the threshold to fail")
+	}
+
+	// Shares of another split of the same vault key are not mixed in
+	otherDir := filepath.Join(tempDir, "other_shares")
+	if err := RunVaultKeySplit(3, 2, nil, otherDir, cfg); err != nil {
+		t.Fatalf("RunVaultKeySplit failed: %v", err)
+	}
+	otherShares := []string{share("5"), filepath.Join(otherDir, "share-2.txt"), filepath.Join(otherDir, "share-3.txt")}
+	if err := RunVaultKeyCombine(otherShares, nil, false, newCfg); err == nil || !strings.Contains(err.Error(), "different splits") {
+		t.Fatalf("expected combine of shares from different splits to fail, got: %v", err)
+	}
+
+	if err := RunVaultKeyCombine([]string{share("5"), share("2"), share("3")}, nil, false, newCfg); err != nil {
+		t.Fatalf("RunVaultKeyCombine failed: %v", err)
+	}
+
+	restored, err := keymgmt.VaultKeyFromIdentityFile(newCfg.IdentityFile, newCfg.VaultKeyFile)
+	if err != nil {
+		t.Fatalf("failed to load restored vault key: %v", err)
+	}
+	restoredBytes, err := restored.Marshal()
+	if err != nil {
+		t.Fatalf("failed to serialize restored vault key: %v", err)
+	}
+	if !bytes.Equal(restoredBytes, original) {
+		t.Error("restored vault key is not byte-identical to the original")
+	}
+}
+
+func TestRunVaultKeySplitCombine_Custodians(t *testing.T) {
+	tempDir := t.TempDir()
+
+	identityPath := filepath.Join(tempDir, "identity.txt")
+	identity := writeTestIdentity(t, identityPath)
+	vaultKeyPath := filepath.Join(tempDir, "vault_key.age")
+	vaultKey := writeTestVaultKey(t, identity, vaultKeyPath)
+	vaultKeyIdentity, _ := vaultKey.GetIdentity()
+
+	// Alice is a registered member, Bob is given inline
+	aliceIdentityPath := filepath.Join(tempDir, "alice.txt")
+	alice := writeTestIdentity(t, aliceIdentityPath)
+	bobIdentityPath := filepath.Join(tempDir, "bob.txt")
+	bob := writeTestIdentity(t, bobIdentityPath)
+
+	registry := &members.Registry{}
+	if err := registry.Add("alice", alice.Recipient().String()); err != nil {
+		t.Fatalf("failed to add member: %v", err)
+	}
+	recipientsPath := filepath.Join(tempDir, config.RecipientsFileName)
+	if err := registry.Save(recipientsPath); err != nil {
+		t.Fatalf("failed to save registry: %v", err)
+	}
+
+	cfg := &config.Config{
+		IdentityFile:   identityPath,
+		VaultKeyFile:   vaultKeyPath,
+		RecipientsFile: recipientsPath,
+	}
+
+	outputDir := filepath.Join(tempDir, "shares")
+	custodians := []string{"alice", "bob=" + bob.Recipient().String()}
+	if err := RunVaultKeySplit(2, 2, custodians, outputDir, cfg); err != nil {
+		t.Fatalf("RunVaultKeySplit failed: %v", err)
+	}
+
+	if err := RunVaultKeySplit(3, 2, custodians, outputDir, cfg); err == nil {
+		t.Error("expected split with fewer custodians than shares to fail")
+	}
+
+	// Custodian names cannot escape the output directory
+	for _, name := range []string{"../../x", "a/b", ".hidden"} {
+		escaping := []string{"alice", name + "=" + bob.Recipient().String()}
+		if err := RunVaultKeySplit(2, 2, escaping, filepath.Join(tempDir, "escaping"), cfg); err == nil || !strings.Contains(err.Error(), "invalid custodian") {
+			t.Errorf("expected custodian name %q to be rejected, got: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "escaping")); !os.IsNotExist(err) {
+		t.Error("expected no shares to be written")
+	}
+
+	// Alice combines on her machine, with Bob's identity at hand
+	aliceCfg := &config.Config{
+		IdentityFile: aliceIdentityPath,
+		VaultKeyFile: filepath.Join(tempDir, "alice_vault_key.age"),
+	}
+	sharePaths := []string{
+		filepath.Join(outputDir, "share-1.alice.age"),
+		filepath.Join(outputDir, "share-2.bob.age"),
+	}
+	if err := RunVaultKeyCombine(sharePaths, nil, false, aliceCfg); err == nil {
+		t.Fatal("expected combine without Bob's identity to fail")
+	}
+	// Bob's identity file is compound, his share opens with its second identity
+	other, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("failed to generate identity: %v", err)
+	}
+	if err := os.WriteFile(bobIdentityPath, []byte(other.String()+"\n"+bob.String()+"\n"), 0600); err != nil {
+		t.Fatalf("failed to write identity file: %v", err)
+	}
+	if err := RunVaultKeyCombine(sharePaths, []string{bobIdentityPath}, false, aliceCfg); err != nil {
+		t.Fatalf("RunVaultKeyCombine failed: %v", err)
+	}
+
+	restored, err := keymgmt.VaultKeyFromIdentityFile(aliceCfg.IdentityFile, aliceCfg.VaultKeyFile)
+	if err != nil {
+		t.Fatalf("failed to load restored vault key: %v", err)
+	}
+	restoredIdentity, _ := restored.GetIdentity()
+	if restoredIdentity.(*age.X25519Identity).String() != vaultKeyIdentity.(*age.X25519Identity).String() {
+		t.Error("restored vault key differs from the original")
+	}
+}
@@ -0,0 +1,84 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/leolimasa/age-vault/config"
+	"github.com/leolimasa/age-vault/keymgmt"
+	"github.com/leolimasa/age-vault/vault"
+)
+
+// RunVaultsList handles the vaults list command.
+// It lists the vaults of age_vault.yml, the implicit top-level one first, then the named ones,
+// marks the selected one, and reports for each whether its vault key can currently be opened
+// with its identity.
+func RunVaultsList(cfg *config.Config) error {
+	entries, err := listVaults(cfg)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Found %d vault(s):\n", len(entries))
+	for _, entry := range entries {
+		// The top-level vault has no name, it is used when no named vault is selected
+		name := entry.name
+		if name == "" {
+			name = "(top level)"
+		}
+		selected := ""
+		if entry.selected {
+			selected = " (selected)"
+		}
+		fmt.Printf("  - %s%s: %s (%s)\n", name, selected, vaultStatus(entry.cfg), entry.cfg.VaultKeyFile)
+	}
+
+	return nil
+}
+
+// vaultEntry is a vault listed by vaults list.
+type vaultEntry struct {
+	name     string // Empty for the top-level vault
+	selected bool
+	cfg      *config.Config
+}
+
+// listVaults returns the top-level vault followed by the named vaults declared in
+// age_vault.yml, each with its own configuration.
+func listVaults(cfg *config.Config) ([]vaultEntry, error) {
+	topLevelCfg, err := config.NewTopLevelConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load top-level configuration: %w", err)
+	}
+	entries := []vaultEntry{{selected: cfg.VaultName == "", cfg: topLevelCfg}}
+
+	for _, name := range cfg.VaultNames {
+		vaultCfg, err := config.NewConfigForVault(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load configuration of vault %s: %w", name, err)
+		}
+		entries = append(entries, vaultEntry{name: name, selected: name == cfg.VaultName, cfg: vaultCfg})
+	}
+
+	return entries, nil
+}
+
+// vaultStatus describes whether the vault key of a configuration can be opened with its
+// identity. Unlike keymgmt.VaultKeyFromIdentityFile, it never prompts for a recovery passphrase.
+func vaultStatus(cfg *config.Config) string {
+	encryptedKey, err := os.ReadFile(cfg.VaultKeyFile)
+	if os.IsNotExist(err) {
+		return "no vault key"
+	} else if err != nil {
+		return fmt.Sprintf("unreadable vault key: %v", err)
+	}
+
+	identities, err := keymgmt.LoadIdentities(cfg.IdentityFile)
+	if err != nil {
+		return fmt.Sprintf("cannot open: %v", err)
+	}
+	if _, err := vault.DecryptVaultKey(encryptedKey, identities...); err != nil {
+		return "cannot open with identity " + cfg.IdentityFile
+	}
+	return "can open"
+}
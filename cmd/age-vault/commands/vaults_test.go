@@ -0,0 +1,76 @@
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/leolimasa/age-vault/config"
+)
+
+func TestVaultStatus(t *testing.T) {
+	tempDir := t.TempDir()
+
+	identityPath := filepath.Join(tempDir, "identity.txt")
+	identity := writeTestIdentity(t, identityPath)
+	vaultKeyPath := filepath.Join(tempDir, "vault_key.age")
+	writeTestVaultKey(t, identity, vaultKeyPath)
+
+	otherIdentityPath := filepath.Join(tempDir, "other.txt")
+	writeTestIdentity(t, otherIdentityPath)
+
+	tests := []struct {
+		name string
+		cfg  *config.Config
+		want string
+	}{
+		{"open", &config.Config{IdentityFile: identityPath, VaultKeyFile: vaultKeyPath}, "can open"},
+		{"other identity", &config.Config{IdentityFile: otherIdentityPath, VaultKeyFile: vaultKeyPath}, "cannot open with identity " + otherIdentityPath},
+		{"no vault key", &config.Config{IdentityFile: identityPath, VaultKeyFile: filepath.Join(tempDir, "missing.age")}, "no vault key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := vaultStatus(tt.cfg); got != tt.want {
+				t.Errorf("vaultStatus() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListVaults(t *testing.T) {
+	tempDir := t.TempDir()
+	t.Setenv("HOME", tempDir)
+	t.Setenv("AGE_VAULT_NAME", "")
+	t.Setenv("AGE_VAULT_KEY_FILE", "")
+	t.Chdir(tempDir)
+
+	configYAML := `default_vault: team
+vault_key_file: personal_key.age
+vaults:
+  team:
+    vault_key_file: team_key.age
+`
+	if err := os.WriteFile(filepath.Join(tempDir, "age_vault.yml"), []byte(configYAML), 0600); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	cfg, err := config.NewConfig()
+	if err != nil {
+		t.Fatalf("NewConfig failed: %v", err)
+	}
+	entries, err := listVaults(cfg)
+	if err != nil {
+		t.Fatalf("listVaults failed: %v", err)
+	}
+
+	// The top-level vault is listed even though default_vault selects another one
+	if len(entries) != 2 {
+		t.Fatalf("got %d vaults, want 2", len(entries))
+	}
+	if entries[0].name != "" || entries[0].selected || filepath.Base(entries[0].cfg.VaultKeyFile) != "personal_key.age" {
+		t.Errorf("unexpected top-level vault: %+v", entries[0])
+	}
+	if entries[1].name != "team" || !entries[1].selected || filepath.Base(entries[1].cfg.VaultKeyFile) != "team_key.age" {
+		t.Errorf("unexpected named vault: %+v", entries[1])
+	}
+}
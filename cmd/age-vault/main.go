@@ -11,38 +11,90 @@ import (
 
 var cfg *config.Config
 
-func main() {
-	// Load configuration
-	var err error
-	cfg, err = config.NewConfig()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error loading configuration: %v\n", err)
-		os.Exit(1)
-	}
+// vaultName is the named vault selected with --vault
+var vaultName string
 
+// passthroughArgs are the arguments of a command without flag parsing (sops), less --vault
+var passthroughArgs []string
+
+func main() {
 	rootCmd := &cobra.Command{
 		Use:   "age-vault",
 		Short: "A secure secret sharing tool built on age encryption",
 		Long: `age-vault enables secure secret sharing across multiple machines using a
 centralized vault key system. Built on top of the age encryption tool.`,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			// Load the configuration once flags are parsed, so --vault overrides AGE_VAULT_NAME
+			// even when that one is invalid
+			var err error
+			if cmd.DisableFlagParsing {
+				// cobra leaves --vault in the arguments of passthrough commands
+				var name string
+				name, passthroughArgs, err = commands.SplitVaultFlag(args)
+				if err != nil {
+					return err
+				}
+				if name != "" {
+					vaultName = name
+				}
+			}
+			if vaultName != "" {
+				cfg, err = config.NewConfigForVault(vaultName)
+			} else {
+				cfg, err = config.NewConfig()
+			}
+			if err != nil {
+				return fmt.Errorf("error loading configuration: %w", err)
+			}
+			return nil
+		},
+	}
+	rootCmd.PersistentFlags().StringVarP(&vaultName, "vault", "V", "", "Named vault from age_vault.yml to use (default: AGE_VAULT_NAME or default_vault)")
+
+	// Add init command
+	var initIdentity string
+	var initPlugin string
+	var initKeyType string
+	initCmd := &cobra.Command{
+		Use:   "init [dir]",
+		Short: "Set up a new vault in a project",
+		Long:  "Sets up a new vault in a project directory (the current directory by default): uses the identity in --identity (default: AGE_VAULT_IDENTITY_FILE or ~/.config/.age-vault/identity.txt), creating it with --plugin if it doesn't exist (asked interactively when age plugins are installed), creates the vault key, writes age_vault.yml, adds the vault key to .gitignore and *.age to .gitattributes, and prints the vault key fingerprint.",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := ""
+			if len(args) > 0 {
+				dir = args[0]
+			}
+			return commands.RunInit(dir, initIdentity, initPlugin, initKeyType, cfg)
+		},
 	}
+	initCmd.Flags().StringVar(&initIdentity, "identity", "", "Identity file to use, created if it doesn't exist")
+	initCmd.Flags().StringVar(&initPlugin, "plugin", "", "How to create the identity: native, tpm, yubikey or se (age-plugin-se)")
+	initCmd.Flags().StringVar(&initKeyType, "key-type", "", "Type of the vault key: x25519 or hybrid (post-quantum) (default x25519)")
+	rootCmd.AddCommand(initCmd)
 
 	// Add encrypt command
 	var encryptOutputFile string
+	var encryptSubvault string
+	var encryptHeader bool
+	var encryptContentType string
 	encryptCmd := &cobra.Command{
 		Use:   "encrypt [file]",
 		Short: "Encrypt a file using the vault key",
-		Long:  "Encrypts a file using the vault key. Reads from stdin if no file is provided.",
+		Long:  "Encrypts a file using the vault key, or the key of the sub-vault given with --subvault. Reads from stdin if no file is provided.",
 		Args:  cobra.MaximumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			inputPath := ""
 			if len(args) > 0 {
 				inputPath = args[0]
 			}
-			return commands.RunEncrypt(inputPath, encryptOutputFile, cfg)
+			return commands.RunEncrypt(inputPath, encryptOutputFile, encryptSubvault, encryptHeader, encryptContentType, cfg)
 		},
 	}
 	encryptCmd.Flags().StringVarP(&encryptOutputFile, "output", "o", "", "Output file (default: stdout)")
+	encryptCmd.Flags().StringVar(&encryptSubvault, "subvault", "", "Encrypt with the key of this sub-vault instead of the vault key")
+	encryptCmd.Flags().BoolVar(&encryptHeader, "header", false, "Start the output with a metadata header (vault ID, key generation, content type, file name)")
+	encryptCmd.Flags().StringVar(&encryptContentType, "content-type", "", "Content type recorded in the metadata header (default: detected from the input)")
 	rootCmd.AddCommand(encryptCmd)
 
 	// Add decrypt command
@@ -50,7 +102,7 @@ centralized vault key system. Built on top of the age encryption tool.`,
 	decryptCmd := &cobra.Command{
 		Use:   "decrypt [file]",
 		Short: "Decrypt a file using the vault key",
-		Long:  "Decrypts a file using the vault key. Reads from stdin if no file is provided.",
+		Long:  "Decrypts a file using the vault key, or the key of the sub-vault it was encrypted for. Reads from stdin if no file is provided.",
 		Args:  cobra.MaximumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			inputPath := ""
@@ -67,14 +119,75 @@ centralized vault key system. Built on top of the age encryption tool.`,
 	sopsCmd := &cobra.Command{
 		Use:                "sops [sops-args...]",
 		Short:              "Run sops with vault key",
-		Long:               "Passthrough to sops that sets up the vault key as an age identity.",
+		Long:               "Passthrough to sops that sets up the vault key as an age identity. The global --vault flag is taken out of the arguments, everything else goes to sops.",
 		DisableFlagParsing: true, // Let sops handle its own flags
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return commands.RunSops(args, cfg)
+			return commands.RunSops(passthroughArgs, cfg)
 		},
 	}
 	rootCmd.AddCommand(sopsCmd)
 
+	// Add rekey command
+	var rekeyOldVaultKeyFile string
+	var rekeySkipSSHKeys bool
+	rekeyCmd := &cobra.Command{
+		Use:   "rekey [paths...]",
+		Short: "Re-encrypt files with the current vault key",
+		Long:  "Re-encrypts vault-encrypted files from retired vault keys (or the vault key in --old-vault-key-file, e.g. the backup left by vault-key rotate) to the current vault key. Directories are scanned recursively for .age files. Encrypted SSH keys in AGE_VAULT_SSH_KEYS_DIR are included unless --skip-ssh-keys is set.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return commands.RunRekey(args, rekeyOldVaultKeyFile, rekeySkipSSHKeys, cfg)
+		},
+	}
+	rekeyCmd.Flags().StringVar(&rekeyOldVaultKeyFile, "old-vault-key-file", "", "Encrypted vault key file of the previous vault key (default: retired keys of the current keyring)")
+	rekeyCmd.Flags().BoolVar(&rekeySkipSSHKeys, "skip-ssh-keys", false, "Do not re-encrypt the SSH keys in AGE_VAULT_SSH_KEYS_DIR")
+	rootCmd.AddCommand(rekeyCmd)
+
+	// Add inspect command
+	var inspectJSON bool
+	inspectCmd := &cobra.Command{
+		Use:   "inspect [files...]",
+		Short: "Show the headers of encrypted files without decrypting them",
+		Long:  "Prints the headers of age encrypted files (vault key files, encrypted SSH keys, secrets) without decrypting them: stanza types, payload size, armor, age-vault envelope or metadata header, and whether the vault key or your identity can open them.",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return commands.RunInspect(args, inspectJSON, cfg)
+		},
+	}
+	inspectCmd.Flags().BoolVar(&inspectJSON, "json", false, "Output JSON")
+	rootCmd.AddCommand(inspectCmd)
+
+	// Add status command
+	statusCmd := &cobra.Command{
+		Use:   "status [dir]",
+		Short: "Check the encrypted files of a repository",
+		Long:  "Scans a directory (by default the one containing age_vault.yml) for vault-encrypted files, found by .age extension, content or an age-vault attribute in .gitattributes. Reports files encrypted to the current vault key, to retired key generations, files that fail to decrypt and plaintext files that look like secrets. Exits non-zero if any problem is found, so it can run in CI.",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := ""
+			if len(args) > 0 {
+				dir = args[0]
+			}
+			return commands.RunStatus(dir, cfg)
+		},
+	}
+	rootCmd.AddCommand(statusCmd)
+
+	// Add doctor command
+	doctorCmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Diagnose the identity, vault key, plugins and ssh agent",
+		Long:  "Checks the setup end to end: which age_vault.yml is used and why, whether the configuration loads, the permissions of the identity and vault key files, whether the identity loads and its plugin binaries are on PATH, a round-trip encrypt/decrypt with the vault key, sops availability and running ssh agents. Exits non-zero if a check fails.",
+		Args:  cobra.NoArgs,
+		// Replaces the root one: doctor loads the configuration itself, to diagnose a broken one
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return commands.RunDoctor(vaultName)
+		},
+	}
+	rootCmd.AddCommand(doctorCmd)
+
 	// Add vault-key command group
 	vaultKeyCmd := &cobra.Command{
 		Use:   "vault-key",
@@ -88,34 +201,52 @@ centralized vault key system. Built on top of the age encryption tool.`,
 	var vaultKeyEncryptPubkey string
 	var vaultKeyEncryptPubkeyFile string
 	var vaultKeyEncryptSave bool
+	var vaultKeyEncryptAll bool
+	var vaultKeyEncryptForce bool
+	var vaultKeyEncryptRecoveryPassphrase bool
+	var vaultKeyEncryptAndPubkeys []string
+	var vaultKeyEncryptKeyType string
 
 	vaultKeyEncryptCmd := &cobra.Command{
 		Use:   "encrypt",
 		Short: "Encrypt vault key for a new recipient",
-		Long:  "Encrypts the vault key for a recipient. Use --pubkey or --pubkey-file to specify the recipient's public key. Outputs to stdout by default.",
+		Long:  "Encrypts the vault key for a recipient. Use --pubkey or --pubkey-file to specify the recipient's public key, or --all to encrypt it for every registered member into the -o directory. Outputs to stdout by default.",
 		Args:  cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return commands.RunVaultKeyEncrypt(vaultKeyEncryptPubkey, vaultKeyEncryptPubkeyFile, vaultKeyEncryptOutput, vaultKeyEncryptSave, cfg)
+			if vaultKeyEncryptAll {
+				return commands.RunVaultKeyEncryptAll(vaultKeyEncryptOutput, vaultKeyEncryptKeyType, cfg)
+			}
+			return commands.RunVaultKeyEncrypt(vaultKeyEncryptPubkey, vaultKeyEncryptPubkeyFile, vaultKeyEncryptAndPubkeys, vaultKeyEncryptOutput, vaultKeyEncryptSave, vaultKeyEncryptForce, vaultKeyEncryptRecoveryPassphrase, vaultKeyEncryptKeyType, cfg)
 		},
 	}
 	vaultKeyEncryptCmd.Flags().StringVar(&vaultKeyEncryptPubkey, "pubkey", "", "Public key string")
 	vaultKeyEncryptCmd.Flags().StringVar(&vaultKeyEncryptPubkeyFile, "pubkey-file", "", "Path to public key file")
-	vaultKeyEncryptCmd.Flags().StringVarP(&vaultKeyEncryptOutput, "output", "o", "", "Output file (default: stdout)")
+	vaultKeyEncryptCmd.Flags().StringVarP(&vaultKeyEncryptOutput, "output", "o", "", "Output file, or output directory with --all (default: stdout)")
 	vaultKeyEncryptCmd.Flags().BoolVar(&vaultKeyEncryptSave, "save", false, "Save to configured vault key location instead of stdout")
-	vaultKeyEncryptCmd.MarkFlagsMutuallyExclusive("pubkey", "pubkey-file")
-	vaultKeyEncryptCmd.MarkFlagsOneRequired("pubkey", "pubkey-file")
+	vaultKeyEncryptCmd.Flags().BoolVar(&vaultKeyEncryptAll, "all", false, "Encrypt for every member of the recipients registry")
+	vaultKeyEncryptCmd.Flags().BoolVar(&vaultKeyEncryptForce, "force", false, "With --save, replace the vault key even if it cannot be decrypted with your identity or belongs to another vault")
+	vaultKeyEncryptCmd.Flags().BoolVar(&vaultKeyEncryptRecoveryPassphrase, "recovery-passphrase", false, "Also encrypt the vault key to a recovery passphrase, in case the recipient's identity is lost")
+	vaultKeyEncryptCmd.MarkFlagsMutuallyExclusive("pubkey", "pubkey-file", "all")
+	vaultKeyEncryptCmd.Flags().StringArrayVar(&vaultKeyEncryptAndPubkeys, "and-pubkey", nil, "Also require this public key's identity to decrypt, as an inner layer (repeatable, in unwrap order)")
+	vaultKeyEncryptCmd.Flags().StringVar(&vaultKeyEncryptKeyType, "key-type", "", "Type of the vault key if a new one is created: x25519 or hybrid (post-quantum) (default x25519)")
+	vaultKeyEncryptCmd.MarkFlagsMutuallyExclusive("recovery-passphrase", "all")
+	vaultKeyEncryptCmd.MarkFlagsMutuallyExclusive("and-pubkey", "all")
+	vaultKeyEncryptCmd.MarkFlagsMutuallyExclusive("save", "all")
+	vaultKeyEncryptCmd.MarkFlagsOneRequired("pubkey", "pubkey-file", "all")
 	vaultKeyCmd.AddCommand(vaultKeyEncryptCmd)
 
 	// Add vault-key set subcommand
+	var vaultKeySetForce bool
 	vaultKeySetCmd := &cobra.Command{
 		Use:   "set [encrypted-key-file]",
 		Short: "Set the vault key from an encrypted file",
-		Long:  "Copies the encrypted vault key file to the configured vault key location.",
+		Long:  "Copies the encrypted vault key file to the configured vault key location. Refuses to replace the current vault key with one that cannot be decrypted with your identity or that belongs to another vault, unless --force is given. The previous file is kept as a timestamped backup.",
 		Args:  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return commands.RunVaultKeySet(args[0], cfg)
+			return commands.RunVaultKeySet(args[0], vaultKeySetForce, cfg)
 		},
 	}
+	vaultKeySetCmd.Flags().BoolVar(&vaultKeySetForce, "force", false, "Replace the vault key even if it cannot be decrypted with your identity or belongs to another vault")
 	vaultKeyCmd.AddCommand(vaultKeySetCmd)
 
 	// Add vault-key pubkey subcommand
@@ -132,6 +263,364 @@ centralized vault key system. Built on top of the age encryption tool.`,
 	vaultKeyPubkeyCmd.Flags().StringVarP(&vaultKeyPubkeyOutput, "output", "o", "", "Output file (default: stdout)")
 	vaultKeyCmd.AddCommand(vaultKeyPubkeyCmd)
 
+	// Add vault-key backup subcommand
+	var vaultKeyBackupOutput string
+	vaultKeyBackupCmd := &cobra.Command{
+		Use:   "backup",
+		Short: "Back up the vault key with a passphrase",
+		Long:  "Decrypts the vault key and re-encrypts it with a passphrase, without the plaintext vault key ever touching disk or a pipe. Restore it with vault-key restore.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return commands.RunVaultKeyBackup(vaultKeyBackupOutput, cfg)
+		},
+	}
+	vaultKeyBackupCmd.Flags().StringVarP(&vaultKeyBackupOutput, "output", "o", "", "Output file for the passphrase-encrypted vault key")
+	vaultKeyBackupCmd.MarkFlagRequired("output")
+	vaultKeyCmd.AddCommand(vaultKeyBackupCmd)
+
+	// Add vault-key restore subcommand
+	var vaultKeyRestoreForce bool
+	vaultKeyRestoreCmd := &cobra.Command{
+		Use:   "restore [backup-file]",
+		Short: "Restore the vault key from a passphrase backup",
+		Long:  "Decrypts a backup made by vault-key backup and re-encrypts the vault key for the configured identity, installing it like vault-key set.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return commands.RunVaultKeyRestore(args[0], vaultKeyRestoreForce, cfg)
+		},
+	}
+	vaultKeyRestoreCmd.Flags().BoolVar(&vaultKeyRestoreForce, "force", false, "Replace an existing vault key that belongs to another vault")
+	vaultKeyCmd.AddCommand(vaultKeyRestoreCmd)
+
+	// Add vault-key paper-backup subcommand
+	var vaultKeyPaperBackupOutputDir string
+	var vaultKeyPaperBackupQR string
+	vaultKeyPaperBackupCmd := &cobra.Command{
+		Use:   "paper-backup",
+		Short: "Create a printable backup of the vault key",
+		Long:  "Encrypts the vault key with a passphrase and writes it as a printable document of numbered, checksummed chunks, plus one QR code per chunk. Restore it with vault-key paper-restore.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return commands.RunVaultKeyPaperBackup(vaultKeyPaperBackupOutputDir, vaultKeyPaperBackupQR, cfg)
+		},
+	}
+	vaultKeyPaperBackupCmd.Flags().StringVarP(&vaultKeyPaperBackupOutputDir, "output-dir", "o", "", "Directory to write the backup document and QR codes to")
+	vaultKeyPaperBackupCmd.Flags().StringVar(&vaultKeyPaperBackupQR, "qr", "png", "QR code format: png, svg or none")
+	vaultKeyPaperBackupCmd.MarkFlagRequired("output-dir")
+	vaultKeyCmd.AddCommand(vaultKeyPaperBackupCmd)
+
+	// Add vault-key paper-restore subcommand
+	var vaultKeyPaperRestoreForce bool
+	vaultKeyPaperRestoreCmd := &cobra.Command{
+		Use:   "paper-restore [files...]",
+		Short: "Restore the vault key from a paper backup",
+		Long:  "Reads the chunks of a paper backup, typed or scanned in any order, from the given files (or stdin), then decrypts the vault key and installs it for the configured identity like vault-key set.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return commands.RunVaultKeyPaperRestore(args, vaultKeyPaperRestoreForce, cfg)
+		},
+	}
+	vaultKeyPaperRestoreCmd.Flags().BoolVar(&vaultKeyPaperRestoreForce, "force", false, "Replace an existing vault key that belongs to another vault")
+	vaultKeyCmd.AddCommand(vaultKeyPaperRestoreCmd)
+
+	// Add vault-key split subcommand
+	var vaultKeySplitShares int
+	var vaultKeySplitThreshold int
+	var vaultKeySplitCustodians []string
+	var vaultKeySplitOutputDir string
+	vaultKeySplitCmd := &cobra.Command{
+		Use:   "split",
+		Short: "Split the vault key into Shamir shares",
+		Long:  "Splits the vault key into --shares Shamir shares, any --threshold of which restore it with vault-key combine. With --custodian (one per share), each share is encrypted to the custodian's public key.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return commands.RunVaultKeySplit(vaultKeySplitShares, vaultKeySplitThreshold, vaultKeySplitCustodians, vaultKeySplitOutputDir, cfg)
+		},
+	}
+	vaultKeySplitCmd.Flags().IntVar(&vaultKeySplitShares, "shares", 0, "Number of shares to create")
+	vaultKeySplitCmd.Flags().IntVar(&vaultKeySplitThreshold, "threshold", 0, "Number of shares required to restore the vault key")
+	vaultKeySplitCmd.Flags().StringArrayVar(&vaultKeySplitCustodians, "custodian", nil, "Encrypt a share for this registered member, or NAME=PUBKEY (repeat once per share)")
+	vaultKeySplitCmd.Flags().StringVarP(&vaultKeySplitOutputDir, "output-dir", "o", "", "Directory to write the shares to")
+	vaultKeySplitCmd.MarkFlagRequired("shares")
+	vaultKeySplitCmd.MarkFlagRequired("threshold")
+	vaultKeySplitCmd.MarkFlagRequired("output-dir")
+	vaultKeyCmd.AddCommand(vaultKeySplitCmd)
+
+	// Add vault-key combine subcommand
+	var vaultKeyCombineIdentities []string
+	var vaultKeyCombineForce bool
+	vaultKeyCombineCmd := &cobra.Command{
+		Use:   "combine [share-files...]",
+		Short: "Restore the vault key from Shamir shares",
+		Long:  "Reconstructs the vault key from share files made by vault-key split and installs it for the configured identity like vault-key set. Encrypted shares are decrypted with the configured identity or the --identity files.",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return commands.RunVaultKeyCombine(args, vaultKeyCombineIdentities, vaultKeyCombineForce, cfg)
+		},
+	}
+	vaultKeyCombineCmd.Flags().StringArrayVarP(&vaultKeyCombineIdentities, "identity", "i", nil, "Additional identity file to decrypt custodian shares with (can be repeated)")
+	vaultKeyCombineCmd.Flags().BoolVar(&vaultKeyCombineForce, "force", false, "Replace an existing vault key that belongs to another vault")
+	vaultKeyCmd.AddCommand(vaultKeyCombineCmd)
+
+	// Add vault-key import subcommand
+	var vaultKeyImportDeleteSource bool
+	var vaultKeyImportForce bool
+	vaultKeyImportCmd := &cobra.Command{
+		Use:   "import [age identity file]",
+		Short: "Adopt an existing age identity as the vault key",
+		Long:  "Imports the age X25519 identity of an existing identity file (e.g. the key used with plain age or sops) as the vault key, encrypted for your identity. Additional identities in the file become retired vault keys. Refuses to replace a vault key of another vault unless --force is given. The plaintext identity file is left in place with a warning, unless --delete-source is given.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return commands.RunVaultKeyImport(args[0], vaultKeyImportDeleteSource, vaultKeyImportForce, cfg)
+		},
+	}
+	vaultKeyImportCmd.Flags().BoolVar(&vaultKeyImportDeleteSource, "delete-source", false, "Overwrite and delete the plaintext identity file after importing it")
+	vaultKeyImportCmd.Flags().BoolVar(&vaultKeyImportForce, "force", false, "Replace the vault key even if it belongs to another vault")
+	vaultKeyCmd.AddCommand(vaultKeyImportCmd)
+
+	// Add vault-key audit subcommand
+	vaultKeyAuditCmd := &cobra.Command{
+		Use:   "audit [paths...]",
+		Short: "Check that encrypted vault keys include the escrow recipients",
+		Long:  "Checks that every encrypted vault key file is also encrypted to each escrow recipient configured in age_vault.yml. Checks the configured vault key file unless files or directories (scanned recursively) are given. Fails if any file is missing an escrow recipient.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return commands.RunVaultKeyAudit(args, cfg)
+		},
+	}
+	vaultKeyCmd.AddCommand(vaultKeyAuditCmd)
+
+	// Add vault-key fingerprint subcommand
+	var vaultKeyFingerprintFormat string
+	vaultKeyFingerprintCmd := &cobra.Command{
+		Use:   "fingerprint",
+		Short: "Output a short fingerprint of the vault key",
+		Long:  "Outputs a short, human-comparable fingerprint of the vault public key, in hex and word form. Machines sharing the same vault key show the same fingerprint.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return commands.RunVaultKeyFingerprint(vaultKeyFingerprintFormat, cfg)
+		},
+	}
+	vaultKeyFingerprintCmd.Flags().StringVar(&vaultKeyFingerprintFormat, "format", "", "Only output one form: hex or words")
+	vaultKeyCmd.AddCommand(vaultKeyFingerprintCmd)
+
+	// Add vault-key verify subcommand
+	var vaultKeyVerifyFingerprint string
+	vaultKeyVerifyCmd := &cobra.Command{
+		Use:   "verify",
+		Short: "Check the vault key against a fingerprint",
+		Long:  "Decrypts the local vault key and checks that its fingerprint matches the given one (hex or word form). Exits with an error on mismatch.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return commands.RunVaultKeyVerify(vaultKeyVerifyFingerprint, cfg)
+		},
+	}
+	vaultKeyVerifyCmd.Flags().StringVar(&vaultKeyVerifyFingerprint, "fingerprint", "", "Expected fingerprint, as printed by vault-key fingerprint")
+	vaultKeyVerifyCmd.MarkFlagRequired("fingerprint")
+	vaultKeyCmd.AddCommand(vaultKeyVerifyCmd)
+
+	// Add vault-key rotate subcommand
+	var vaultKeyRotateRecipients string
+	var vaultKeyRotateOutputDir string
+	var vaultKeyRotateDropRetired bool
+	var vaultKeyRotateKeyType string
+	vaultKeyRotateCmd := &cobra.Command{
+		Use:   "rotate",
+		Short: "Generate a new vault key and encrypt it for every recipient",
+		Long:  "Generates a new vault key, encrypts it for every public key in --recipients (a public key file or a directory of public key files), or for every registered member if --recipients is not given, and writes one encrypted vault key file per recipient to --output-dir. Previous vault keys are kept as retired keys so existing data stays decryptable, unless --drop-retired is given. The configured vault key file is replaced only after everything succeeded, and the previous one is kept as a timestamped backup.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return commands.RunVaultKeyRotate(vaultKeyRotateRecipients, vaultKeyRotateOutputDir, vaultKeyRotateDropRetired, vaultKeyRotateKeyType, cfg)
+		},
+	}
+	vaultKeyRotateCmd.Flags().StringVar(&vaultKeyRotateRecipients, "recipients", "", "Public key file or directory of public key files (default: registered members)")
+	vaultKeyRotateCmd.Flags().StringVarP(&vaultKeyRotateOutputDir, "output-dir", "o", "", "Directory to write the encrypted vault key files to")
+	vaultKeyRotateCmd.Flags().BoolVar(&vaultKeyRotateDropRetired, "drop-retired", false, "Do not keep previous vault keys in the new keyring")
+	vaultKeyRotateCmd.Flags().StringVar(&vaultKeyRotateKeyType, "key-type", "", "Type of the new vault key: x25519 or hybrid (post-quantum) (default: type of the current vault key)")
+	vaultKeyRotateCmd.MarkFlagRequired("output-dir")
+	vaultKeyCmd.AddCommand(vaultKeyRotateCmd)
+
+	// Add subvault command group
+	subvaultCmd := &cobra.Command{
+		Use:   "subvault",
+		Short: "Manage sub-vaults",
+		Long:  "Commands for sub-vaults, which scope secrets to a subset of the members of the vault",
+	}
+	rootCmd.AddCommand(subvaultCmd)
+
+	// Add subvault create subcommand
+	var subvaultCreateMembers []string
+	var subvaultCreateParent bool
+	var subvaultCreateKeyType string
+	subvaultCreateCmd := &cobra.Command{
+		Use:   "create [name]",
+		Short: "Create a sub-vault",
+		Long:  "Generates the key of a new sub-vault and encrypts it to the given registered members and, with --parent, to the key of its parent (the vault key, or the parent sub-vault for nested names like prod/db). Encrypt secrets for it with 'encrypt --subvault [name]'.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return commands.RunSubvaultCreate(args[0], subvaultCreateMembers, subvaultCreateParent, subvaultCreateKeyType, cfg)
+		},
+	}
+	subvaultCreateCmd.Flags().StringArrayVar(&subvaultCreateMembers, "member", nil, "Registered member with access to the sub-vault (can be repeated)")
+	subvaultCreateCmd.Flags().BoolVar(&subvaultCreateParent, "parent", false, "Also give access to every member of the parent vault or sub-vault")
+	subvaultCreateCmd.Flags().StringVar(&subvaultCreateKeyType, "key-type", "", "Type of the sub-vault key: x25519 or hybrid (post-quantum) (default: type of the parent key)")
+	subvaultCmd.AddCommand(subvaultCreateCmd)
+
+	// Add vaults command group
+	vaultsCmd := &cobra.Command{
+		Use:   "vaults",
+		Short: "Manage named vaults",
+		Long:  "Commands for the named vaults declared in the vaults section of age_vault.yml",
+	}
+	rootCmd.AddCommand(vaultsCmd)
+
+	// Add vaults list subcommand
+	vaultsListCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List vaults",
+		Long:  "Lists the vaults of age_vault.yml, the top-level one and the named ones, marks the selected one, and shows which can currently be opened with their identity.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return commands.RunVaultsList(cfg)
+		},
+	}
+	vaultsCmd.AddCommand(vaultsListCmd)
+
+	// Add members command group
+	membersCmd := &cobra.Command{
+		Use:   "members",
+		Short: "Manage the recipients registry",
+		Long:  "Commands for managing the users/machines with access to the vault, recorded in age_vault_recipients.yml",
+	}
+	rootCmd.AddCommand(membersCmd)
+
+	// Add members add subcommand
+	var membersAddPubkey string
+	var membersAddPubkeyFile string
+	membersAddCmd := &cobra.Command{
+		Use:   "add [name]",
+		Short: "Register a new member",
+		Long:  "Adds a user/machine and its public key to the recipients registry. Use --pubkey or --pubkey-file to specify the public key.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return commands.RunMembersAdd(args[0], membersAddPubkey, membersAddPubkeyFile, cfg)
+		},
+	}
+	membersAddCmd.Flags().StringVar(&membersAddPubkey, "pubkey", "", "Public key string")
+	membersAddCmd.Flags().StringVar(&membersAddPubkeyFile, "pubkey-file", "", "Path to public key file")
+	membersAddCmd.MarkFlagsMutuallyExclusive("pubkey", "pubkey-file")
+	membersAddCmd.MarkFlagsOneRequired("pubkey", "pubkey-file")
+	membersCmd.AddCommand(membersAddCmd)
+
+	// Add members list subcommand
+	membersListCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List registered members",
+		Long:  "Lists every user/machine in the recipients registry along with its public key.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return commands.RunMembersList(cfg)
+		},
+	}
+	membersCmd.AddCommand(membersListCmd)
+
+	// Add members remove subcommand
+	membersRemoveCmd := &cobra.Command{
+		Use:   "remove [name]",
+		Short: "Remove a member",
+		Long:  "Removes a user/machine from the recipients registry. Rotate the vault key afterwards to revoke its access.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return commands.RunMembersRemove(args[0], cfg)
+		},
+	}
+	membersCmd.AddCommand(membersRemoveCmd)
+
+	// Add enroll command group
+	enrollCmd := &cobra.Command{
+		Use:   "enroll",
+		Short: "Enroll a new user/machine",
+		Long:  "Commands for granting a new user/machine access to the vault key through a request/approve workflow",
+	}
+	rootCmd.AddCommand(enrollCmd)
+
+	// Add enroll request subcommand
+	var enrollRequestOutput string
+	enrollRequestCmd := &cobra.Command{
+		Use:   "request",
+		Short: "Create an enrollment request",
+		Long:  "Writes an enrollment request with this machine's public key and hostname, and prints a verification code to compare with the approver.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return commands.RunEnrollRequest(enrollRequestOutput, cfg)
+		},
+	}
+	enrollRequestCmd.Flags().StringVarP(&enrollRequestOutput, "output", "o", "", "Output file (default: <hostname>.enroll-request.yml)")
+	enrollCmd.AddCommand(enrollRequestCmd)
+
+	// Add enroll approve subcommand
+	var enrollApproveOutput string
+	var enrollApproveMember string
+	var enrollApproveYes bool
+	enrollApproveCmd := &cobra.Command{
+		Use:   "approve [request-file]",
+		Short: "Approve an enrollment request",
+		Long:  "Shows the verification code of an enrollment request and, once confirmed, writes a response containing the vault key encrypted for the requesting machine.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return commands.RunEnrollApprove(args[0], enrollApproveOutput, enrollApproveMember, enrollApproveYes, cfg)
+		},
+	}
+	enrollApproveCmd.Flags().StringVarP(&enrollApproveOutput, "output", "o", "", "Output file (default: <member or hostname>.enroll-response.yml)")
+	enrollApproveCmd.Flags().StringVar(&enrollApproveMember, "member", "", "Also register the machine in the recipients registry under this name")
+	enrollApproveCmd.Flags().BoolVarP(&enrollApproveYes, "yes", "y", false, "Approve without asking for confirmation")
+	enrollCmd.AddCommand(enrollApproveCmd)
+
+	// Add enroll complete subcommand
+	var enrollCompleteForce bool
+	enrollCompleteCmd := &cobra.Command{
+		Use:   "complete [response-file]",
+		Short: "Install the vault key from an enrollment response",
+		Long:  "Verifies that the enrollment response was made for this machine and that its vault key can be decrypted, then installs it as the vault key.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return commands.RunEnrollComplete(args[0], enrollCompleteForce, cfg)
+		},
+	}
+	enrollCompleteCmd.Flags().BoolVar(&enrollCompleteForce, "force", false, "Replace an existing vault key that belongs to another vault")
+	enrollCmd.AddCommand(enrollCompleteCmd)
+
+	// Add enroll serve subcommand
+	var enrollServeListen string
+	var enrollServeMember string
+	enrollServeCmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Send the vault key to a new machine over the network",
+		Long:  "Listens for a single new machine running 'age-vault enroll join' with the printed code, and sends it the vault key encrypted for its public key. The code authenticates the exchange, so the vault key never travels unauthenticated.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return commands.RunEnrollServe(enrollServeListen, enrollServeMember, cfg)
+		},
+	}
+	enrollServeCmd.Flags().StringVar(&enrollServeListen, "listen", ":0", "Address to listen on (default: a random port on all interfaces)")
+	enrollServeCmd.Flags().StringVar(&enrollServeMember, "member", "", "Also register the machine in the recipients registry under this name")
+	enrollCmd.AddCommand(enrollServeCmd)
+
+	// Add enroll join subcommand
+	var enrollJoinAddr string
+	var enrollJoinForce bool
+	enrollJoinCmd := &cobra.Command{
+		Use:   "join [code]",
+		Short: "Receive the vault key from a machine running enroll serve",
+		Long:  "Connects to a machine running 'age-vault enroll serve', authenticates with the code it printed, and installs the received vault key.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return commands.RunEnrollJoin(enrollJoinAddr, args[0], enrollJoinForce, cfg)
+		},
+	}
+	enrollJoinCmd.Flags().StringVar(&enrollJoinAddr, "addr", "", "Address printed by enroll serve (host:port)")
+	enrollJoinCmd.Flags().BoolVar(&enrollJoinForce, "force", false, "Replace an existing vault key that belongs to another vault")
+	enrollJoinCmd.MarkFlagRequired("addr")
+	enrollCmd.AddCommand(enrollJoinCmd)
+
 	// Add identity command group
 	identityCmd := &cobra.Command{
 		Use:   "identity",
@@ -140,6 +629,22 @@ centralized vault key system. Built on top of the age encryption tool.`,
 	}
 	rootCmd.AddCommand(identityCmd)
 
+	// Add identity generate subcommand
+	var identityGeneratePlugin string
+	var identityGenerateForce bool
+	identityGenerateCmd := &cobra.Command{
+		Use:   "generate",
+		Short: "Generate a new identity",
+		Long:  "Generates a new identity, natively or with an installed age plugin (age-plugin-tpm, age-plugin-yubikey or age-plugin-se), and saves it to AGE_VAULT_IDENTITY_FILE with its public key recorded in a comment. Prints the public key.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return commands.RunIdentityGenerate(identityGeneratePlugin, identityGenerateForce, cfg)
+		},
+	}
+	identityGenerateCmd.Flags().StringVar(&identityGeneratePlugin, "plugin", "native", "How to create the identity: native, tpm, yubikey or se")
+	identityGenerateCmd.Flags().BoolVar(&identityGenerateForce, "force", false, "Replace an existing identity (a backup is kept)")
+	identityCmd.AddCommand(identityGenerateCmd)
+
 	// Add identity set subcommand
 	identitySetCmd := &cobra.Command{
 		Use:   "set [identity-file]",
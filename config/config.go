@@ -6,29 +6,83 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
 
 // Config holds all configuration for age-vault.
 type Config struct {
-	VaultKeyFile  string // Path to encrypted vault key
-	IdentityFile  string // Path to user's private key (identity)
-	SSHKeysDir    string // Directory containing encrypted SSH keys
-	configFileDir string // Directory containing the loaded config file (private)
+	VaultName        string   // Name of the selected vault from the vaults section ("" if none)
+	VaultNames       []string // Names of every vault declared in age_vault.yml, sorted
+	VaultKeyFile     string   // Path to encrypted vault key
+	IdentityFile     string   // Path to user's private key (identity)
+	SSHKeysDir       string   // Directory containing encrypted SSH keys
+	RecipientsFile   string   // Path to the recipients registry (age_vault_recipients.yml)
+	SubvaultsDir     string   // Directory containing encrypted sub-vault keys
+	EscrowRecipients []string // Public keys every encrypted vault key is also encrypted to
+	ConfigDir        string   // Directory containing the loaded config file ("" if none was found)
 }
 
 // yamlConfig represents the structure of age_vault.yml file.
 type yamlConfig struct {
-	VaultKeyFile string `yaml:"vault_key_file"`
-	IdentityFile string `yaml:"identity_file"`
-	SSHKeysDir   string `yaml:"ssh_keys_dir"`
+	VaultKeyFile     string   `yaml:"vault_key_file"`
+	IdentityFile     string   `yaml:"identity_file"`
+	SSHKeysDir       string   `yaml:"ssh_keys_dir"`
+	RecipientsFile   string   `yaml:"recipients_file"`
+	SubvaultsDir     string   `yaml:"subvaults_dir"`
+	EscrowRecipients []string `yaml:"escrow_recipients"`
+	DefaultVault     string   `yaml:"default_vault"`
+
+	// Named vaults, each with its own vault key
+	Vaults map[string]yamlVaultConfig `yaml:"vaults"`
 }
 
+// yamlVaultConfig represents a named vault in the vaults section of age_vault.yml.
+// identity_file and escrow_recipients default to the top-level settings.
+type yamlVaultConfig struct {
+	VaultKeyFile     string   `yaml:"vault_key_file"`
+	IdentityFile     string   `yaml:"identity_file"`
+	SSHKeysDir       string   `yaml:"ssh_keys_dir"`
+	RecipientsFile   string   `yaml:"recipients_file"`
+	SubvaultsDir     string   `yaml:"subvaults_dir"`
+	EscrowRecipients []string `yaml:"escrow_recipients"`
+}
+
+// RecipientsFileName is the name of the recipients registry file.
+// It is looked up next to age_vault.yml.
+const RecipientsFileName = "age_vault_recipients.yml"
+
+// SubvaultsDirName is the name of the directory containing sub-vault keys.
+// It is looked up next to age_vault.yml.
+const SubvaultsDirName = "age_vault_subvaults"
+
 // NewConfig creates a new Config by reading environment variables,
 // searching for age_vault.yml config file, and applying defaults.
 // Environment variables override YAML config, which overrides defaults.
+// The vault named by AGE_VAULT_NAME (or default_vault) is selected, see NewConfigForVault.
 func NewConfig() (*Config, error) {
+	return NewConfigForVault(os.Getenv("AGE_VAULT_NAME"))
+}
+
+// NewConfigForVault creates a new Config like NewConfig, for the named vault of the vaults
+// section of age_vault.yml. The settings of the named vault replace the top-level ones; its
+// vault key and recipients registry default to per-vault locations, so vaults never share them.
+// An empty name selects default_vault, or the top-level settings if there is none.
+func NewConfigForVault(name string) (*Config, error) {
+	return newConfig(name, true)
+}
+
+// NewTopLevelConfig creates a new Config like NewConfig for the top-level settings of
+// age_vault.yml, even if a named vault is selected with AGE_VAULT_NAME or default_vault.
+func NewTopLevelConfig() (*Config, error) {
+	return newConfig("", false)
+}
+
+// newConfig creates a Config for the named vault, or for default_vault if name is empty
+// and selectDefault is set, or else for the top-level settings.
+func newConfig(name string, selectDefault bool) (*Config, error) {
 	cfg := &Config{}
 
 	// First, load from YAML config file if present
@@ -38,7 +92,7 @@ func NewConfig() (*Config, error) {
 	}
 
 	// Store the config file directory
-	cfg.configFileDir = configFileDir
+	cfg.ConfigDir = configFileDir
 
 	// Get home directory for defaults
 	homeDir, err := os.UserHomeDir()
@@ -48,16 +102,67 @@ func NewConfig() (*Config, error) {
 
 	defaultConfigDir := filepath.Join(homeDir, ".config", ".age-vault")
 
+	for vaultName := range yamlCfg.Vaults {
+		cfg.VaultNames = append(cfg.VaultNames, vaultName)
+	}
+	sort.Strings(cfg.VaultNames)
+
 	// Resolve YAML paths relative to config file directory
 	resolvedVaultKeyFile := resolveConfigPath(yamlCfg.VaultKeyFile, configFileDir)
 	resolvedIdentityFile := resolveConfigPath(yamlCfg.IdentityFile, configFileDir)
 	resolvedSSHKeysDir := resolveConfigPath(yamlCfg.SSHKeysDir, configFileDir)
+	resolvedRecipientsFile := resolveConfigPath(yamlCfg.RecipientsFile, configFileDir)
+	resolvedSubvaultsDir := resolveConfigPath(yamlCfg.SubvaultsDir, configFileDir)
+	escrowRecipients := yamlCfg.EscrowRecipients
+	defaultVaultKeyFile := filepath.Join(defaultConfigDir, "vault_key.age")
+
+	// The recipients registry and sub-vault keys live next to the config file, if there is one
+	discoveredRecipientsFile := ""
+	discoveredSubvaultsDir := ""
+	if configFileDir != "" {
+		discoveredRecipientsFile = filepath.Join(configFileDir, RecipientsFileName)
+		discoveredSubvaultsDir = filepath.Join(configFileDir, SubvaultsDirName)
+	}
+	defaultSubvaultsDir := filepath.Join(defaultConfigDir, "subvaults")
+
+	// Select a named vault
+	if name == "" && selectDefault {
+		name = yamlCfg.DefaultVault
+	}
+	if name != "" {
+		vaultCfg, ok := yamlCfg.Vaults[name]
+		if !ok {
+			if len(cfg.VaultNames) == 0 {
+				return nil, fmt.Errorf("unknown vault %q (no vaults declared in age_vault.yml)", name)
+			}
+			return nil, fmt.Errorf("unknown vault %q (declared vaults: %s)", name, strings.Join(cfg.VaultNames, ", "))
+		}
+		cfg.VaultName = name
+
+		// Vault specific settings are never shared with the top-level vault
+		resolvedVaultKeyFile = resolveConfigPath(vaultCfg.VaultKeyFile, configFileDir)
+		resolvedSSHKeysDir = resolveConfigPath(vaultCfg.SSHKeysDir, configFileDir)
+		resolvedRecipientsFile = resolveConfigPath(vaultCfg.RecipientsFile, configFileDir)
+		resolvedSubvaultsDir = resolveConfigPath(vaultCfg.SubvaultsDir, configFileDir)
+		defaultVaultKeyFile = filepath.Join(defaultConfigDir, "vaults", name, "vault_key.age")
+		discoveredRecipientsFile = filepath.Join(configFileDir, "age_vault_recipients."+name+".yml")
+		discoveredSubvaultsDir = filepath.Join(configFileDir, SubvaultsDirName+"."+name)
+		defaultSubvaultsDir = filepath.Join(defaultConfigDir, "vaults", name, "subvaults")
+
+		// The identity and escrow recipients are shared unless overridden
+		if vaultCfg.IdentityFile != "" {
+			resolvedIdentityFile = resolveConfigPath(vaultCfg.IdentityFile, configFileDir)
+		}
+		if vaultCfg.EscrowRecipients != nil {
+			escrowRecipients = vaultCfg.EscrowRecipients
+		}
+	}
 
 	// Set VaultKeyFile
 	cfg.VaultKeyFile = getConfigValue(
 		os.Getenv("AGE_VAULT_KEY_FILE"),
 		resolvedVaultKeyFile,
-		filepath.Join(defaultConfigDir, "vault_key.age"),
+		defaultVaultKeyFile,
 	)
 
 	// Set IdentityFile
@@ -74,9 +179,30 @@ func NewConfig() (*Config, error) {
 		"",
 	)
 
+	// Set RecipientsFile
+	cfg.RecipientsFile = getConfigValue(
+		os.Getenv("AGE_VAULT_RECIPIENTS_FILE"),
+		resolvedRecipientsFile,
+		discoveredRecipientsFile,
+		filepath.Join(defaultConfigDir, RecipientsFileName),
+	)
+
+	// Set SubvaultsDir
+	cfg.SubvaultsDir = getConfigValue(
+		os.Getenv("AGE_VAULT_SUBVAULTS_DIR"),
+		resolvedSubvaultsDir,
+		discoveredSubvaultsDir,
+		defaultSubvaultsDir,
+	)
+
+	// Escrow recipients can only be set in the config file
+	cfg.EscrowRecipients = escrowRecipients
+
 	// Expand home directory in all paths (only for env vars or defaults)
 	cfg.VaultKeyFile = expandHomePath(cfg.VaultKeyFile)
 	cfg.IdentityFile = expandHomePath(cfg.IdentityFile)
+	cfg.RecipientsFile = expandHomePath(cfg.RecipientsFile)
+	cfg.SubvaultsDir = expandHomePath(cfg.SubvaultsDir)
 	if cfg.SSHKeysDir != "" {
 		cfg.SSHKeysDir = expandHomePath(cfg.SSHKeysDir)
 	}
@@ -84,61 +210,65 @@ func NewConfig() (*Config, error) {
 	return cfg, nil
 }
 
-// findAndLoadYAMLConfig searches for age_vault.yml by traversing up the directory tree.
+// findAndLoadYAMLConfig loads the config file found by LocateConfigFile.
 // Returns the config, the directory containing the config file, and any error.
 func findAndLoadYAMLConfig() (yamlConfig, string, error) {
 	cfg := yamlConfig{}
 
-	// Start from current directory
-	currentDir, err := os.Getwd()
+	configPath, _ := LocateConfigFile()
+	if configPath == "" {
+		return cfg, "", nil // No config file found, return empty config
+	}
+
+	data, err := os.ReadFile(configPath)
 	if err != nil {
-		return cfg, "", nil // Not fatal, just use defaults
+		return cfg, "", fmt.Errorf("error reading config file %s: %w", configPath, err)
+	}
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return cfg, "", fmt.Errorf("error parsing config file %s: %w", configPath, err)
 	}
 
-	// Traverse up the directory tree
-	for {
-		configPath := filepath.Join(currentDir, "age_vault.yml")
-		if _, err := os.Stat(configPath); err == nil {
-			// File exists, try to load it
-			data, err := os.ReadFile(configPath)
-			if err != nil {
-				return cfg, "", fmt.Errorf("error reading config file %s: %w", configPath, err)
-			}
+	return cfg, filepath.Dir(configPath), nil
+}
 
-			if err := yaml.Unmarshal(data, &cfg); err != nil {
-				return cfg, "", fmt.Errorf("error parsing config file %s: %w", configPath, err)
+// LocateConfigFile searches for age_vault.yml by traversing up the directory tree from the
+// current directory, then at the default location ~/.config/.age-vault/age_vault.yml.
+// Returns the path of the config file ("" if none was found) and why it was chosen.
+func LocateConfigFile() (string, string) {
+	// Start from current directory
+	currentDir, err := os.Getwd()
+	if err == nil {
+		// Traverse up the directory tree
+		for dir := currentDir; ; {
+			configPath := filepath.Join(dir, "age_vault.yml")
+			if _, err := os.Stat(configPath); err == nil {
+				if dir == currentDir {
+					return configPath, "found in the current directory"
+				}
+				return configPath, fmt.Sprintf("found in a parent of the current directory %s", currentDir)
 			}
 
-			return cfg, currentDir, nil
-		}
-
-		// Move up one directory
-		parentDir := filepath.Dir(currentDir)
-		if parentDir == currentDir {
-			// Reached root directory
-			break
+			// Move up one directory
+			parentDir := filepath.Dir(dir)
+			if parentDir == dir {
+				// Reached root directory
+				break
+			}
+			dir = parentDir
 		}
-		currentDir = parentDir
 	}
 
 	// No config file found in parent directories, try default location
 	homeDir, err := os.UserHomeDir()
-	if err == nil {
-		defaultConfigPath := filepath.Join(homeDir, ".config", ".age-vault", "age_vault.yml")
-		if _, err := os.Stat(defaultConfigPath); err == nil {
-			// File exists at default location
-			data, err := os.ReadFile(defaultConfigPath)
-			if err != nil {
-				return cfg, "", fmt.Errorf("error reading default config file %s: %w", defaultConfigPath, err)
-			}
-			if err := yaml.Unmarshal(data, &cfg); err != nil {
-				return cfg, "", fmt.Errorf("error parsing default config file %s: %w", defaultConfigPath, err)
-			}
-			return cfg, filepath.Join(homeDir, ".config", ".age-vault"), nil
-		}
+	if err != nil {
+		return "", fmt.Sprintf("no age_vault.yml in %s or its parents", currentDir)
+	}
+	defaultConfigPath := filepath.Join(homeDir, ".config", ".age-vault", "age_vault.yml")
+	if _, err := os.Stat(defaultConfigPath); err == nil {
+		return defaultConfigPath, fmt.Sprintf("default location, no age_vault.yml in %s or its parents", currentDir)
 	}
 
-	return cfg, "", nil // No config file found, return empty config
+	return "", fmt.Sprintf("no age_vault.yml in %s, its parents or at %s", currentDir, defaultConfigPath)
 }
 
 // getConfigValue returns the first non-empty value from the arguments.
@@ -204,6 +334,34 @@ func resolveConfigPath(path string, configFileDir string) string {
 	return path
 }
 
+// RelativeConfigPath is the inverse of resolveConfigPath: it returns how to write path in a
+// config file located in configFileDir. Paths inside configFileDir become relative, paths
+// inside the home directory start with ~, others are absolute.
+func RelativeConfigPath(path string, configFileDir string) string {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+
+	isInside := func(rel string) bool {
+		return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+	}
+
+	if absDir, err := filepath.Abs(configFileDir); err == nil {
+		if rel, err := filepath.Rel(absDir, absPath); err == nil && isInside(rel) {
+			return rel
+		}
+	}
+
+	if homeDir, err := os.UserHomeDir(); err == nil {
+		if rel, err := filepath.Rel(homeDir, absPath); err == nil && isInside(rel) {
+			return "~" + string(filepath.Separator) + rel
+		}
+	}
+
+	return absPath
+}
+
 // EnsureParentDir creates the parent directory for the given file path if it doesn't exist.
 // Sets permissions to 0700 for security.
 func EnsureParentDir(path string) error {
@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -151,6 +152,36 @@ func TestResolveConfigPath(t *testing.T) {
 	}
 }
 
+func TestRelativeConfigPath(t *testing.T) {
+	homeDir, _ := os.UserHomeDir()
+	configDir := filepath.Join(homeDir, "project")
+
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{"inside config dir", filepath.Join(configDir, "vault", "key.age"), filepath.Join("vault", "key.age")},
+		{"inside home", filepath.Join(homeDir, ".config", "identity.txt"), "~" + string(filepath.Separator) + filepath.Join(".config", "identity.txt")},
+		{"sibling of config dir", filepath.Join(homeDir, "project-other", "key.age"), "~" + string(filepath.Separator) + filepath.Join("project-other", "key.age")},
+		{"elsewhere", "/elsewhere/key.age", "/elsewhere/key.age"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := RelativeConfigPath(tt.path, configDir)
+			if result != tt.expected {
+				t.Errorf("RelativeConfigPath(%s, %s) = %s, expected %s", tt.path, configDir, result, tt.expected)
+			}
+
+			// Resolving the written path gives back the original one
+			if resolved := resolveConfigPath(result, configDir); resolved != tt.path {
+				t.Errorf("resolveConfigPath(%s, %s) = %s, expected %s", result, configDir, resolved, tt.path)
+			}
+		})
+	}
+}
+
 func TestNewConfig_YAMLWithRelativePaths(t *testing.T) {
 	// Clear environment variables
 	os.Unsetenv("AGE_VAULT_KEY_FILE")
@@ -194,6 +225,10 @@ ssh_keys_dir: ./vault/ssh_keys
 	if cfg.SSHKeysDir != expectedSSHKeys {
 		t.Errorf("Expected SSHKeysDir to be %s, got %s", expectedSSHKeys, cfg.SSHKeysDir)
 	}
+
+	if cfg.ConfigDir != tempDir {
+		t.Errorf("Expected ConfigDir to be %s, got %s", tempDir, cfg.ConfigDir)
+	}
 }
 
 func TestNewConfig_YAMLFromSubdirectory(t *testing.T) {
@@ -245,4 +280,166 @@ ssh_keys_dir: ./vault/ssh_keys
 	if cfg.SSHKeysDir != expectedSSHKeys {
 		t.Errorf("Expected SSHKeysDir to be %s, got %s", expectedSSHKeys, cfg.SSHKeysDir)
 	}
+
+	// The config file is reported as found in a parent directory
+	path, reason := LocateConfigFile()
+	if path != configPath || !strings.Contains(reason, "parent") {
+		t.Errorf("Expected %s found in a parent directory, got %s (%s)", configPath, path, reason)
+	}
+}
+
+func TestNewConfig_RecipientsFileNextToConfig(t *testing.T) {
+	// Clear environment variables
+	os.Unsetenv("AGE_VAULT_KEY_FILE")
+	os.Unsetenv("AGE_VAULT_IDENTITY_FILE")
+	os.Unsetenv("AGE_VAULT_SSH_KEYS_DIR")
+	os.Unsetenv("AGE_VAULT_RECIPIENTS_FILE")
+
+	// Create a temp directory with a config file
+	tempDir := t.TempDir()
+	configPath := filepath.Join(tempDir, "age_vault.yml")
+	if err := os.WriteFile(configPath, []byte("vault_key_file: ./vault_key.age\n"), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	subDir := filepath.Join(tempDir, "subdir")
+	if err := os.MkdirAll(subDir, 0755); err != nil {
+		t.Fatalf("Failed to create subdirectory: %v", err)
+	}
+
+	oldWd, _ := os.Getwd()
+	os.Chdir(subDir)
+	defer os.Chdir(oldWd)
+
+	cfg, err := NewConfig()
+	if err != nil {
+		t.Fatalf("NewConfig() failed: %v", err)
+	}
+
+	// The registry is discovered next to age_vault.yml
+	expected := filepath.Join(tempDir, RecipientsFileName)
+	if cfg.RecipientsFile != expected {
+		t.Errorf("Expected RecipientsFile to be %s, got %s", expected, cfg.RecipientsFile)
+	}
+
+	// The environment variable takes precedence
+	os.Setenv("AGE_VAULT_RECIPIENTS_FILE", "/custom/recipients.yml")
+	defer os.Unsetenv("AGE_VAULT_RECIPIENTS_FILE")
+
+	cfg, err = NewConfig()
+	if err != nil {
+		t.Fatalf("NewConfig() failed: %v", err)
+	}
+	if cfg.RecipientsFile != "/custom/recipients.yml" {
+		t.Errorf("Expected RecipientsFile to be /custom/recipients.yml, got %s", cfg.RecipientsFile)
+	}
+}
+
+func TestNewConfig_EscrowRecipients(t *testing.T) {
+	tempDir := t.TempDir()
+	configContent := `vault_key_file: ./vault_key.age
+escrow_recipients:
+  - age1escrowone
+  - age1escrowtwo
+`
+	configPath := filepath.Join(tempDir, "age_vault.yml")
+	if err := os.WriteFile(configPath, []byte(configContent), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	oldWd, _ := os.Getwd()
+	os.Chdir(tempDir)
+	defer os.Chdir(oldWd)
+
+	cfg, err := NewConfig()
+	if err != nil {
+		t.Fatalf("NewConfig() failed: %v", err)
+	}
+
+	if len(cfg.EscrowRecipients) != 2 || cfg.EscrowRecipients[0] != "age1escrowone" || cfg.EscrowRecipients[1] != "age1escrowtwo" {
+		t.Errorf("Expected both escrow recipients, got %v", cfg.EscrowRecipients)
+	}
+}
+
+func TestNewConfig_NamedVaults(t *testing.T) {
+	os.Unsetenv("AGE_VAULT_KEY_FILE")
+	os.Unsetenv("AGE_VAULT_IDENTITY_FILE")
+	os.Unsetenv("AGE_VAULT_SSH_KEYS_DIR")
+	os.Unsetenv("AGE_VAULT_RECIPIENTS_FILE")
+	os.Unsetenv("AGE_VAULT_NAME")
+
+	tempDir := t.TempDir()
+	configContent := `identity_file: ./identity.txt
+default_vault: personal
+vaults:
+  personal:
+    vault_key_file: ./personal/vault_key.age
+  team:
+    vault_key_file: ./team/vault_key.age
+    ssh_keys_dir: ./team/ssh_keys
+    identity_file: ./team_identity.txt
+`
+	configPath := filepath.Join(tempDir, "age_vault.yml")
+	if err := os.WriteFile(configPath, []byte(configContent), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	oldWd, _ := os.Getwd()
+	os.Chdir(tempDir)
+	defer os.Chdir(oldWd)
+
+	// default_vault is selected, sharing the top-level identity
+	cfg, err := NewConfig()
+	if err != nil {
+		t.Fatalf("NewConfig() failed: %v", err)
+	}
+	if cfg.VaultName != "personal" {
+		t.Errorf("Expected personal vault to be selected, got %q", cfg.VaultName)
+	}
+	if len(cfg.VaultNames) != 2 || cfg.VaultNames[0] != "personal" || cfg.VaultNames[1] != "team" {
+		t.Errorf("Expected vault names [personal team], got %v", cfg.VaultNames)
+	}
+	if cfg.VaultKeyFile != filepath.Join(tempDir, "personal", "vault_key.age") {
+		t.Errorf("Unexpected vault key file: %s", cfg.VaultKeyFile)
+	}
+	if cfg.IdentityFile != filepath.Join(tempDir, "identity.txt") {
+		t.Errorf("Expected shared identity file, got %s", cfg.IdentityFile)
+	}
+	if cfg.RecipientsFile != filepath.Join(tempDir, "age_vault_recipients.personal.yml") {
+		t.Errorf("Expected per-vault recipients file, got %s", cfg.RecipientsFile)
+	}
+
+	// AGE_VAULT_NAME selects another vault with its own settings
+	os.Setenv("AGE_VAULT_NAME", "team")
+	defer os.Unsetenv("AGE_VAULT_NAME")
+	cfg, err = NewConfig()
+	if err != nil {
+		t.Fatalf("NewConfig() failed: %v", err)
+	}
+	if cfg.VaultName != "team" {
+		t.Errorf("Expected team vault to be selected, got %q", cfg.VaultName)
+	}
+	if cfg.SSHKeysDir != filepath.Join(tempDir, "team", "ssh_keys") {
+		t.Errorf("Unexpected SSH keys dir: %s", cfg.SSHKeysDir)
+	}
+	if cfg.IdentityFile != filepath.Join(tempDir, "team_identity.txt") {
+		t.Errorf("Expected overridden identity file, got %s", cfg.IdentityFile)
+	}
+
+	// The top-level settings stay reachable whichever vault is selected
+	cfg, err = NewTopLevelConfig()
+	if err != nil {
+		t.Fatalf("NewTopLevelConfig() failed: %v", err)
+	}
+	if cfg.VaultName != "" {
+		t.Errorf("Expected no vault to be selected, got %q", cfg.VaultName)
+	}
+	if cfg.RecipientsFile != filepath.Join(tempDir, RecipientsFileName) {
+		t.Errorf("Expected top-level recipients file, got %s", cfg.RecipientsFile)
+	}
+
+	// Unknown vaults are rejected
+	if _, err := NewConfigForVault("missing"); err == nil {
+		t.Error("Expected error for unknown vault")
+	}
 }
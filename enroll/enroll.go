@@ -0,0 +1,162 @@
+// Package enroll provides the request/approve workflow used to add a new machine to the vault.
+// The new machine writes a request file with its public key, an existing member approves it by
+// encrypting the vault key for that public key into a response file, and the new machine
+// installs the vault key from the response.
+package enroll
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base32"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"filippo.io/age/armor"
+	"gopkg.in/yaml.v3"
+)
+
+// formatVersion is the version of the request and response file formats.
+// Version 2 lengthened the verification code.
+const formatVersion = 2
+
+// Request is written by a new machine that wants access to the vault.
+type Request struct {
+	Version   int       `yaml:"version"`
+	Hostname  string    `yaml:"hostname"`
+	Recipient string    `yaml:"recipient"`
+	Code      string    `yaml:"code"`
+	Created   time.Time `yaml:"created"`
+}
+
+// Response is written by an existing member when approving a request.
+// It contains the vault key encrypted for the requesting machine.
+type Response struct {
+	Version   int    `yaml:"version"`
+	Hostname  string `yaml:"hostname"`
+	Recipient string `yaml:"recipient"`
+	VaultKey  string `yaml:"vault_key"` // Armored encrypted vault key
+}
+
+// VerificationCode derives a human-comparable code from a public key and hostname.
+// Both machines compute it independently, so any tampering with the request changes the code.
+// It carries 80 bits of the hash, so an attacker cannot grind keys until one matches the code
+// of the legitimate request (formatted as XXXX-XXXX-XXXX-XXXX).
+func VerificationCode(recipient, hostname string) string {
+	sum := sha256.Sum256([]byte("age-vault-enroll\x00" + recipient + "\x00" + hostname))
+	code := base32.StdEncoding.EncodeToString(sum[:10])
+	return code[:4] + "-" + code[4:8] + "-" + code[8:12] + "-" + code[12:]
+}
+
+// NewRequest creates an enrollment request for the given public key and hostname.
+func NewRequest(recipient, hostname string) *Request {
+	return &Request{
+		Version:   formatVersion,
+		Hostname:  hostname,
+		Recipient: recipient,
+		Code:      VerificationCode(recipient, hostname),
+		Created:   time.Now().UTC().Truncate(time.Second),
+	}
+}
+
+// Verify checks that the request is well formed and that its verification code
+// matches its content.
+func (r *Request) Verify() error {
+	if r.Version != formatVersion {
+		return fmt.Errorf("unsupported enrollment request version %d", r.Version)
+	}
+	if r.Recipient == "" {
+		return fmt.Errorf("enrollment request has no public key")
+	}
+	if r.Code != VerificationCode(r.Recipient, r.Hostname) {
+		return fmt.Errorf("enrollment request verification code does not match its content; the request may have been tampered with")
+	}
+	return nil
+}
+
+// NewResponse creates an enrollment response carrying the encrypted vault key for a request.
+func NewResponse(request *Request, encryptedVaultKey []byte) (*Response, error) {
+	var armored bytes.Buffer
+	w := armor.NewWriter(&armored)
+	if _, err := w.Write(encryptedVaultKey); err != nil {
+		return nil, fmt.Errorf("error armoring vault key: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("error armoring vault key: %w", err)
+	}
+
+	return &Response{
+		Version:   formatVersion,
+		Hostname:  request.Hostname,
+		Recipient: request.Recipient,
+		VaultKey:  armored.String(),
+	}, nil
+}
+
+// EncryptedVaultKey returns the encrypted vault key carried by the response.
+func (r *Response) EncryptedVaultKey() ([]byte, error) {
+	if r.Version != formatVersion {
+		return nil, fmt.Errorf("unsupported enrollment response version %d", r.Version)
+	}
+
+	data, err := io.ReadAll(armor.NewReader(strings.NewReader(r.VaultKey)))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding vault key: %w", err)
+	}
+	return data, nil
+}
+
+// WriteRequest saves a request to path.
+func WriteRequest(path string, request *Request) error {
+	return writeYAML(path, request)
+}
+
+// ReadRequest loads a request from path.
+func ReadRequest(path string) (*Request, error) {
+	request := &Request{}
+	if err := readYAML(path, request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// WriteResponse saves a response to path.
+func WriteResponse(path string, response *Response) error {
+	return writeYAML(path, response)
+}
+
+// ReadResponse loads a response from path.
+func ReadResponse(path string) (*Response, error) {
+	response := &Response{}
+	if err := readYAML(path, response); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// writeYAML encodes v as YAML and writes it to path.
+// Enrollment files only contain public keys or encrypted data.
+func writeYAML(path string, v interface{}) error {
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("error encoding %s: %w", path, err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("error writing %s: %w", path, err)
+	}
+	return nil
+}
+
+// readYAML reads path and decodes it as YAML into v.
+func readYAML(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %w", path, err)
+	}
+	if err := yaml.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("error parsing %s: %w", path, err)
+	}
+	return nil
+}
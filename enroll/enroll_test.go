@@ -0,0 +1,82 @@
+package enroll
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerificationCode(t *testing.T) {
+	code := VerificationCode("age1example", "laptop")
+
+	if len(code) != 19 || code[4] != '-' || code[9] != '-' || code[14] != '-' {
+		t.Errorf("unexpected verification code format: %q", code)
+	}
+	if code != VerificationCode("age1example", "laptop") {
+		t.Error("verification code is not deterministic")
+	}
+	if code == VerificationCode("age1other", "laptop") {
+		t.Error("verification code does not depend on the public key")
+	}
+	if code == VerificationCode("age1example", "desktop") {
+		t.Error("verification code does not depend on the hostname")
+	}
+}
+
+func TestRequestWriteReadVerify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "request.yml")
+
+	request := NewRequest("age1example", "laptop")
+	if err := WriteRequest(path, request); err != nil {
+		t.Fatalf("failed to write request: %v", err)
+	}
+
+	loaded, err := ReadRequest(path)
+	if err != nil {
+		t.Fatalf("failed to read request: %v", err)
+	}
+	if err := loaded.Verify(); err != nil {
+		t.Errorf("expected request to verify, got: %v", err)
+	}
+	if loaded.Recipient != request.Recipient || loaded.Hostname != request.Hostname || loaded.Code != request.Code {
+		t.Errorf("request changed after round trip: got %+v, want %+v", loaded, request)
+	}
+}
+
+func TestRequestVerify_Tampered(t *testing.T) {
+	request := NewRequest("age1example", "laptop")
+	request.Recipient = "age1attacker"
+
+	if err := request.Verify(); err == nil {
+		t.Error("expected tampered request to fail verification")
+	}
+}
+
+func TestResponseWriteRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "response.yml")
+	encrypted := []byte("age-encryption.org/v1\n\x00\x01binary payload")
+
+	response, err := NewResponse(NewRequest("age1example", "laptop"), encrypted)
+	if err != nil {
+		t.Fatalf("failed to create response: %v", err)
+	}
+	if err := WriteResponse(path, response); err != nil {
+		t.Fatalf("failed to write response: %v", err)
+	}
+
+	loaded, err := ReadResponse(path)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	if loaded.Recipient != "age1example" || loaded.Hostname != "laptop" {
+		t.Errorf("unexpected response content: %+v", loaded)
+	}
+
+	decoded, err := loaded.EncryptedVaultKey()
+	if err != nil {
+		t.Fatalf("failed to decode vault key: %v", err)
+	}
+	if !bytes.Equal(decoded, encrypted) {
+		t.Errorf("vault key changed after round trip")
+	}
+}
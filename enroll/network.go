@@ -0,0 +1,148 @@
+package enroll
+
+import (
+	"fmt"
+	"io"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Join runs the joining side of a network enrollment over conn.
+// It authenticates with code, sends the request and returns the encrypted vault key
+// sent back by the serving member.
+func Join(conn io.ReadWriter, code string, request *Request) ([]byte, error) {
+	session, err := newPakeSession(code, true)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeMessage(conn, session.message); err != nil {
+		return nil, fmt.Errorf("error sending pake message: %w", err)
+	}
+	peerMessage, err := readMessage(conn)
+	if err != nil {
+		return nil, fmt.Errorf("error receiving pake message: %w", err)
+	}
+	keys, err := session.finish(peerMessage)
+	if err != nil {
+		return nil, err
+	}
+
+	// Prove we know the code, then check that the serving member does too
+	if err := writeMessage(conn, keys.confirmation); err != nil {
+		return nil, fmt.Errorf("error sending pake confirmation: %w", err)
+	}
+	confirmation, err := readMessage(conn)
+	if err != nil {
+		return nil, fmt.Errorf("error receiving pake confirmation (was the code correct?): %w", err)
+	}
+	if err := keys.checkConfirmation(confirmation); err != nil {
+		return nil, err
+	}
+
+	// Send our request
+	data, err := yaml.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding enrollment request: %w", err)
+	}
+	sealed, err := seal(keys.sendKey, data)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeMessage(conn, sealed); err != nil {
+		return nil, fmt.Errorf("error sending enrollment request: %w", err)
+	}
+
+	// Receive the vault key
+	sealed, err = readMessage(conn)
+	if err != nil {
+		return nil, fmt.Errorf("error receiving enrollment response: %w", err)
+	}
+	data, err = open(keys.receiveKey, sealed)
+	if err != nil {
+		return nil, err
+	}
+	response := &Response{}
+	if err := yaml.Unmarshal(data, response); err != nil {
+		return nil, fmt.Errorf("error parsing enrollment response: %w", err)
+	}
+	if response.Recipient != request.Recipient {
+		return nil, fmt.Errorf("enrollment response was made for public key %s, not %s", response.Recipient, request.Recipient)
+	}
+
+	return response.EncryptedVaultKey()
+}
+
+// Serve runs the serving side of a network enrollment over conn.
+// It authenticates with code, verifies the request received from the joining machine, and
+// sends back the encrypted vault key returned by approve. The request is returned once the
+// vault key was sent.
+func Serve(conn io.ReadWriter, code string, approve func(*Request) ([]byte, error)) (*Request, error) {
+	peerMessage, err := readMessage(conn)
+	if err != nil {
+		return nil, fmt.Errorf("error receiving pake message: %w", err)
+	}
+	session, err := newPakeSession(code, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeMessage(conn, session.message); err != nil {
+		return nil, fmt.Errorf("error sending pake message: %w", err)
+	}
+	keys, err := session.finish(peerMessage)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check that the joining machine knows the code; a wrong code fails here
+	confirmation, err := readMessage(conn)
+	if err != nil {
+		return nil, fmt.Errorf("error receiving pake confirmation: %w", err)
+	}
+	if err := keys.checkConfirmation(confirmation); err != nil {
+		return nil, err
+	}
+	if err := writeMessage(conn, keys.confirmation); err != nil {
+		return nil, fmt.Errorf("error sending pake confirmation: %w", err)
+	}
+
+	// Receive the request
+	sealed, err := readMessage(conn)
+	if err != nil {
+		return nil, fmt.Errorf("error receiving enrollment request: %w", err)
+	}
+	data, err := open(keys.receiveKey, sealed)
+	if err != nil {
+		return nil, err
+	}
+	request := &Request{}
+	if err := yaml.Unmarshal(data, request); err != nil {
+		return nil, fmt.Errorf("error parsing enrollment request: %w", err)
+	}
+	if err := request.Verify(); err != nil {
+		return nil, err
+	}
+
+	encryptedVaultKey, err := approve(request)
+	if err != nil {
+		return nil, err
+	}
+
+	// Send the vault key back
+	response, err := NewResponse(request, encryptedVaultKey)
+	if err != nil {
+		return nil, err
+	}
+	data, err = yaml.Marshal(response)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding enrollment response: %w", err)
+	}
+	sealed, err = seal(keys.sendKey, data)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeMessage(conn, sealed); err != nil {
+		return nil, fmt.Errorf("error sending enrollment response: %w", err)
+	}
+
+	return request, nil
+}
@@ -0,0 +1,123 @@
+package enroll
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+)
+
+// runExchange runs Serve and Join against each other over a loopback TCP connection.
+func runExchange(t *testing.T, serveCode, joinCode string, request *Request, vaultKey []byte) (served *Request, received []byte, serveErr, joinErr error) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	type serveResult struct {
+		request *Request
+		err     error
+	}
+	done := make(chan serveResult, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			done <- serveResult{nil, err}
+			return
+		}
+		defer conn.Close()
+		served, err := Serve(conn, serveCode, func(*Request) ([]byte, error) {
+			return vaultKey, nil
+		})
+		done <- serveResult{served, err}
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	received, joinErr = Join(conn, joinCode, request)
+	conn.Close()
+
+	result := <-done
+	return result.request, received, result.err, joinErr
+}
+
+func TestServeJoin(t *testing.T) {
+	code, err := NewCode()
+	if err != nil {
+		t.Fatalf("failed to generate code: %v", err)
+	}
+	request := NewRequest("age1example", "laptop")
+	vaultKey := []byte("encrypted vault key")
+
+	served, received, serveErr, joinErr := runExchange(t, code, code, request, vaultKey)
+	if serveErr != nil {
+		t.Fatalf("Serve failed: %v", serveErr)
+	}
+	if joinErr != nil {
+		t.Fatalf("Join failed: %v", joinErr)
+	}
+	if served.Recipient != request.Recipient || served.Hostname != request.Hostname {
+		t.Errorf("served request = %+v, want %+v", served, request)
+	}
+	if !bytes.Equal(received, vaultKey) {
+		t.Errorf("received vault key = %q, want %q", received, vaultKey)
+	}
+}
+
+func TestServeJoin_CodeNormalization(t *testing.T) {
+	request := NewRequest("age1example", "laptop")
+
+	_, _, serveErr, joinErr := runExchange(t, "ABCD-EFGH", " abcd-efgh", request, []byte("key"))
+	if serveErr != nil || joinErr != nil {
+		t.Fatalf("expected codes to match after normalization: serve=%v join=%v", serveErr, joinErr)
+	}
+}
+
+func TestServeJoin_WrongCode(t *testing.T) {
+	request := NewRequest("age1example", "laptop")
+	approved := false
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer conn.Close()
+		_, err = Serve(conn, "AAAA-BBBB", func(*Request) ([]byte, error) {
+			approved = true
+			return []byte("key"), nil
+		})
+		done <- err
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	_, joinErr := Join(conn, "CCCC-DDDD", request)
+	conn.Close()
+	serveErr := <-done
+
+	if !errors.Is(serveErr, ErrPakeFailed) {
+		t.Errorf("expected Serve to fail with ErrPakeFailed, got: %v", serveErr)
+	}
+	if joinErr == nil {
+		t.Error("expected Join to fail with a wrong code")
+	}
+	if approved {
+		t.Error("vault key should not be released with a wrong code")
+	}
+}
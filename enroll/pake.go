@@ -0,0 +1,305 @@
+package enroll
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+
+	"filippo.io/nistec"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/scrypt"
+)
+
+// The network enrollment runs SPAKE2 as specified in RFC 9382, with the
+// SPAKE2-P256-SHA256-HKDF-HMAC ciphersuite, authenticated by a short code shared out of band.
+// Both sides derive the same keys only if they used the same code, which they prove to each
+// other with the confirmation messages of the RFC, and an attacker gets a single online guess
+// per run. The joining machine is party A and the serving member party B. The shared key Ke
+// then encrypts the joining machine's public key in one direction and its encrypted vault key
+// in the other.
+
+const (
+	pakeLabel = "age-vault-enroll-pake/v2"
+
+	// pakeIdentityA and pakeIdentityB are the party identities bound into the transcript
+	pakeIdentityA = "age-vault enroll join"
+	pakeIdentityB = "age-vault enroll serve"
+
+	// maxMessageSize bounds the size of messages read from the network
+	maxMessageSize = 1 << 20
+)
+
+var (
+	// pakeM and pakeN are the P-256 blinding points of RFC 9382, section 6.
+	pakeM = mustDecodePoint("02886e2f97ace46e55ba9dd7242579f2993b64e16ef3dcab95afd497333d8fa12f")
+	pakeN = mustDecodePoint("03d8bbd6c639c62937b04d997f38c3770719c629d7014d49a24b4f98baa1292b49")
+
+	// p256Order is the order of the P-256 group.
+	p256Order, _ = new(big.Int).SetString("ffffffff00000000ffffffffffffffffbce6faada7179e84f3b9cac2fc632551", 16)
+)
+
+// ErrPakeFailed is returned when the two sides of an enrollment did not use the same code.
+var ErrPakeFailed = errors.New("enrollment code mismatch or tampered connection")
+
+// mustDecodePoint decodes a hex encoded P-256 point.
+func mustDecodePoint(s string) *nistec.P256Point {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	p, err := nistec.NewP256Point().SetBytes(b)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// scalarFromBytes reduces a big-endian value, at least 128 bits longer than the group order
+// so the result is unbiased, to a 32-byte scalar.
+func scalarFromBytes(b []byte) []byte {
+	return new(big.Int).Mod(new(big.Int).SetBytes(b), p256Order).FillBytes(make([]byte, 32))
+}
+
+// negateScalar returns -s modulo the group order.
+func negateScalar(s []byte) []byte {
+	n := new(big.Int).Neg(new(big.Int).SetBytes(s))
+	return n.Mod(n, p256Order).FillBytes(make([]byte, 32))
+}
+
+// NewCode generates a random enrollment code, formatted as groups of four characters.
+func NewCode() (string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error generating enrollment code: %w", err)
+	}
+	s := base32.StdEncoding.EncodeToString(b)
+	return s[0:4] + "-" + s[4:8] + "-" + s[8:12] + "-" + s[12:16], nil
+}
+
+// normalizeCode makes codes typed by hand compare equal regardless of case and spacing.
+func normalizeCode(code string) string {
+	return strings.ToUpper(strings.Join(strings.Fields(code), ""))
+}
+
+// pakePassword derives the SPAKE2 password scalar w from code with scrypt, the memory-hard
+// function RFC 9382 asks for.
+func pakePassword(code string) ([]byte, error) {
+	h, err := scrypt.Key([]byte(normalizeCode(code)), []byte(pakeLabel), 1<<15, 8, 1, 48)
+	if err != nil {
+		return nil, err
+	}
+	return scalarFromBytes(h), nil
+}
+
+// pakeSession holds one side of a SPAKE2 exchange.
+type pakeSession struct {
+	initiator bool
+	w         []byte
+	x         []byte
+	message   []byte
+}
+
+// pakeKeys are the outcome of a SPAKE2 exchange: our confirmation message, the one expected
+// from the peer, and the session keys for sending and receiving.
+type pakeKeys struct {
+	confirmation     []byte
+	peerConfirmation []byte
+	sendKey          []byte
+	receiveKey       []byte
+}
+
+// newPakeSession starts a SPAKE2 exchange for code. The initiator (party A) blinds with M
+// and the responder (party B) with N.
+func newPakeSession(code string, initiator bool) (*pakeSession, error) {
+	w, err := pakePassword(code)
+	if err != nil {
+		return nil, err
+	}
+
+	random := make([]byte, 48)
+	if _, err := rand.Read(random); err != nil {
+		return nil, fmt.Errorf("error generating pake secret: %w", err)
+	}
+	x := scalarFromBytes(random)
+
+	blind := pakeN
+	if initiator {
+		blind = pakeM
+	}
+	message, err := spake2Message(w, x, blind)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pakeSession{initiator: initiator, w: w, x: x, message: message}, nil
+}
+
+// finish combines the peer's message with our secret and returns the confirmation messages
+// and session keys.
+func (s *pakeSession) finish(peerMessage []byte) (*pakeKeys, error) {
+	peerBlind := pakeM
+	if s.initiator {
+		peerBlind = pakeN
+	}
+	k, err := spake2SharedPoint(s.w, s.x, peerMessage, peerBlind)
+	if err != nil {
+		return nil, err
+	}
+
+	pA, pB := s.message, peerMessage
+	if !s.initiator {
+		pA, pB = peerMessage, s.message
+	}
+	transcript := spake2Transcript(pakeIdentityA, pakeIdentityB, pA, pB, k, s.w)
+	ke, confirmationA, confirmationB, err := spake2KeySchedule(transcript)
+	if err != nil {
+		return nil, err
+	}
+
+	kdf := hkdf.New(sha256.New, ke, nil, []byte(pakeLabel))
+	keyA := make([]byte, chacha20poly1305.KeySize)
+	keyB := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(kdf, keyA); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(kdf, keyB); err != nil {
+		return nil, err
+	}
+
+	if s.initiator {
+		return &pakeKeys{confirmationA, confirmationB, keyA, keyB}, nil
+	}
+	return &pakeKeys{confirmationB, confirmationA, keyB, keyA}, nil
+}
+
+// checkConfirmation checks the confirmation message received from the peer.
+func (k *pakeKeys) checkConfirmation(confirmation []byte) error {
+	if !hmac.Equal(confirmation, k.peerConfirmation) {
+		return ErrPakeFailed
+	}
+	return nil
+}
+
+// spake2Message returns x*G + w*blind, the message sent by a party with secret x.
+func spake2Message(w, x []byte, blind *nistec.P256Point) ([]byte, error) {
+	p, err := nistec.NewP256Point().ScalarBaseMult(x)
+	if err != nil {
+		return nil, err
+	}
+	wBlind, err := nistec.NewP256Point().ScalarMult(blind, w)
+	if err != nil {
+		return nil, err
+	}
+	return p.Add(p, wBlind).Bytes(), nil
+}
+
+// spake2SharedPoint returns K = x*(peerMessage - w*peerBlind). Invalid points and the
+// identity, received or computed, are rejected.
+func spake2SharedPoint(w, x, peerMessage []byte, peerBlind *nistec.P256Point) ([]byte, error) {
+	peer, err := nistec.NewP256Point().SetBytes(peerMessage)
+	if err != nil || len(peer.Bytes()) == 1 {
+		return nil, ErrPakeFailed
+	}
+	unblind, err := nistec.NewP256Point().ScalarMult(peerBlind, negateScalar(w))
+	if err != nil {
+		return nil, err
+	}
+	k, err := nistec.NewP256Point().ScalarMult(peer.Add(peer, unblind), x)
+	if err != nil {
+		return nil, err
+	}
+	kBytes := k.Bytes()
+	if len(kBytes) == 1 {
+		return nil, ErrPakeFailed
+	}
+	return kBytes, nil
+}
+
+// spake2Transcript returns the transcript TT of RFC 9382, section 3.3: every field prefixed
+// with its length as a little-endian 64-bit integer.
+func spake2Transcript(idA, idB string, pA, pB, k, w []byte) []byte {
+	var transcript []byte
+	for _, part := range [][]byte{[]byte(idA), []byte(idB), pA, pB, k, w} {
+		transcript = binary.LittleEndian.AppendUint64(transcript, uint64(len(part)))
+		transcript = append(transcript, part...)
+	}
+	return transcript
+}
+
+// spake2KeySchedule derives the shared key Ke and the confirmation messages of both parties
+// from the transcript, as in RFC 9382, section 4.
+func spake2KeySchedule(transcript []byte) (ke, confirmationA, confirmationB []byte, err error) {
+	h := sha256.Sum256(transcript)
+	ke, ka := h[:16], h[16:]
+
+	kc := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, ka, nil, []byte("ConfirmationKeys")), kc); err != nil {
+		return nil, nil, nil, err
+	}
+	mac := func(key []byte) []byte {
+		m := hmac.New(sha256.New, key)
+		m.Write(transcript)
+		return m.Sum(nil)
+	}
+	return ke, mac(kc[:16]), mac(kc[16:]), nil
+}
+
+// writeMessage writes a length-prefixed message.
+func writeMessage(w io.Writer, message []byte) error {
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(message)))
+	if _, err := w.Write(length[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(message)
+	return err
+}
+
+// readMessage reads a length-prefixed message.
+func readMessage(r io.Reader) ([]byte, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(length[:])
+	if n > maxMessageSize {
+		return nil, fmt.Errorf("message too large (%d bytes)", n)
+	}
+	message := make([]byte, n)
+	if _, err := io.ReadFull(r, message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// seal encrypts a single message with a session key. Each key is only used once, so a
+// zero nonce is safe.
+func seal(key, plaintext []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nil, make([]byte, chacha20poly1305.NonceSize), plaintext, nil), nil
+}
+
+// open decrypts a message sealed with seal.
+func open(key, ciphertext []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, make([]byte, chacha20poly1305.NonceSize), ciphertext, nil)
+	if err != nil {
+		return nil, ErrPakeFailed
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,137 @@
+package enroll
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func decodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("invalid hex %q: %v", s, err)
+	}
+	return b
+}
+
+// TestSPAKE2Vectors checks the exchange against the SPAKE2-P256-SHA256-HKDF-HMAC test vector
+// of RFC 9382, appendix B, with A='server' and B='client'.
+func TestSPAKE2Vectors(t *testing.T) {
+	w := decodeHex(t, "2ee57912099d31560b3a44b1184b9b4866e904c49d12ac5042c97dca461b1a5f")
+	x := decodeHex(t, "43dd0fd7215bdcb482879fca3220c6a968e66d70b1356cac18bb26c84a78d729")
+	y := decodeHex(t, "dcb60106f276b02606d8ef0a328c02e4b629f84f89786af5befb0bc75b6e66be")
+	wantPA := decodeHex(t, "04a56fa807caaa53a4d28dbb9853b9815c61a411118a6fe516a8798434751470f9010153ac33d0d5f2047ffdb1a3e42c9b4e6be662766e1eeb4116988ede5f912c")
+	wantPB := decodeHex(t, "0406557e482bd03097ad0cbaa5df82115460d951e3451962f1eaf4367a420676d09857ccbc522686c83d1852abfa8ed6e4a1155cf8f1543ceca528afb591a1e0b7")
+	wantK := decodeHex(t, "0412af7e89717850671913e6b469ace67bd90a4df8ce45c2af19010175e37eed69f75897996d539356e2fa6a406d528501f907e04d97515fbe83db277b715d3325")
+	wantKe := decodeHex(t, "0e0672dc86f8e45565d338b0540abe69")
+	wantConfirmationA := decodeHex(t, "58ad4aa88e0b60d5061eb6b5dd93e80d9c4f00d127c65b3b35b1b5281fee38f0")
+	wantConfirmationB := decodeHex(t, "d3e2e547f1ae04f2dbdbf0fc4b79f8ecff2dff314b5d32fe9fcef2fb26dc459b")
+
+	pA, err := spake2Message(w, x, pakeM)
+	if err != nil {
+		t.Fatalf("spake2Message(A) failed: %v", err)
+	}
+	if !bytes.Equal(pA, wantPA) {
+		t.Errorf("pA = %x, want %x", pA, wantPA)
+	}
+	pB, err := spake2Message(w, y, pakeN)
+	if err != nil {
+		t.Fatalf("spake2Message(B) failed: %v", err)
+	}
+	if !bytes.Equal(pB, wantPB) {
+		t.Errorf("pB = %x, want %x", pB, wantPB)
+	}
+
+	kA, err := spake2SharedPoint(w, x, pB, pakeN)
+	if err != nil {
+		t.Fatalf("spake2SharedPoint(A) failed: %v", err)
+	}
+	kB, err := spake2SharedPoint(w, y, pA, pakeM)
+	if err != nil {
+		t.Fatalf("spake2SharedPoint(B) failed: %v", err)
+	}
+	if !bytes.Equal(kA, wantK) || !bytes.Equal(kB, wantK) {
+		t.Errorf("K = %x and %x, want %x", kA, kB, wantK)
+	}
+
+	transcript := spake2Transcript("server", "client", pA, pB, wantK, w)
+	ke, confirmationA, confirmationB, err := spake2KeySchedule(transcript)
+	if err != nil {
+		t.Fatalf("spake2KeySchedule failed: %v", err)
+	}
+	if !bytes.Equal(ke, wantKe) {
+		t.Errorf("Ke = %x, want %x", ke, wantKe)
+	}
+	if !bytes.Equal(confirmationA, wantConfirmationA) {
+		t.Errorf("A conf = %x, want %x", confirmationA, wantConfirmationA)
+	}
+	if !bytes.Equal(confirmationB, wantConfirmationB) {
+		t.Errorf("B conf = %x, want %x", confirmationB, wantConfirmationB)
+	}
+}
+
+// exchange runs both sides of a SPAKE2 exchange in memory.
+func exchange(t *testing.T, codeA, codeB string) (keysA, keysB *pakeKeys, errB error) {
+	t.Helper()
+
+	a, err := newPakeSession(codeA, true)
+	if err != nil {
+		t.Fatalf("newPakeSession(A) failed: %v", err)
+	}
+	b, err := newPakeSession(codeB, false)
+	if err != nil {
+		t.Fatalf("newPakeSession(B) failed: %v", err)
+	}
+	keysA, err = a.finish(b.message)
+	if err != nil {
+		t.Fatalf("finish(A) failed: %v", err)
+	}
+	keysB, err = b.finish(a.message)
+	if err != nil {
+		t.Fatalf("finish(B) failed: %v", err)
+	}
+	return keysA, keysB, keysB.checkConfirmation(keysA.confirmation)
+}
+
+func TestPakeSession(t *testing.T) {
+	keysA, keysB, err := exchange(t, "ABCD-EFGH-IJKL-MNOP", "abcd-efgh-ijkl-mnop")
+	if err != nil {
+		t.Fatalf("B rejected the confirmation of A: %v", err)
+	}
+	if err := keysA.checkConfirmation(keysB.confirmation); err != nil {
+		t.Fatalf("A rejected the confirmation of B: %v", err)
+	}
+	if !bytes.Equal(keysA.sendKey, keysB.receiveKey) || !bytes.Equal(keysA.receiveKey, keysB.sendKey) {
+		t.Error("session keys differ between the two sides")
+	}
+	if bytes.Equal(keysA.sendKey, keysA.receiveKey) {
+		t.Error("both directions use the same session key")
+	}
+}
+
+func TestPakeSession_WrongPassword(t *testing.T) {
+	keysA, keysB, err := exchange(t, "ABCD-EFGH-IJKL-MNOP", "ABCD-EFGH-IJKL-MNOQ")
+	if !errors.Is(err, ErrPakeFailed) {
+		t.Errorf("expected B to reject the confirmation of A, got: %v", err)
+	}
+	if err := keysA.checkConfirmation(keysB.confirmation); !errors.Is(err, ErrPakeFailed) {
+		t.Errorf("expected A to reject the confirmation of B, got: %v", err)
+	}
+	if bytes.Equal(keysA.sendKey, keysB.receiveKey) {
+		t.Error("session keys match with a wrong password")
+	}
+}
+
+func TestPakeSession_InvalidPoint(t *testing.T) {
+	session, err := newPakeSession("ABCD-EFGH", true)
+	if err != nil {
+		t.Fatalf("newPakeSession failed: %v", err)
+	}
+	for _, message := range [][]byte{{0}, bytes.Repeat([]byte{0xff}, 65), nil} {
+		if _, err := session.finish(message); !errors.Is(err, ErrPakeFailed) {
+			t.Errorf("finish(%x) = %v, want ErrPakeFailed", message, err)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+// Package fingerprint derives short, human-comparable fingerprints from vault public keys.
+// Two machines share the same vault key exactly when their fingerprints match.
+package fingerprint
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+)
+
+// Size is the number of bytes in a fingerprint.
+const Size = 10
+
+// wordCount is the number of bytes shown in the word form of a fingerprint.
+const wordCount = 8
+
+// Fingerprint is a truncated hash of a vault public key.
+type Fingerprint [Size]byte
+
+// Of computes the fingerprint of a public key string (e.g. "age1...").
+func Of(recipient string) Fingerprint {
+	sum := sha256.Sum256([]byte("age-vault-fingerprint/v1\x00" + strings.TrimSpace(recipient)))
+	var f Fingerprint
+	copy(f[:], sum[:Size])
+	return f
+}
+
+// Hex returns the fingerprint as lowercase hex in groups of four characters.
+func (f Fingerprint) Hex() string {
+	h := hex.EncodeToString(f[:])
+	groups := make([]string, 0, len(h)/4)
+	for i := 0; i < len(h); i += 4 {
+		groups = append(groups, h[i:i+4])
+	}
+	return strings.Join(groups, " ")
+}
+
+// Words returns the fingerprint as a sequence of words, which is easier to read aloud.
+func (f Fingerprint) Words() string {
+	words := make([]string, wordCount)
+	for i := range words {
+		words[i] = wordList[f[i]]
+	}
+	return strings.Join(words, " ")
+}
+
+// Matches reports whether s is the hex or word form of the fingerprint.
+// Case, spaces and dashes are ignored.
+func (f Fingerprint) Matches(s string) bool {
+	s = strings.ToLower(strings.ReplaceAll(s, "-", " "))
+	fields := strings.Fields(s)
+
+	// Word form
+	if len(fields) == wordCount && strings.Join(fields, " ") == f.Words() {
+		return true
+	}
+
+	// Hex form
+	expected := hex.EncodeToString(f[:])
+	got := strings.Join(fields, "")
+	return subtle.ConstantTimeCompare([]byte(got), []byte(expected)) == 1
+}
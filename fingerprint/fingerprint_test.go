@@ -0,0 +1,70 @@
+package fingerprint
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestOf(t *testing.T) {
+	a := Of("age1aaa")
+	if a != Of("age1aaa\n") {
+		t.Error("fingerprint should ignore surrounding whitespace")
+	}
+	if a == Of("age1bbb") {
+		t.Error("different keys should have different fingerprints")
+	}
+}
+
+func TestHexAndWords(t *testing.T) {
+	f := Of("age1aaa")
+
+	h := f.Hex()
+	if len(strings.ReplaceAll(h, " ", "")) != Size*2 {
+		t.Errorf("unexpected hex length: %q", h)
+	}
+	if len(strings.Fields(h)) != Size/2 {
+		t.Errorf("expected hex in groups of four, got %q", h)
+	}
+
+	words := strings.Fields(f.Words())
+	if len(words) != wordCount {
+		t.Errorf("expected %d words, got %d", wordCount, len(words))
+	}
+}
+
+func TestWordListUnique(t *testing.T) {
+	seen := map[string]bool{}
+	for _, w := range wordList {
+		if w == "" || seen[w] {
+			t.Errorf("word list entry %q is empty or duplicated", w)
+		}
+		seen[w] = true
+	}
+}
+
+func TestMatches(t *testing.T) {
+	f := Of("age1aaa")
+
+	tests := []struct {
+		name  string
+		input string
+		want  bool
+	}{
+		{"hex", f.Hex(), true},
+		{"hex uppercase with dashes", strings.ToUpper(strings.ReplaceAll(f.Hex(), " ", "-")), true},
+		{"hex without spaces", strings.ReplaceAll(f.Hex(), " ", ""), true},
+		{"words", f.Words(), true},
+		{"words with dashes", strings.ReplaceAll(f.Words(), " ", "-"), true},
+		{"other key hex", Of("age1bbb").Hex(), false},
+		{"other key words", Of("age1bbb").Words(), false},
+		{"truncated hex", f.Hex()[:9], false},
+		{"empty", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := f.Matches(tt.input); got != tt.want {
+				t.Errorf("Matches(%q) = %v, want %v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
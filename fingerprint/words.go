@@ -0,0 +1,37 @@
+package fingerprint
+
+// wordList maps each byte value to a short, distinct English word.
+var wordList = [256]string{
+	"acid", "acorn", "actor", "adult", "agent", "alarm", "album", "alien",
+	"alley", "amber", "angle", "ankle", "apple", "apron", "arena", "armor",
+	"arrow", "atlas", "attic", "audio", "autumn", "badge", "bagel", "baker",
+	"bamboo", "banjo", "barn", "basil", "basket", "beach", "beacon", "beard",
+	"beaver", "bell", "bench", "berry", "bison", "blade", "blanket", "blossom",
+	"boat", "bonnet", "bottle", "boulder", "bracket", "branch", "bread", "brick",
+	"bridge", "broom", "bubble", "bucket", "bugle", "bunny", "butter", "cabin",
+	"cactus", "camel", "candle", "canoe", "canyon", "carpet", "carrot", "castle",
+	"cedar", "cello", "chalk", "cherry", "chess", "cider", "cinema", "circus",
+	"clock", "cloud", "clover", "cobra", "coffee", "comet", "copper", "coral",
+	"cotton", "coyote", "crane", "crayon", "crystal", "curtain", "daisy", "dancer",
+	"delta", "desert", "diamond", "dolphin", "donkey", "dragon", "drum", "eagle",
+	"easel", "echo", "elbow", "ember", "engine", "falcon", "feather", "fence",
+	"fiddle", "finch", "flute", "forest", "fossil", "fox", "galaxy", "garden",
+	"garlic", "ginger", "glacier", "globe", "goat", "gravel", "guitar", "hammer",
+	"harbor", "harp", "hazel", "helmet", "heron", "hockey", "honey", "hornet",
+	"igloo", "iris", "island", "ivory", "jacket", "jaguar", "jelly", "jungle",
+	"kayak", "kettle", "kite", "kiwi", "koala", "ladder", "lagoon", "lantern",
+	"lemon", "lentil", "lizard", "locket", "lotus", "magnet", "mango", "maple",
+	"marble", "meadow", "melon", "mirror", "mitten", "monkey", "moose", "muffin",
+	"napkin", "nectar", "needle", "nickel", "noodle", "nutmeg", "oak", "oasis",
+	"ocean", "olive", "onion", "orbit", "orchid", "otter", "owl", "paddle",
+	"panda", "paper", "parrot", "peach", "peanut", "pebble", "pepper", "piano",
+	"pickle", "pigeon", "pillow", "pine", "pirate", "planet", "plum", "pocket",
+	"pony", "poppy", "potato", "pumpkin", "puzzle", "quail", "quartz", "quill",
+	"rabbit", "radar", "radish", "raven", "ribbon", "river", "robin", "rocket",
+	"saddle", "salmon", "sandal", "saturn", "scarf", "shadow", "shell", "shovel",
+	"silver", "sketch", "sloth", "snail", "sparrow", "spider", "spoon", "stamp",
+	"statue", "summit", "sunset", "swan", "tablet", "tango", "teapot", "temple",
+	"thunder", "tiger", "timber", "tomato", "topaz", "torch", "tulip", "tundra",
+	"turtle", "valley", "velvet", "violin", "walnut", "walrus", "wagon", "whale",
+	"whistle", "willow", "window", "wizard", "yacht", "yogurt", "zebra", "zipper",
+}
@@ -5,10 +5,14 @@ package keymgmt
 import (
 	"bufio"
 	"bytes"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"filippo.io/age"
 	"filippo.io/age/plugin"
@@ -16,6 +20,7 @@ import (
 
 	// REVIEW: why are you using fully qualified domain names for local files??
 	"github.com/leolimasa/age-vault/config"
+	"github.com/leolimasa/age-vault/fingerprint"
 	"github.com/leolimasa/age-vault/vault"
 )
 
@@ -87,49 +92,94 @@ func NewClientUI() *plugin.ClientUI {
 }
 
 // LoadIdentity reads and parses an age identity file from the given path.
-// Supports both native X25519 identities and plugin-based identities.
+// Supports native X25519 and post-quantum hybrid identities and plugin-based identities.
 // Returns the first identity found in the file.
 func LoadIdentity(path string) (age.Identity, error) {
+	identities, err := LoadIdentities(path)
+	if err != nil {
+		return nil, err
+	}
+	return identities[0], nil
+}
+
+// LoadIdentities reads and parses every identity of an age identity file, in order.
+// Supports native X25519 and post-quantum hybrid identities and plugin-based identities, one
+// per line. An identity file listing several identities is a compound identity: vault keys
+// encrypted in layers are unwrapped with each identity in sequence.
+func LoadIdentities(path string) ([]age.Identity, error) {
 	// Read the identity file content
 	content, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("error reading identity file %s: %w", path, err)
 	}
 
-	// Try parsing as native age identity first
-	identities, err := age.ParseIdentities(bytes.NewReader(content))
-	if err == nil && len(identities) > 0 {
-		return identities[0], nil
-	}
-
-	// If native parsing failed, try as plugin identity
-	// Extract the identity line (skip comments and empty lines)
-	var identityStr string
+	var identities []age.Identity
 	lines := bytes.Split(content, []byte("\n"))
-	for _, line := range lines {
-		trimmed := bytes.TrimSpace(line)
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(string(line))
 		// Skip empty lines and comments
-		if len(trimmed) == 0 || bytes.HasPrefix(trimmed, []byte("#")) {
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		// Try parsing as native age identity (X25519 or post-quantum hybrid) first
+		nativeIdentities, nativeErr := age.ParseIdentities(strings.NewReader(trimmed))
+		if nativeErr == nil {
+			identities = append(identities, nativeIdentities...)
 			continue
 		}
-		// Found a non-comment line - assume it's the identity
-		identityStr = string(trimmed)
-		break
+
+		// Otherwise, try loading as plugin identity with proper UI
+		pluginIdentity, pluginErr := plugin.NewIdentity(trimmed, NewClientUI())
+		if pluginErr != nil {
+			return nil, fmt.Errorf("error parsing identity file %s on line %d as native identity: %w, as plugin identity: %v", path, i+1, nativeErr, pluginErr)
+		}
+		identities = append(identities, pluginIdentity)
 	}
 
-	if identityStr == "" {
+	if len(identities) == 0 {
 		return nil, fmt.Errorf("no identity found in file %s", path)
 	}
 
-	// Try loading as plugin identity with proper UI
-	ui := NewClientUI()
-	pluginIdentity, pluginErr := plugin.NewIdentity(identityStr, ui)
-	if pluginErr == nil {
-		return pluginIdentity, nil
+	return identities, nil
+}
+
+// ParseRecipients parses recipient public keys from the given content, one per line.
+// Supports native X25519 and post-quantum hybrid recipients and plugin-based recipients.
+// Empty lines and lines starting with # are ignored.
+func ParseRecipients(content []byte) ([]age.Recipient, error) {
+	var recipients []age.Recipient
+	lines := bytes.Split(content, []byte("\n"))
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(string(line))
+		// Skip empty lines and comments
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		// Try parsing as native age recipient first
+		if recipient, err := age.ParseX25519Recipient(trimmed); err == nil {
+			recipients = append(recipients, recipient)
+			continue
+		}
+		if recipient, err := age.ParseHybridRecipient(trimmed); err == nil {
+			recipients = append(recipients, recipient)
+			continue
+		}
+
+		// Otherwise, try as plugin recipient
+		pluginRecipient, err := plugin.NewRecipient(trimmed, NewClientUI())
+		if err != nil {
+			return nil, fmt.Errorf("error parsing recipient on line %d: %w", i+1, err)
+		}
+		recipients = append(recipients, pluginRecipient)
 	}
 
-	// If both failed, return an appropriate error
-	return nil, fmt.Errorf("error parsing identity file %s as native identity: %w, as plugin identity: %v", path, err, pluginErr)
+	if len(recipients) == 0 {
+		return nil, fmt.Errorf("no recipients found")
+	}
+
+	return recipients, nil
 }
 
 // ExtractRecipient extracts a recipient (public key) from any age.Identity,
@@ -138,6 +188,8 @@ func ExtractRecipient(identity age.Identity) (age.Recipient, error) {
 	switch id := identity.(type) {
 	case *age.X25519Identity:
 		return id.Recipient(), nil
+	case *age.HybridIdentity:
+		return id.Recipient(), nil
 	case *plugin.Identity:
 		return id.Recipient(), nil
 	default:
@@ -152,6 +204,8 @@ func RecipientToString(recipient age.Recipient) (string, error) {
 	switch r := recipient.(type) {
 	case *age.X25519Recipient:
 		return r.String(), nil
+	case *age.HybridRecipient:
+		return r.String(), nil
 	default:
 		// For plugin recipients or other types, we can't easily get the string
 		return "", fmt.Errorf("cannot convert recipient type %T to string directly; for plugin recipients, extract the public key from the identity file comments", recipient)
@@ -171,6 +225,9 @@ func ExtractRecipientString(identityPath string) (string, error) {
 	if x25519Identity, ok := identity.(*age.X25519Identity); ok {
 		return x25519Identity.Recipient().String(), nil
 	}
+	if hybridIdentity, ok := identity.(*age.HybridIdentity); ok {
+		return hybridIdentity.Recipient().String(), nil
+	}
 
 	// For plugin identities, read the public key from file comments
 	if _, ok := identity.(*plugin.Identity); ok {
@@ -180,41 +237,115 @@ func ExtractRecipientString(identityPath string) (string, error) {
 		}
 
 		// Look for public key in comments
-		lines := bytes.Split(content, []byte("\n"))
-		for _, line := range lines {
-			trimmed := bytes.TrimSpace(line)
-			lowerLine := bytes.ToLower(trimmed)
-			if bytes.HasPrefix(lowerLine, []byte("# public key:")) || bytes.HasPrefix(lowerLine, []byte("# recipient:")) {
-				parts := bytes.SplitN(trimmed, []byte(":"), 2)
-				if len(parts) == 2 {
-					return string(bytes.TrimSpace(parts[1])), nil
-				}
-			}
+		if recipient := RecipientFromComments(content); recipient != "" {
+			return recipient, nil
 		}
-		return "", fmt.Errorf("could not find public key in plugin identity file; plugin identity files should include a '# public key: age1...' comment line")
+		return "", fmt.Errorf("could not find public key in plugin identity file; plugin identity files should include a '# public key: age1...' comment line (age-vault identity generate adds it)")
 	}
 
 	return "", fmt.Errorf("unsupported identity type: %T", identity)
 }
 
+// RecipientFromComments returns the public key recorded in the comments of an identity file,
+// as a "# public key: age1..." or "# recipient: age1..." line in any case and spacing (plugins
+// align their comments differently), or "" if there is none.
+func RecipientFromComments(content []byte) string {
+	for _, line := range bytes.Split(content, []byte("\n")) {
+		trimmed := bytes.TrimSpace(line)
+		if !bytes.HasPrefix(trimmed, []byte("#")) {
+			continue
+		}
+		comment := bytes.TrimSpace(trimmed[1:])
+		lowerComment := bytes.ToLower(comment)
+		if bytes.HasPrefix(lowerComment, []byte("public key:")) || bytes.HasPrefix(lowerComment, []byte("recipient:")) {
+			parts := bytes.SplitN(comment, []byte(":"), 2)
+			if recipient := bytes.TrimSpace(parts[1]); len(recipient) > 0 {
+				return string(recipient)
+			}
+		}
+	}
+	return ""
+}
+
+// ReadPassphrase prompts for a passphrase on stderr and reads it from the terminal without
+// echoing it. It is a variable so tests can provide passphrases non-interactively.
+var ReadPassphrase = func(prompt string) (string, error) {
+	fmt.Fprintf(os.Stderr, "%s: ", prompt)
+	passphrase, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintf(os.Stderr, "\n")
+	if err != nil {
+		return "", fmt.Errorf("failed to read passphrase: %w", err)
+	}
+	return string(passphrase), nil
+}
+
+// RecoveryPassphraseEnv names the environment variable providing the recovery passphrase of
+// the vault key when there is no terminal to prompt for it.
+const RecoveryPassphraseEnv = "AGE_VAULT_RECOVERY_PASSPHRASE"
+
+// IsInteractive reports whether the user can be prompted, i.e. stdin is a terminal. It is a
+// variable so tests can simulate a terminal.
+var IsInteractive = func() bool {
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
+
 // VaultKeyFromIdentityFile loads a user identity from file, reads the encrypted
 // vault key from disk, decrypts it using the identity, and returns the decrypted
 // vault key wrapped in a vault.VaultKey object.
+// If the identity cannot be used (e.g. its hardware token is gone) and the vault key
+// was also encrypted to a recovery passphrase, the passphrase is taken from
+// RecoveryPassphraseEnv, or the user is prompted for it if stdin is a terminal. Otherwise
+// the identity error is returned, so scripts fail instead of waiting for a prompt.
 func VaultKeyFromIdentityFile(identityFilePath string, vaultKeyFilePath string) (*vault.VaultKey, error) {
-	// Load user's identity
-	userIdentity, err := LoadIdentity(identityFilePath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to load identity: %w", err)
-	}
-
 	// Read encrypted vault key
 	encryptedVaultKey, err := os.ReadFile(vaultKeyFilePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read vault key file: %w", err)
 	}
 
-	// Decrypt vault key
-	vaultKey, err := vault.DecryptVaultKey(encryptedVaultKey, userIdentity)
+	// Load user's identity and decrypt vault key
+	vaultKey, err := decryptVaultKeyWithIdentityFile(encryptedVaultKey, identityFilePath)
+	if err == nil {
+		return vaultKey, nil
+	}
+	if !vault.HasPassphraseStanza(encryptedVaultKey) {
+		return nil, err
+	}
+
+	// Fall back to the recovery passphrase
+	passphrase := os.Getenv(RecoveryPassphraseEnv)
+	if passphrase == "" {
+		if !IsInteractive() {
+			return nil, fmt.Errorf("%w (the vault key also opens with its recovery passphrase: run interactively or set %s)", err, RecoveryPassphraseEnv)
+		}
+		fmt.Fprintf(os.Stderr, "Could not decrypt vault key with identity: %v\n", err)
+		var readErr error
+		passphrase, readErr = ReadPassphrase("Enter recovery passphrase")
+		if readErr != nil {
+			return nil, readErr
+		}
+	}
+	passphraseIdentity, idErr := vault.NewPassphraseIdentity(passphrase)
+	if idErr != nil {
+		return nil, idErr
+	}
+	vaultKey, err = vault.DecryptVaultKey(encryptedVaultKey, passphraseIdentity)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt vault key with recovery passphrase: %w", err)
+	}
+
+	return vaultKey, nil
+}
+
+// decryptVaultKeyWithIdentityFile decrypts an encrypted vault key with the identities in
+// identityFilePath, one per layer for compound identities.
+func decryptVaultKeyWithIdentityFile(encryptedVaultKey []byte, identityFilePath string) (*vault.VaultKey, error) {
+	userIdentities, err := LoadIdentities(identityFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load identity: %w", err)
+	}
+
+	vaultKey, err := vault.DecryptVaultKey(encryptedVaultKey, userIdentities...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decrypt vault key: %w", err)
 	}
@@ -252,6 +383,96 @@ func CopyFile(sourcePath, destPath string) error {
 	return nil
 }
 
+// WriteFileAtomic writes data to a temporary file next to path and renames it into place,
+// so that readers never observe a partially written file. The file gets secure permissions (0600).
+// Creates parent directories if needed.
+func WriteFileAtomic(path string, data []byte) error {
+	// Ensure parent directory exists
+	if err := config.EnsureParentDir(path); err != nil {
+		return err
+	}
+
+	// Write to a temporary file in the same directory so the rename stays on one filesystem
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("error creating temporary file for %s: %w", path, err)
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath) // No-op once the rename succeeded
+
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error setting permissions on %s: %w", tmpPath, err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing temporary file %s: %w", tmpPath, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error syncing temporary file %s: %w", tmpPath, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error closing temporary file %s: %w", tmpPath, err)
+	}
+
+	// Move the temporary file into place
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("error replacing %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// ShredFile overwrites the file at path with random data before removing it, so its
+// previous content is harder to recover. This is best effort: journaling, copy-on-write
+// filesystems and SSDs may keep copies of the original blocks.
+func ShredFile(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("error accessing %s: %w", path, err)
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %w", path, err)
+	}
+	if _, err := io.CopyN(f, rand.Reader, info.Size()); err != nil {
+		f.Close()
+		return fmt.Errorf("error overwriting %s: %w", path, err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("error syncing %s: %w", path, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("error closing %s: %w", path, err)
+	}
+
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("error removing %s: %w", path, err)
+	}
+	return nil
+}
+
+// BackupFile copies the file at path to a timestamped backup next to it
+// (e.g. vault_key.age.20240131-150405.bak) and returns the backup path.
+// Existing backups are never overwritten.
+func BackupFile(path string) (string, error) {
+	timestamp := time.Now().Format("20060102-150405")
+	backupPath := fmt.Sprintf("%s.%s.bak", path, timestamp)
+	for i := 1; ; i++ {
+		if _, err := os.Stat(backupPath); os.IsNotExist(err) {
+			break
+		}
+		backupPath = fmt.Sprintf("%s.%s-%d.bak", path, timestamp, i)
+	}
+	if err := CopyFile(path, backupPath); err != nil {
+		return "", fmt.Errorf("error backing up %s: %w", path, err)
+	}
+	return backupPath, nil
+}
+
 // GetOrCreateVaultKey loads an existing vault key if it exists, or generates a new one if it doesn't.
 // This function does NOT save the vault key - that's the caller's responsibility.
 func GetOrCreateVaultKey(cfg *config.Config) (age.Identity, error) {
@@ -272,29 +493,119 @@ func GetOrCreateVaultKey(cfg *config.Config) (age.Identity, error) {
 	return vaultKey.GetIdentity()
 }
 
-// SaveVaultKeyForIdentity encrypts a vault key for a specific identity and saves it to disk.
-func SaveVaultKeyForIdentity(vaultKeyIdentity age.Identity, userIdentity age.Identity, savePath string) error {
-	// Extract recipient from user identity
-	recipient, err := ExtractRecipient(userIdentity)
+// SaveVaultKeyForIdentity encrypts a vault key for the identity in cfg.IdentityFile and saves
+// it to savePath. Like every vault key handed out, it is also encrypted to the escrow
+// recipients of cfg and wrapped in an envelope labeled with label (see
+// EncryptVaultKeyringLayers). The result is checked to decrypt with the identity before it
+// is written.
+func SaveVaultKeyForIdentity(vaultKey *vault.VaultKey, label string, savePath string, cfg *config.Config) error {
+	userIdentities, err := LoadIdentities(cfg.IdentityFile)
 	if err != nil {
-		return fmt.Errorf("failed to extract recipient from identity: %w", err)
+		return fmt.Errorf("failed to load identity: %w", err)
+	}
+	userLayers, err := SelfRecipientLayers(cfg.IdentityFile)
+	if err != nil {
+		return err
 	}
 
-	// Encrypt vault key for the recipient
-	encryptedVaultKey, err := vault.EncryptVaultKey(vaultKeyIdentity, recipient)
+	encryptedVaultKey, err := EncryptVaultKeyringLayers(vaultKey, label, cfg, userLayers...)
 	if err != nil {
 		return fmt.Errorf("failed to encrypt vault key: %w", err)
 	}
 
+	// Make sure we can actually decrypt what we are about to save
+	if _, err := vault.DecryptVaultKey(encryptedVaultKey, userIdentities...); err != nil {
+		return fmt.Errorf("failed to verify vault key with identity: %w", err)
+	}
+
 	// Ensure parent directory exists
 	if err := config.EnsureParentDir(savePath); err != nil {
 		return err
 	}
 
-	// Write encrypted vault key to file with secure permissions
-	if err := os.WriteFile(savePath, encryptedVaultKey, 0600); err != nil {
+	if err := WriteFileAtomic(savePath, encryptedVaultKey); err != nil {
 		return fmt.Errorf("failed to write vault key file: %w", err)
 	}
 
 	return nil
 }
+
+// SelfRecipientLayers returns the recipients of the identity file, one layer per identity,
+// so vault keys encrypted for a compound identity require all of its identities.
+func SelfRecipientLayers(identityFilePath string) ([][]age.Recipient, error) {
+	userIdentities, err := LoadIdentities(identityFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load identity: %w", err)
+	}
+
+	var layers [][]age.Recipient
+	for _, userIdentity := range userIdentities {
+		userRecipient, err := ExtractRecipient(userIdentity)
+		if err != nil {
+			return nil, fmt.Errorf("failed to extract recipient from identity: %w", err)
+		}
+		layers = append(layers, []age.Recipient{userRecipient})
+	}
+	return layers, nil
+}
+
+// EscrowID identifies an escrow recipient in the header of encrypted vault keys.
+func EscrowID(pubkey string) string {
+	f := fingerprint.Of(pubkey)
+	return hex.EncodeToString(f[:])
+}
+
+// EscrowRecipients parses the escrow recipients of the configuration.
+func EscrowRecipients(cfg *config.Config) ([]age.Recipient, error) {
+	var escrow []age.Recipient
+	for _, pubkey := range cfg.EscrowRecipients {
+		recipients, err := ParseRecipients([]byte(pubkey))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse escrow recipient %s: %w", pubkey, err)
+		}
+		escrow = append(escrow, vault.NewEscrowRecipient(recipients[0], EscrowID(pubkey)))
+	}
+	return escrow, nil
+}
+
+// EncryptVaultKeyringLayers encrypts a vault key in nested layers of recipients (see
+// vault.EncryptVaultKeyringLayers), in an envelope labeled with label (see vault.Envelope).
+// Escrow recipients are added to every layer, so the escrow key alone still opens the vault
+// key. Since age refuses to mix post-quantum and classic recipients, escrow recipients must
+// be of the same kind as every layer. All vault keys handed out go through here.
+func EncryptVaultKeyringLayers(vaultKey *vault.VaultKey, label string, cfg *config.Config, layers ...[]age.Recipient) ([]byte, error) {
+	escrow, err := EscrowRecipients(cfg)
+	if err != nil {
+		return nil, err
+	}
+	withEscrow := make([][]age.Recipient, len(layers))
+	for i, layer := range layers {
+		if err := vault.CheckRecipientKinds(layer); err != nil {
+			return nil, err
+		}
+		postQuantum := false
+		for _, r := range layer {
+			postQuantum = postQuantum || vault.IsPostQuantum(r)
+		}
+		for j, e := range escrow {
+			if vault.IsPostQuantum(e) != postQuantum {
+				return nil, fmt.Errorf("escrow recipient %s cannot be combined with %s recipients: age refuses to mix post-quantum (hybrid) and classic recipients, configure escrow_recipients of the same kind as the identities of the vault",
+					cfg.EscrowRecipients[j], recipientKindName(postQuantum))
+			}
+		}
+		withEscrow[i] = append(append([]age.Recipient{}, layer...), escrow...)
+	}
+	encryptedKey, err := vault.EncryptVaultKeyringLayers(vaultKey, withEscrow...)
+	if err != nil {
+		return nil, err
+	}
+	return vault.NewEnvelope(vaultKey, label).Seal(encryptedKey), nil
+}
+
+// recipientKindName describes a kind of recipients in errors about mixing kinds.
+func recipientKindName(postQuantum bool) string {
+	if postQuantum {
+		return "post-quantum (hybrid)"
+	}
+	return "classic"
+}
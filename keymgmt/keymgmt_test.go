@@ -3,9 +3,14 @@ package keymgmt
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"filippo.io/age"
+	"filippo.io/age/plugin"
+
+	"github.com/leolimasa/age-vault/config"
+	"github.com/leolimasa/age-vault/vault"
 )
 
 func TestLoadIdentity(t *testing.T) {
@@ -134,6 +139,43 @@ func TestExtractRecipient_PluginIdentity(t *testing.T) {
 	t.Skip("Plugin identity testing requires actual plugin binary")
 }
 
+func TestExtractRecipientString_PluginIdentity(t *testing.T) {
+	identity := plugin.EncodeIdentity("yubikey", []byte("identity data"))
+	recipient := plugin.EncodeRecipient("yubikey", []byte("recipient data"))
+
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"public key comment", "# public key: " + recipient + "\n" + identity + "\n"},
+		{"aligned recipient comment", "#       Serial: 123, Slot: 1\n#    Recipient: " + recipient + "\n" + identity + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "identity.txt")
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatalf("failed to write identity: %v", err)
+			}
+			got, err := ExtractRecipientString(path)
+			if err != nil {
+				t.Fatalf("ExtractRecipientString failed: %v", err)
+			}
+			if got != recipient {
+				t.Errorf("expected %s, got %s", recipient, got)
+			}
+		})
+	}
+
+	// Without a comment the public key is unknown
+	path := filepath.Join(t.TempDir(), "identity.txt")
+	if err := os.WriteFile(path, []byte(identity+"\n"), 0600); err != nil {
+		t.Fatalf("failed to write identity: %v", err)
+	}
+	if _, err := ExtractRecipientString(path); err == nil {
+		t.Error("expected error without a public key comment")
+	}
+}
+
 // mockIdentity is a mock type that implements age.Identity for testing
 type mockIdentity struct{}
 
@@ -151,3 +193,331 @@ func TestExtractRecipient_UnsupportedType(t *testing.T) {
 		t.Error("ExtractRecipient() should fail with unsupported identity type")
 	}
 }
+
+func TestParseRecipients(t *testing.T) {
+	identity1, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("GenerateX25519Identity() failed: %v", err)
+	}
+	identity2, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("GenerateX25519Identity() failed: %v", err)
+	}
+
+	content := "# laptop\n" + identity1.Recipient().String() + "\n\n" + identity2.Recipient().String() + "\n"
+	recipients, err := ParseRecipients([]byte(content))
+	if err != nil {
+		t.Fatalf("ParseRecipients() failed: %v", err)
+	}
+
+	if len(recipients) != 2 {
+		t.Fatalf("Expected 2 recipients, got %d", len(recipients))
+	}
+
+	if recipients[0].(*age.X25519Recipient).String() != identity1.Recipient().String() {
+		t.Error("First recipient does not match")
+	}
+}
+
+func TestParseRecipients_Invalid(t *testing.T) {
+	if _, err := ParseRecipients([]byte("not-a-recipient\n")); err == nil {
+		t.Error("ParseRecipients() should fail with invalid recipient")
+	}
+
+	if _, err := ParseRecipients([]byte("# only a comment\n")); err == nil {
+		t.Error("ParseRecipients() should fail when no recipients are present")
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	tempDir := t.TempDir()
+	destFile := filepath.Join(tempDir, "subdir", "dest.txt")
+
+	if err := os.MkdirAll(filepath.Dir(destFile), 0700); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(destFile, []byte("old content"), 0644); err != nil {
+		t.Fatalf("Failed to create destination file: %v", err)
+	}
+
+	if err := WriteFileAtomic(destFile, []byte("new content")); err != nil {
+		t.Fatalf("WriteFileAtomic() failed: %v", err)
+	}
+
+	content, err := os.ReadFile(destFile)
+	if err != nil {
+		t.Fatalf("Failed to read destination file: %v", err)
+	}
+	if string(content) != "new content" {
+		t.Errorf("Expected new content, got %s", string(content))
+	}
+
+	info, err := os.Stat(destFile)
+	if err != nil {
+		t.Fatalf("Failed to stat destination file: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected file permissions to be 0600, got %o", info.Mode().Perm())
+	}
+
+	// No temporary files should be left behind
+	entries, err := os.ReadDir(filepath.Dir(destFile))
+	if err != nil {
+		t.Fatalf("Failed to read directory: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Expected only the destination file in directory, got %d entries", len(entries))
+	}
+}
+
+func TestVaultKeyFromIdentityFile_RecoveryPassphrase(t *testing.T) {
+	tempDir := t.TempDir()
+
+	vaultKeyIdentity, err := vault.GenerateVaultKey()
+	if err != nil {
+		t.Fatalf("GenerateVaultKey() failed: %v", err)
+	}
+	lostIdentity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("GenerateX25519Identity() failed: %v", err)
+	}
+	passphraseRecipient, err := vault.NewPassphraseRecipient("correct horse battery staple")
+	if err != nil {
+		t.Fatalf("NewPassphraseRecipient() failed: %v", err)
+	}
+	encryptedKey, err := vault.EncryptVaultKey(vaultKeyIdentity, lostIdentity.Recipient(), passphraseRecipient)
+	if err != nil {
+		t.Fatalf("EncryptVaultKey() failed: %v", err)
+	}
+	vaultKeyFile := filepath.Join(tempDir, "vault_key.age")
+	if err := os.WriteFile(vaultKeyFile, encryptedKey, 0600); err != nil {
+		t.Fatalf("Failed to write vault key file: %v", err)
+	}
+
+	// The identity on this machine can no longer decrypt the vault key
+	otherIdentity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("GenerateX25519Identity() failed: %v", err)
+	}
+	identityFile := filepath.Join(tempDir, "identity.txt")
+	if err := os.WriteFile(identityFile, []byte(otherIdentity.String()), 0600); err != nil {
+		t.Fatalf("Failed to write identity file: %v", err)
+	}
+
+	original := ReadPassphrase
+	originalIsInteractive := IsInteractive
+	t.Cleanup(func() {
+		ReadPassphrase = original
+		IsInteractive = originalIsInteractive
+	})
+
+	// Without a terminal, the identity error is returned instead of prompting
+	IsInteractive = func() bool { return false }
+	ReadPassphrase = func(prompt string) (string, error) {
+		t.Fatalf("unexpected passphrase prompt: %s", prompt)
+		return "", nil
+	}
+	_, err = VaultKeyFromIdentityFile(identityFile, vaultKeyFile)
+	if err == nil || !strings.Contains(err.Error(), "incorrect identity") || !strings.Contains(err.Error(), RecoveryPassphraseEnv) {
+		t.Errorf("expected the identity error mentioning %s, got: %v", RecoveryPassphraseEnv, err)
+	}
+
+	// Scripts can provide the passphrase in the environment
+	t.Setenv(RecoveryPassphraseEnv, "correct horse battery staple")
+	if _, err := VaultKeyFromIdentityFile(identityFile, vaultKeyFile); err != nil {
+		t.Errorf("VaultKeyFromIdentityFile() with %s failed: %v", RecoveryPassphraseEnv, err)
+	}
+	t.Setenv(RecoveryPassphraseEnv, "")
+
+	IsInteractive = func() bool { return true }
+	ReadPassphrase = func(prompt string) (string, error) { return "wrong passphrase", nil }
+	if _, err := VaultKeyFromIdentityFile(identityFile, vaultKeyFile); err == nil {
+		t.Error("VaultKeyFromIdentityFile() should fail with a wrong passphrase")
+	}
+
+	ReadPassphrase = func(prompt string) (string, error) { return "correct horse battery staple", nil }
+	vaultKey, err := VaultKeyFromIdentityFile(identityFile, vaultKeyFile)
+	if err != nil {
+		t.Fatalf("VaultKeyFromIdentityFile() failed: %v", err)
+	}
+	if !vaultKey.Contains(vaultKeyIdentity) {
+		t.Error("Recovered vault key does not match original")
+	}
+}
+
+func TestVaultKeyFromIdentityFile_CompoundIdentity(t *testing.T) {
+	tempDir := t.TempDir()
+
+	vaultKeyIdentity, err := vault.GenerateVaultKey()
+	if err != nil {
+		t.Fatalf("GenerateVaultKey() failed: %v", err)
+	}
+	first, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("GenerateX25519Identity() failed: %v", err)
+	}
+	second, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("GenerateX25519Identity() failed: %v", err)
+	}
+
+	encryptedKey, err := vault.EncryptVaultKeyringLayers(vault.NewVaultKey(vaultKeyIdentity),
+		[]age.Recipient{first.Recipient()}, []age.Recipient{second.Recipient()})
+	if err != nil {
+		t.Fatalf("EncryptVaultKeyringLayers() failed: %v", err)
+	}
+	vaultKeyFile := filepath.Join(tempDir, "vault_key.age")
+	if err := os.WriteFile(vaultKeyFile, encryptedKey, 0600); err != nil {
+		t.Fatalf("Failed to write vault key file: %v", err)
+	}
+
+	// An identity file listing both identities unwraps both layers
+	identityFile := filepath.Join(tempDir, "identity.txt")
+	content := "# first factor\n" + first.String() + "\n# second factor\n" + second.String() + "\n"
+	if err := os.WriteFile(identityFile, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write identity file: %v", err)
+	}
+
+	identities, err := LoadIdentities(identityFile)
+	if err != nil {
+		t.Fatalf("LoadIdentities() failed: %v", err)
+	}
+	if len(identities) != 2 {
+		t.Fatalf("Expected 2 identities, got %d", len(identities))
+	}
+
+	vaultKey, err := VaultKeyFromIdentityFile(identityFile, vaultKeyFile)
+	if err != nil {
+		t.Fatalf("VaultKeyFromIdentityFile() failed: %v", err)
+	}
+	if !vaultKey.Contains(vaultKeyIdentity) {
+		t.Error("Decrypted vault key does not match original")
+	}
+
+	// A single factor is not enough
+	if err := os.WriteFile(identityFile, []byte(first.String()+"\n"), 0600); err != nil {
+		t.Fatalf("Failed to write identity file: %v", err)
+	}
+	if _, err := VaultKeyFromIdentityFile(identityFile, vaultKeyFile); err == nil {
+		t.Error("VaultKeyFromIdentityFile() should fail with only one of the identities")
+	}
+}
+
+func TestShredFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret.txt")
+	if err := os.WriteFile(path, []byte("AGE-SECRET-KEY-1EXAMPLE"), 0600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	if err := ShredFile(path); err != nil {
+		t.Fatalf("ShredFile() failed: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected file to be removed, got: %v", err)
+	}
+
+	if err := ShredFile(path); err == nil {
+		t.Error("ShredFile() should fail for a missing file")
+	}
+}
+
+func TestVaultKeyFromIdentityFile_Envelope(t *testing.T) {
+	tempDir := t.TempDir()
+
+	userIdentity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("GenerateX25519Identity() failed: %v", err)
+	}
+	identityFile := filepath.Join(tempDir, "identity.txt")
+	if err := os.WriteFile(identityFile, []byte(userIdentity.String()+"\n"), 0600); err != nil {
+		t.Fatalf("Failed to write identity file: %v", err)
+	}
+
+	vaultKeyIdentity, err := vault.GenerateVaultKey()
+	if err != nil {
+		t.Fatalf("GenerateVaultKey() failed: %v", err)
+	}
+	vaultKey := vault.NewVaultKey(vaultKeyIdentity)
+	encryptedKey, err := vault.EncryptVaultKeyring(vaultKey, userIdentity.Recipient())
+	if err != nil {
+		t.Fatalf("EncryptVaultKeyring() failed: %v", err)
+	}
+
+	// Both the legacy raw file and the envelope are accepted
+	files := map[string][]byte{
+		"legacy":   encryptedKey,
+		"envelope": vault.NewEnvelope(vaultKey, "laptop").Seal(encryptedKey),
+	}
+	for name, data := range files {
+		t.Run(name, func(t *testing.T) {
+			vaultKeyFile := filepath.Join(tempDir, name+".age")
+			if err := os.WriteFile(vaultKeyFile, data, 0600); err != nil {
+				t.Fatalf("Failed to write vault key file: %v", err)
+			}
+			decrypted, err := VaultKeyFromIdentityFile(identityFile, vaultKeyFile)
+			if err != nil {
+				t.Fatalf("VaultKeyFromIdentityFile() failed: %v", err)
+			}
+			if !decrypted.Contains(vaultKeyIdentity) {
+				t.Error("Decrypted vault key does not match original")
+			}
+		})
+	}
+}
+
+func TestSaveVaultKeyForIdentity(t *testing.T) {
+	tempDir := t.TempDir()
+
+	userIdentity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("GenerateX25519Identity() failed: %v", err)
+	}
+	identityFile := filepath.Join(tempDir, "identity.txt")
+	if err := os.WriteFile(identityFile, []byte(userIdentity.String()+"\n"), 0600); err != nil {
+		t.Fatalf("Failed to write identity file: %v", err)
+	}
+	escrowIdentity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("GenerateX25519Identity() failed: %v", err)
+	}
+	escrowPubkey := escrowIdentity.Recipient().String()
+
+	cfg := &config.Config{
+		IdentityFile:     identityFile,
+		EscrowRecipients: []string{escrowPubkey},
+	}
+
+	vaultKeyIdentity, err := vault.GenerateVaultKey()
+	if err != nil {
+		t.Fatalf("GenerateVaultKey() failed: %v", err)
+	}
+	vaultKey := vault.NewVaultKey(vaultKeyIdentity)
+
+	savePath := filepath.Join(tempDir, "nested", "vault_key.age")
+	if err := SaveVaultKeyForIdentity(vaultKey, "laptop", savePath, cfg); err != nil {
+		t.Fatalf("SaveVaultKeyForIdentity() failed: %v", err)
+	}
+
+	data, err := os.ReadFile(savePath)
+	if err != nil {
+		t.Fatalf("Failed to read vault key file: %v", err)
+	}
+	if !vault.IsEnvelope(data) {
+		t.Error("Expected the vault key to be saved in an envelope")
+	}
+	escrowIDs := vault.EscrowIDs(data)
+	if len(escrowIDs) != 1 || escrowIDs[0] != EscrowID(escrowPubkey) {
+		t.Errorf("EscrowIDs() = %v, want [%s]", escrowIDs, EscrowID(escrowPubkey))
+	}
+
+	// Both the identity and the escrow key open it
+	for name, identity := range map[string]age.Identity{"identity": userIdentity, "escrow": escrowIdentity} {
+		decrypted, err := vault.DecryptVaultKey(data, identity)
+		if err != nil {
+			t.Fatalf("DecryptVaultKey() with %s failed: %v", name, err)
+		}
+		if !decrypted.Contains(vaultKeyIdentity) {
+			t.Errorf("Vault key decrypted with %s does not match original", name)
+		}
+	}
+}
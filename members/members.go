@@ -0,0 +1,140 @@
+// Package members provides the recipients registry for age-vault.
+// The registry records which users/machines have access to the vault and their public keys.
+// It is meant to be checked into the repository next to age_vault.yml.
+package members
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/leolimasa/age-vault/config"
+	"github.com/leolimasa/age-vault/keymgmt"
+)
+
+// Member is a user or machine with access to the vault.
+type Member struct {
+	Name      string `yaml:"name"`      // Name of the user/machine (e.g. alice@laptop)
+	Recipient string `yaml:"recipient"` // Public key the vault key is encrypted for
+}
+
+// Registry holds the members of the vault, as stored in age_vault_recipients.yml.
+type Registry struct {
+	Members []Member `yaml:"members"`
+}
+
+// validName restricts member names to characters that are safe to use in file names.
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._@-]*$`)
+
+// ValidateName checks that name can be used as a member name, and so in file names.
+func ValidateName(name string) error {
+	if !validName.MatchString(name) {
+		return fmt.Errorf("invalid member name %q: only letters, digits, '.', '_', '@' and '-' are allowed", name)
+	}
+	return nil
+}
+
+// Load reads the registry from path.
+// A missing file is not an error, it yields an empty registry.
+// Member names are used in file names, so a registry with an invalid name is rejected.
+func Load(path string) (*Registry, error) {
+	registry := &Registry{}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return registry, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error reading recipients file %s: %w", path, err)
+	}
+
+	if err := yaml.Unmarshal(data, registry); err != nil {
+		return nil, fmt.Errorf("error parsing recipients file %s: %w", path, err)
+	}
+
+	for _, m := range registry.Members {
+		if err := ValidateName(m.Name); err != nil {
+			return nil, fmt.Errorf("error in recipients file %s: %w", path, err)
+		}
+	}
+
+	return registry, nil
+}
+
+// Save writes the registry to path, with members sorted by name.
+func (r *Registry) Save(path string) error {
+	sort.Slice(r.Members, func(i, j int) bool {
+		return r.Members[i].Name < r.Members[j].Name
+	})
+
+	data, err := yaml.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("error encoding recipients file: %w", err)
+	}
+
+	// The registry only contains public keys, so it can be world readable
+	if err := config.EnsureParentDir(path); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("error writing recipients file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// Add registers a new member. The recipient must be a single native or plugin public key;
+// comment lines (as found in public key files) are ignored.
+func (r *Registry) Add(name, recipient string) error {
+	if err := ValidateName(name); err != nil {
+		return err
+	}
+
+	if _, ok := r.Get(name); ok {
+		return fmt.Errorf("member %s already exists", name)
+	}
+
+	// Keep only the public key lines
+	var keys []string
+	for _, line := range strings.Split(recipient, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		keys = append(keys, trimmed)
+	}
+	if len(keys) != 1 {
+		return fmt.Errorf("expected exactly one public key for member %s, got %d", name, len(keys))
+	}
+
+	if _, err := keymgmt.ParseRecipients([]byte(keys[0])); err != nil {
+		return fmt.Errorf("invalid public key for member %s: %w", name, err)
+	}
+
+	r.Members = append(r.Members, Member{Name: name, Recipient: keys[0]})
+	return nil
+}
+
+// Remove unregisters a member by name.
+func (r *Registry) Remove(name string) error {
+	for i, m := range r.Members {
+		if m.Name == name {
+			r.Members = append(r.Members[:i], r.Members[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("member %s not found", name)
+}
+
+// Get returns the member with the given name.
+func (r *Registry) Get(name string) (Member, bool) {
+	for _, m := range r.Members {
+		if m.Name == name {
+			return m, true
+		}
+	}
+	return Member{}, false
+}
@@ -0,0 +1,145 @@
+package members
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+)
+
+func TestLoad_MissingFile(t *testing.T) {
+	registry, err := Load(filepath.Join(t.TempDir(), "age_vault_recipients.yml"))
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+
+	if len(registry.Members) != 0 {
+		t.Errorf("Expected empty registry, got %d members", len(registry.Members))
+	}
+}
+
+func TestLoad_InvalidName(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "age_vault_recipients.yml")
+	content := "members:\n  - name: ../../x\n    recipient: age1example\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write recipients file: %v", err)
+	}
+
+	if _, err := Load(path); err == nil {
+		t.Error("Load() should have rejected an invalid member name")
+	}
+}
+
+func TestAddSaveLoad(t *testing.T) {
+	identity1, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("GenerateX25519Identity() failed: %v", err)
+	}
+	identity2, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("GenerateX25519Identity() failed: %v", err)
+	}
+
+	registry := &Registry{}
+	if err := registry.Add("laptop", identity1.Recipient().String()); err != nil {
+		t.Fatalf("Add() failed: %v", err)
+	}
+	// Public key files may contain comments
+	if err := registry.Add("desktop", "# created by age-keygen\n"+identity2.Recipient().String()+"\n"); err != nil {
+		t.Fatalf("Add() failed: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "age_vault_recipients.yml")
+	if err := registry.Save(path); err != nil {
+		t.Fatalf("Save() failed: %v", err)
+	}
+
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+
+	if len(loaded.Members) != 2 {
+		t.Fatalf("Expected 2 members, got %d", len(loaded.Members))
+	}
+
+	// Members are saved sorted by name
+	if loaded.Members[0].Name != "desktop" || loaded.Members[1].Name != "laptop" {
+		t.Errorf("Unexpected member order: %v", loaded.Members)
+	}
+
+	desktop, ok := loaded.Get("desktop")
+	if !ok {
+		t.Fatal("Get() did not find member desktop")
+	}
+	if desktop.Recipient != identity2.Recipient().String() {
+		t.Errorf("Expected recipient %s, got %s", identity2.Recipient().String(), desktop.Recipient)
+	}
+}
+
+func TestAdd_Invalid(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("GenerateX25519Identity() failed: %v", err)
+	}
+	recipient := identity.Recipient().String()
+
+	registry := &Registry{}
+	if err := registry.Add("laptop", recipient); err != nil {
+		t.Fatalf("Add() failed: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		member    string
+		recipient string
+	}{
+		{"duplicate name", "laptop", recipient},
+		{"invalid name", "../laptop", recipient},
+		{"invalid public key", "desktop", "not-a-public-key"},
+		{"multiple public keys", "desktop", recipient + "\n" + recipient},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := registry.Add(tt.member, tt.recipient); err == nil {
+				t.Error("Add() should have failed")
+			}
+		})
+	}
+}
+
+func TestRemove(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("GenerateX25519Identity() failed: %v", err)
+	}
+
+	registry := &Registry{}
+	if err := registry.Add("laptop", identity.Recipient().String()); err != nil {
+		t.Fatalf("Add() failed: %v", err)
+	}
+
+	if err := registry.Remove("laptop"); err != nil {
+		t.Fatalf("Remove() failed: %v", err)
+	}
+	if _, ok := registry.Get("laptop"); ok {
+		t.Error("Member still present after Remove()")
+	}
+
+	if err := registry.Remove("laptop"); err == nil {
+		t.Error("Remove() should fail for unknown member")
+	}
+}
+
+func TestSave_CreatesParentDir(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sub", "age_vault_recipients.yml")
+	if err := (&Registry{}).Save(path); err != nil {
+		t.Fatalf("Save() failed: %v", err)
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("Registry file not created: %v", err)
+	}
+}
@@ -0,0 +1,280 @@
+// Package paper encodes data (a passphrase-encrypted vault key) as numbered, checksummed
+// chunks that can be printed, as text or QR codes, and typed or scanned back in any order.
+//
+// Each chunk is a single line of QR alphanumeric characters:
+//
+//	AV1 02/05 3F9A KRSX G5CT MVRX EZLU ... 1C2D3E4F
+//
+// made of the format marker, the chunk number and count, a set ID identifying the backup the
+// chunk belongs to, the base32 data in groups of four, and a CRC-32 of the rest of the line.
+package paper
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"rsc.io/qr"
+)
+
+const (
+	// marker starts every chunk line
+	marker = "AV1"
+
+	// ChunkSize is the default number of data bytes per chunk.
+	ChunkSize = 40
+
+	// maxChunks keeps chunk numbers to two digits
+	maxChunks = 99
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Chunk is one numbered piece of the encoded data.
+type Chunk struct {
+	Index int    // 1-based chunk number
+	Total int    // Number of chunks in the set
+	SetID string // Identifies the data the chunk belongs to
+	Data  []byte
+}
+
+// setID derives the set ID from the whole data, so combining can verify the result.
+func setID(data []byte) string {
+	sum := sha256.Sum256(data)
+	return strings.ToUpper(hex.EncodeToString(sum[:2]))
+}
+
+// MaxSize returns the size of the largest data Split accepts with chunks of chunkSize bytes.
+func MaxSize(chunkSize int) int {
+	return chunkSize * maxChunks
+}
+
+// Split cuts data into chunks of at most chunkSize bytes.
+func Split(data []byte, chunkSize int) ([]Chunk, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("no data to split")
+	}
+	if chunkSize <= 0 {
+		return nil, fmt.Errorf("invalid chunk size %d", chunkSize)
+	}
+
+	total := (len(data) + chunkSize - 1) / chunkSize
+	if total > maxChunks {
+		return nil, fmt.Errorf("data too large: %d chunks needed, at most %d supported", total, maxChunks)
+	}
+
+	id := setID(data)
+	chunks := make([]Chunk, 0, total)
+	for i := 0; i < total; i++ {
+		end := (i + 1) * chunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		chunks = append(chunks, Chunk{
+			Index: i + 1,
+			Total: total,
+			SetID: id,
+			Data:  data[i*chunkSize : end],
+		})
+	}
+	return chunks, nil
+}
+
+// body formats everything but the checksum.
+func (c Chunk) body() string {
+	encoded := encoding.EncodeToString(c.Data)
+	groups := make([]string, 0, len(encoded)/4+1)
+	for i := 0; i < len(encoded); i += 4 {
+		end := i + 4
+		if end > len(encoded) {
+			end = len(encoded)
+		}
+		groups = append(groups, encoded[i:end])
+	}
+	return fmt.Sprintf("%s %02d/%02d %s %s", marker, c.Index, c.Total, c.SetID, strings.Join(groups, " "))
+}
+
+// checksum computes the CRC-32 of a chunk body, ignoring spacing.
+func checksum(body string) string {
+	return fmt.Sprintf("%08X", crc32.ChecksumIEEE([]byte(strings.Join(strings.Fields(body), ""))))
+}
+
+// String formats the chunk as a printable line.
+func (c Chunk) String() string {
+	body := c.body()
+	return body + " " + checksum(body)
+}
+
+// IsChunk reports whether line looks like a chunk, so other lines of a document can be skipped.
+func IsChunk(line string) bool {
+	return strings.HasPrefix(strings.ToUpper(strings.TrimSpace(line)), marker)
+}
+
+// ParseChunk parses a chunk line, as printed or scanned. Case and extra spacing are ignored.
+func ParseChunk(line string) (Chunk, error) {
+	fields := strings.Fields(strings.ToUpper(line))
+	if len(fields) < 5 || fields[0] != marker {
+		return Chunk{}, fmt.Errorf("not a vault key chunk: %q", line)
+	}
+
+	sum := fields[len(fields)-1]
+	if checksum(strings.Join(fields[:len(fields)-1], " ")) != sum {
+		return Chunk{}, fmt.Errorf("checksum mismatch in chunk %s, check for typos", fields[1])
+	}
+
+	numbers := strings.SplitN(fields[1], "/", 2)
+	if len(numbers) != 2 {
+		return Chunk{}, fmt.Errorf("invalid chunk number %q", fields[1])
+	}
+	index, err := strconv.Atoi(numbers[0])
+	if err != nil {
+		return Chunk{}, fmt.Errorf("invalid chunk number %q", fields[1])
+	}
+	total, err := strconv.Atoi(numbers[1])
+	if err != nil || index < 1 || index > total {
+		return Chunk{}, fmt.Errorf("invalid chunk number %q", fields[1])
+	}
+
+	data, err := encoding.DecodeString(strings.Join(fields[3:len(fields)-1], ""))
+	if err != nil {
+		return Chunk{}, fmt.Errorf("invalid data in chunk %s: %w", fields[1], err)
+	}
+
+	return Chunk{Index: index, Total: total, SetID: fields[2], Data: data}, nil
+}
+
+// Combine reassembles the data from its chunks, given in any order. Duplicates are ignored.
+func Combine(chunks []Chunk) ([]byte, error) {
+	if len(chunks) == 0 {
+		return nil, fmt.Errorf("no chunks provided")
+	}
+
+	first := chunks[0]
+	byIndex := map[int]Chunk{}
+	for _, c := range chunks {
+		if c.SetID != first.SetID || c.Total != first.Total {
+			return nil, fmt.Errorf("chunk %d/%d (set %s) does not belong to the same backup as chunk %d/%d (set %s)",
+				c.Index, c.Total, c.SetID, first.Index, first.Total, first.SetID)
+		}
+		byIndex[c.Index] = c
+	}
+
+	var missing []string
+	for i := 1; i <= first.Total; i++ {
+		if _, ok := byIndex[i]; !ok {
+			missing = append(missing, strconv.Itoa(i))
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing chunk(s) %s of %d", strings.Join(missing, ", "), first.Total)
+	}
+
+	indexes := make([]int, 0, len(byIndex))
+	for i := range byIndex {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+
+	var data bytes.Buffer
+	for _, i := range indexes {
+		data.Write(byIndex[i].Data)
+	}
+
+	if setID(data.Bytes()) != first.SetID {
+		return nil, fmt.Errorf("reassembled data does not match set %s", first.SetID)
+	}
+	return data.Bytes(), nil
+}
+
+// QRCode encodes the chunk line as a QR code.
+func (c Chunk) QRCode() (*qr.Code, error) {
+	code, err := qr.Encode(c.String(), qr.M)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding chunk %d as QR code: %w", c.Index, err)
+	}
+	return code, nil
+}
+
+// PNG renders the chunk as a QR code PNG image.
+func (c Chunk) PNG() ([]byte, error) {
+	code, err := c.QRCode()
+	if err != nil {
+		return nil, err
+	}
+	return code.PNG(), nil
+}
+
+// SVG renders the chunk as a QR code SVG image, with the chunk line as a caption.
+func (c Chunk) SVG() ([]byte, error) {
+	code, err := c.QRCode()
+	if err != nil {
+		return nil, err
+	}
+
+	const scale = 8
+	const margin = 4
+	size := (code.Size + 2*margin) * scale
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+"\n", size, size+3*scale, size, size+3*scale)
+	fmt.Fprintf(&buf, `<rect width="100%%" height="100%%" fill="#fff"/>`+"\n")
+	fmt.Fprintf(&buf, `<path fill="#000" d="`)
+	for y := 0; y < code.Size; y++ {
+		for x := 0; x < code.Size; x++ {
+			if code.Black(x, y) {
+				fmt.Fprintf(&buf, "M%d %dh%dv%dh-%dz", (x+margin)*scale, (y+margin)*scale, scale, scale, scale)
+			}
+		}
+	}
+	fmt.Fprintf(&buf, "\"/>\n")
+	fmt.Fprintf(&buf, `<text x="%d" y="%d" font-family="monospace" font-size="%d" text-anchor="middle">Chunk %d of %d (set %s)</text>`+"\n",
+		size/2, size+scale, 2*scale, c.Index, c.Total, c.SetID)
+	fmt.Fprintf(&buf, "</svg>\n")
+	return buf.Bytes(), nil
+}
+
+// WriteText writes a printable text document listing every chunk, preceded by a title
+// and instructions.
+func WriteText(w io.Writer, title string, instructions []string, chunks []Chunk) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s\n%s\n\n", title, strings.Repeat("=", len(title)))
+	for _, line := range instructions {
+		fmt.Fprintf(&buf, "%s\n", line)
+	}
+	if len(instructions) > 0 {
+		fmt.Fprintf(&buf, "\n")
+	}
+	for _, c := range chunks {
+		fmt.Fprintf(&buf, "%s\n", c.String())
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// ReadChunks parses every chunk line found in r, skipping any other line.
+func ReadChunks(r io.Reader) ([]Chunk, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("error reading chunks: %w", err)
+	}
+
+	var chunks []Chunk
+	for _, line := range strings.Split(string(data), "\n") {
+		if !IsChunk(line) {
+			continue
+		}
+		c, err := ParseChunk(line)
+		if err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, c)
+	}
+	return chunks, nil
+}
@@ -0,0 +1,157 @@
+package paper
+
+import (
+	"bytes"
+	"crypto/rand"
+	"strings"
+	"testing"
+)
+
+func randomData(t *testing.T, n int) []byte {
+	t.Helper()
+
+	data := make([]byte, n)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatalf("failed to generate data: %v", err)
+	}
+	return data
+}
+
+func TestSplitCombine(t *testing.T) {
+	data := randomData(t, 250)
+
+	chunks, err := Split(data, ChunkSize)
+	if err != nil {
+		t.Fatalf("Split failed: %v", err)
+	}
+	if len(chunks) != 7 {
+		t.Fatalf("expected 7 chunks, got %d", len(chunks))
+	}
+
+	// Print, parse back in reverse order with a duplicate
+	var parsed []Chunk
+	for i := len(chunks) - 1; i >= 0; i-- {
+		c, err := ParseChunk(chunks[i].String())
+		if err != nil {
+			t.Fatalf("ParseChunk failed: %v", err)
+		}
+		parsed = append(parsed, c)
+	}
+	parsed = append(parsed, parsed[0])
+
+	combined, err := Combine(parsed)
+	if err != nil {
+		t.Fatalf("Combine failed: %v", err)
+	}
+	if !bytes.Equal(combined, data) {
+		t.Error("combined data differs from the original")
+	}
+}
+
+func TestParseChunk_TypedInput(t *testing.T) {
+	chunks, err := Split(randomData(t, 40), ChunkSize)
+	if err != nil {
+		t.Fatalf("Split failed: %v", err)
+	}
+	line := chunks[0].String()
+
+	// Lowercase with extra spaces is accepted
+	typed := "  " + strings.ToLower(strings.ReplaceAll(line, " ", "  ")) + " "
+	c, err := ParseChunk(typed)
+	if err != nil {
+		t.Fatalf("ParseChunk failed on typed input: %v", err)
+	}
+	if !bytes.Equal(c.Data, chunks[0].Data) {
+		t.Error("parsed data differs")
+	}
+
+	// A typo is caught by the checksum
+	fields := strings.Fields(line)
+	group := []byte(fields[3])
+	if group[0] == 'A' {
+		group[0] = 'B'
+	} else {
+		group[0] = 'A'
+	}
+	fields[3] = string(group)
+	if _, err := ParseChunk(strings.Join(fields, " ")); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("expected checksum error, got: %v", err)
+	}
+}
+
+func TestCombine_Errors(t *testing.T) {
+	a, err := Split(randomData(t, 100), ChunkSize)
+	if err != nil {
+		t.Fatalf("Split failed: %v", err)
+	}
+	b, err := Split(randomData(t, 100), ChunkSize)
+	if err != nil {
+		t.Fatalf("Split failed: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		chunks  []Chunk
+		wantErr string
+	}{
+		{"empty", nil, "no chunks"},
+		{"missing", []Chunk{a[0], a[2]}, "missing chunk(s) 2"},
+		{"mixed sets", []Chunk{a[0], b[1], a[2]}, "same backup"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Combine(tt.chunks)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got: %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestWriteReadText(t *testing.T) {
+	data := randomData(t, 120)
+	chunks, err := Split(data, ChunkSize)
+	if err != nil {
+		t.Fatalf("Split failed: %v", err)
+	}
+
+	var doc bytes.Buffer
+	if err := WriteText(&doc, "Vault key backup", []string{"Keep this safe."}, chunks); err != nil {
+		t.Fatalf("WriteText failed: %v", err)
+	}
+
+	read, err := ReadChunks(&doc)
+	if err != nil {
+		t.Fatalf("ReadChunks failed: %v", err)
+	}
+	combined, err := Combine(read)
+	if err != nil {
+		t.Fatalf("Combine failed: %v", err)
+	}
+	if !bytes.Equal(combined, data) {
+		t.Error("data read back from the document differs")
+	}
+}
+
+func TestQRCodes(t *testing.T) {
+	chunks, err := Split(randomData(t, ChunkSize), ChunkSize)
+	if err != nil {
+		t.Fatalf("Split failed: %v", err)
+	}
+
+	png, err := chunks[0].PNG()
+	if err != nil {
+		t.Fatalf("PNG failed: %v", err)
+	}
+	if !bytes.HasPrefix(png, []byte("\x89PNG")) {
+		t.Error("PNG output is not a PNG image")
+	}
+
+	svg, err := chunks[0].SVG()
+	if err != nil {
+		t.Fatalf("SVG failed: %v", err)
+	}
+	if !bytes.HasPrefix(svg, []byte("<svg")) {
+		t.Error("SVG output is not an SVG image")
+	}
+}
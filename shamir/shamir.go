@@ -0,0 +1,135 @@
+// Package shamir implements Shamir's secret sharing over GF(2^8).
+// A secret is split into n shares so that any k of them reconstruct it, while fewer than k
+// reveal nothing about it. Each byte of the secret is shared independently with its own
+// random polynomial of degree k-1.
+package shamir
+
+import (
+	"crypto/rand"
+	"fmt"
+)
+
+// Share is one share of a secret. X is the evaluation point (never 0), Y holds one
+// polynomial evaluation per byte of the secret.
+type Share struct {
+	X byte
+	Y []byte
+}
+
+// gfMul multiplies two elements of GF(2^8) modulo the AES polynomial x^8+x^4+x^3+x+1.
+// It runs in constant time.
+func gfMul(a, b byte) byte {
+	var p byte
+	for i := 0; i < 8; i++ {
+		mask := -(b & 1)
+		p ^= a & mask
+		carry := -(a >> 7)
+		a = (a << 1) ^ (0x1b & carry)
+		b >>= 1
+	}
+	return p
+}
+
+// gfInv returns the multiplicative inverse of a in GF(2^8), computed as a^254.
+// The inverse of 0 is 0.
+func gfInv(a byte) byte {
+	result := byte(1)
+	base := a
+	for e := 254; e > 0; e >>= 1 {
+		if e&1 == 1 {
+			result = gfMul(result, base)
+		}
+		base = gfMul(base, base)
+	}
+	return result
+}
+
+// Split divides secret into n shares, any k of which reconstruct it.
+func Split(secret []byte, n, k int) ([]Share, error) {
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("secret is empty")
+	}
+	if k < 2 {
+		return nil, fmt.Errorf("threshold must be at least 2, got %d", k)
+	}
+	if n < k {
+		return nil, fmt.Errorf("number of shares (%d) must be at least the threshold (%d)", n, k)
+	}
+	if n > 255 {
+		return nil, fmt.Errorf("at most 255 shares are supported, got %d", n)
+	}
+
+	shares := make([]Share, n)
+	for i := range shares {
+		shares[i] = Share{X: byte(i + 1), Y: make([]byte, len(secret))}
+	}
+
+	coefficients := make([]byte, k)
+	for b, secretByte := range secret {
+		// Random polynomial with the secret byte as constant term
+		coefficients[0] = secretByte
+		if _, err := rand.Read(coefficients[1:]); err != nil {
+			return nil, fmt.Errorf("error generating random coefficients: %w", err)
+		}
+
+		for i := range shares {
+			// Horner evaluation at x
+			x := shares[i].X
+			var y byte
+			for c := k - 1; c >= 0; c-- {
+				y = gfMul(y, x) ^ coefficients[c]
+			}
+			shares[i].Y[b] = y
+		}
+	}
+
+	// Don't leave the coefficients of the last byte around
+	for i := range coefficients {
+		coefficients[i] = 0
+	}
+
+	return shares, nil
+}
+
+// Combine reconstructs the secret from at least threshold shares, using Lagrange
+// interpolation at x = 0. With fewer shares than the threshold the result is garbage,
+// so callers should verify it.
+func Combine(shares []Share) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, fmt.Errorf("at least 2 shares are required, got %d", len(shares))
+	}
+
+	length := len(shares[0].Y)
+	seen := map[byte]bool{}
+	for _, s := range shares {
+		if s.X == 0 {
+			return nil, fmt.Errorf("invalid share with x = 0")
+		}
+		if seen[s.X] {
+			return nil, fmt.Errorf("duplicate share %d", s.X)
+		}
+		seen[s.X] = true
+		if len(s.Y) != length {
+			return nil, fmt.Errorf("shares have different lengths")
+		}
+	}
+
+	secret := make([]byte, length)
+	for i, si := range shares {
+		// Lagrange basis polynomial for share i evaluated at 0:
+		// prod over j != i of x_j / (x_j - x_i), where subtraction is XOR in GF(2^8)
+		basis := byte(1)
+		for j, sj := range shares {
+			if i == j {
+				continue
+			}
+			basis = gfMul(basis, gfMul(sj.X, gfInv(sj.X^si.X)))
+		}
+
+		for b := range secret {
+			secret[b] ^= gfMul(si.Y[b], basis)
+		}
+	}
+
+	return secret, nil
+}
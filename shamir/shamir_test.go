@@ -0,0 +1,89 @@
+package shamir
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestGFInverse(t *testing.T) {
+	for a := 1; a < 256; a++ {
+		if got := gfMul(byte(a), gfInv(byte(a))); got != 1 {
+			t.Fatalf("%d * inv(%d) = %d, want 1", a, a, got)
+		}
+	}
+}
+
+func TestSplitCombine(t *testing.T) {
+	secret := []byte("AGE-SECRET-KEY-1EXAMPLEEXAMPLEEXAMPLE\n")
+
+	shares, err := Split(secret, 5, 3)
+	if err != nil {
+		t.Fatalf("Split failed: %v", err)
+	}
+	if len(shares) != 5 {
+		t.Fatalf("expected 5 shares, got %d", len(shares))
+	}
+
+	// Every combination of 3 shares works
+	for a := 0; a < 5; a++ {
+		for b := a + 1; b < 5; b++ {
+			for c := b + 1; c < 5; c++ {
+				combined, err := Combine([]Share{shares[c], shares[a], shares[b]})
+				if err != nil {
+					t.Fatalf("Combine failed: %v", err)
+				}
+				if !bytes.Equal(combined, secret) {
+					t.Fatalf("shares %d,%d,%d reconstructed a different secret", a, b, c)
+				}
+			}
+		}
+	}
+
+	// All shares work too
+	combined, err := Combine(shares)
+	if err != nil {
+		t.Fatalf("Combine failed: %v", err)
+	}
+	if !bytes.Equal(combined, secret) {
+		t.Error("all shares reconstructed a different secret")
+	}
+
+	// Below the threshold the secret is not recovered
+	combined, err = Combine(shares[:2])
+	if err != nil {
+		t.Fatalf("Combine failed: %v", err)
+	}
+	if bytes.Equal(combined, secret) {
+		t.Error("secret recovered with fewer shares than the threshold")
+	}
+}
+
+func TestSplit_InvalidParameters(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret []byte
+		n, k   int
+	}{
+		{"empty secret", nil, 3, 2},
+		{"threshold too low", []byte("s"), 3, 1},
+		{"fewer shares than threshold", []byte("s"), 2, 3},
+		{"too many shares", []byte("s"), 256, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Split(tt.secret, tt.n, tt.k); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestCombine_Duplicates(t *testing.T) {
+	shares, err := Split([]byte("secret"), 3, 2)
+	if err != nil {
+		t.Fatalf("Split failed: %v", err)
+	}
+	if _, err := Combine([]Share{shares[0], shares[0]}); err == nil {
+		t.Error("expected error with duplicate shares")
+	}
+}
@@ -5,14 +5,12 @@ package sshagent
 import (
 	"bytes"
 	"fmt"
-	"io"
 	"net"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"syscall"
 
-	"filippo.io/age"
 	"github.com/leolimasa/age-vault/vault"
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/agent"
@@ -71,19 +69,9 @@ func (a *VaultSSHAgent) loadKey(keyPath string) error {
 	}
 
 	// Decrypt the key using the vault key
-	vaultKeyIdentity, err := a.vaultKey.GetIdentity()
-	if err != nil {
-		return fmt.Errorf("error getting vault key identity: %w", err)
-	}
-
-	r, err := age.Decrypt(bytes.NewReader(encryptedKey), vaultKeyIdentity)
-	if err != nil {
-		return fmt.Errorf("error decrypting key: %w", err)
-	}
-
 	var decryptedKey bytes.Buffer
-	if _, err := io.Copy(&decryptedKey, r); err != nil {
-		return fmt.Errorf("error reading decrypted key: %w", err)
+	if err := a.vaultKey.Decrypt(bytes.NewReader(encryptedKey), &decryptedKey); err != nil {
+		return fmt.Errorf("error decrypting key: %w", err)
 	}
 
 	// Parse the SSH private key
@@ -0,0 +1,125 @@
+package vault
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EnvelopeVersion is the first line of vault key files in the current envelope format.
+//
+// The envelope is a short text header ahead of the encrypted vault key, so the vault and
+// key generation a file belongs to can be told without decrypting it:
+//
+//	age-vault-key/v1
+//	vault-id: 1a2b3c4d5e6f7a8b9c0d
+//	generation: 2
+//	created: 2024-05-01T12:00:00Z
+//	label: alice@laptop
+//
+//	age-encryption.org/v1
+//	...
+//
+// The header is not authenticated: it describes the file, the age payload decides who can
+// open it. Files without an envelope are raw age files, as written by older versions.
+const EnvelopeVersion = "age-vault-key/v1"
+
+// envelopePrefix starts every version of the envelope.
+const envelopePrefix = "age-vault-key/"
+
+// Envelope is the metadata of a vault key file, readable without decrypting it.
+type Envelope struct {
+	VaultID    string    // ID of the vault (see VaultKey.ID)
+	Generation int       // Generation of the current vault key (see VaultKey.Generation)
+	Created    time.Time // When the file was written
+	Label      string    // Who the file is for, e.g. a member name or hostname
+}
+
+// NewEnvelope returns the envelope of a vault key file written now for the recipient
+// described by label.
+func NewEnvelope(vaultKey *VaultKey, label string) Envelope {
+	return Envelope{
+		VaultID:    vaultKey.ID(),
+		Generation: vaultKey.Generation(),
+		Created:    time.Now().UTC().Truncate(time.Second),
+		Label:      label,
+	}
+}
+
+// Seal returns the vault key file made of the envelope followed by the encrypted vault key.
+func (e Envelope) Seal(encryptedKey []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(EnvelopeVersion + "\n")
+	writeField := func(key, value string) {
+		// Values are single line
+		value = strings.Join(strings.Fields(value), " ")
+		if value != "" {
+			buf.WriteString(key + ": " + value + "\n")
+		}
+	}
+	writeField("vault-id", e.VaultID)
+	if e.Generation > 0 {
+		writeField("generation", strconv.Itoa(e.Generation))
+	}
+	if !e.Created.IsZero() {
+		writeField("created", e.Created.Format(time.RFC3339))
+	}
+	writeField("label", e.Label)
+	buf.WriteString("\n")
+	buf.Write(encryptedKey)
+	return buf.Bytes()
+}
+
+// IsEnvelope reports whether data is a vault key file with an envelope, of any version.
+func IsEnvelope(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(envelopePrefix))
+}
+
+// OpenEnvelope splits a vault key file into its envelope and the encrypted vault key.
+// Files without an envelope are returned as is, with a nil envelope.
+func OpenEnvelope(data []byte) (*Envelope, []byte, error) {
+	if !IsEnvelope(data) {
+		return nil, data, nil
+	}
+
+	header, payload, found := bytes.Cut(data, []byte("\n\n"))
+	if !found {
+		return nil, nil, fmt.Errorf("malformed vault key envelope: missing end of header")
+	}
+	lines := strings.Split(string(header), "\n")
+	if strings.TrimSpace(lines[0]) != EnvelopeVersion {
+		return nil, nil, fmt.Errorf("unsupported vault key file version %q", strings.TrimSpace(lines[0]))
+	}
+
+	// Unknown fields are ignored, so later versions can add some
+	envelope := &Envelope{}
+	for _, line := range lines[1:] {
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			return nil, nil, fmt.Errorf("malformed vault key envelope line %q", line)
+		}
+		value = strings.TrimSpace(value)
+		switch strings.TrimSpace(key) {
+		case "vault-id":
+			envelope.VaultID = value
+		case "generation":
+			generation, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, nil, fmt.Errorf("malformed vault key generation %q", value)
+			}
+			envelope.Generation = generation
+		case "created":
+			created, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return nil, nil, fmt.Errorf("malformed vault key creation time %q", value)
+			}
+			envelope.Created = created
+		case "label":
+			envelope.Label = value
+		}
+	}
+
+	return envelope, payload, nil
+}
@@ -0,0 +1,27 @@
+package vault
+
+import (
+	"filippo.io/age"
+)
+
+// EscrowStanzaType is the stanza type of the markers recording which escrow recipients a
+// vault key was encrypted to.
+//
+// The stanzas of most recipients (e.g. X25519) do not reveal who they are for, so an
+// escrow recipient adds a marker with its ID next to its own stanza. Markers carry no key
+// material and are ignored by identities, including the age CLI.
+const EscrowStanzaType = "age-vault-escrow"
+
+// NewEscrowRecipient returns a recipient that encrypts to recipient and records id in the
+// header, so EscrowIDs can later tell that the vault key was encrypted to it.
+func NewEscrowRecipient(recipient age.Recipient, id string) age.Recipient {
+	return &markerRecipient{
+		recipient: recipient,
+		marker:    &age.Stanza{Type: EscrowStanzaType, Args: []string{id}},
+	}
+}
+
+// EscrowIDs returns the IDs of the escrow recipients an encrypted vault key was encrypted to.
+func EscrowIDs(encryptedKey []byte) []string {
+	return markerArgs(encryptedKey, EscrowStanzaType)
+}
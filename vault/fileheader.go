@@ -0,0 +1,193 @@
+package vault
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"filippo.io/age"
+)
+
+// FileHeaderVersion is the first line of files encrypted with a metadata header.
+//
+// The header is a short text block ahead of the age stream:
+//
+//	age-vault-file/v1
+//	vault-id: 1a2b3c4d5e6f7a8b9c0d
+//	generation: 2
+//	content-type: text/plain; charset=utf-8
+//	filename: secrets.env
+//
+//	age-encryption.org/v1
+//	-> X25519 ...
+//	-> age-vault-header 3q2+7w...
+//	--- ...
+//
+// The age header carries a hash of the metadata header in an age-vault-header stanza.
+// age authenticates its header with the file key, so the metadata cannot be changed without
+// decryption failing. Stripping the metadata header leaves a regular age file.
+const FileHeaderVersion = "age-vault-file/v1"
+
+// HeaderStanzaType is the stanza type of the hash binding a metadata header to the age stream.
+const HeaderStanzaType = "age-vault-header"
+
+// fileHeaderPrefix starts every version of the metadata header.
+const fileHeaderPrefix = "age-vault-file/"
+
+// MaxHeaderSize bounds the metadata and age headers read ahead of the encrypted payload.
+const MaxHeaderSize = 64 * 1024
+
+// FileHeader is the metadata header of an encrypted file, readable without decrypting it.
+type FileHeader struct {
+	VaultID     string // ID of the vault whose key encrypted the file (see VaultKey.ID)
+	Generation  int    // Generation of that key (see VaultKey.Generation)
+	ContentType string // MIME type of the plaintext
+	Filename    string // Original file name of the plaintext
+}
+
+// marshal serializes the header, including the blank line ending it.
+func (h *FileHeader) marshal() []byte {
+	var buf bytes.Buffer
+	buf.WriteString(FileHeaderVersion + "\n")
+	writeField := func(key, value string) {
+		// Values are single line
+		value = strings.Join(strings.Fields(value), " ")
+		if value != "" {
+			buf.WriteString(key + ": " + value + "\n")
+		}
+	}
+	writeField("vault-id", h.VaultID)
+	if h.Generation > 0 {
+		writeField("generation", strconv.Itoa(h.Generation))
+	}
+	writeField("content-type", h.ContentType)
+	writeField("filename", h.Filename)
+	buf.WriteString("\n")
+	return buf.Bytes()
+}
+
+// headerHash returns the hash of a serialized header, as stored in the age-vault-header stanza.
+func headerHash(header []byte) string {
+	sum := sha256.Sum256(header)
+	return base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// HasFileHeader reports whether data starts with a metadata header, of any version.
+func HasFileHeader(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(fileHeaderPrefix))
+}
+
+// ParseFileHeader splits an encrypted file into its metadata header and the age file that
+// follows. Files without one are returned as is, with a nil header. The header is not
+// verified against the age stream: only Decrypt does that.
+func ParseFileHeader(data []byte) (*FileHeader, []byte, error) {
+	header, length, err := splitFileHeader(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return header, data[length:], nil
+}
+
+// splitFileHeader parses the metadata header at the start of data and returns it with its
+// length. Data without a metadata header returns a nil header.
+func splitFileHeader(data []byte) (*FileHeader, int, error) {
+	if !HasFileHeader(data) {
+		return nil, 0, nil
+	}
+
+	end := bytes.Index(data, []byte("\n\n"))
+	if end < 0 {
+		return nil, 0, fmt.Errorf("malformed file header: missing end of header")
+	}
+	lines := strings.Split(string(data[:end]), "\n")
+	if strings.TrimSpace(lines[0]) != FileHeaderVersion {
+		return nil, 0, fmt.Errorf("unsupported file header version %q", strings.TrimSpace(lines[0]))
+	}
+
+	// Unknown fields are ignored, so later versions can add some
+	header := &FileHeader{}
+	for _, line := range lines[1:] {
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			return nil, 0, fmt.Errorf("malformed file header line %q", line)
+		}
+		value = strings.TrimSpace(value)
+		switch strings.TrimSpace(key) {
+		case "vault-id":
+			header.VaultID = value
+		case "generation":
+			generation, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, 0, fmt.Errorf("malformed key generation %q", value)
+			}
+			header.Generation = generation
+		case "content-type":
+			header.ContentType = value
+		case "filename":
+			header.Filename = value
+		}
+	}
+
+	return header, end + 2, nil
+}
+
+// WithFileHeader makes VaultKey.Encrypt write a metadata header recording the vault ID and
+// key generation, and the given content type and original file name (both optional).
+func WithFileHeader(contentType, filename string) EncryptOption {
+	return func(opts *encryptOptions) {
+		opts.header = &FileHeader{ContentType: contentType, Filename: filename}
+	}
+}
+
+// readFileHeader consumes the metadata header at the start of r, if any, and checks it against
+// the hash in the age header that follows. The age header is authenticated when decrypting.
+// r must buffer at least MaxHeaderSize bytes.
+func readFileHeader(r *bufio.Reader) (*FileHeader, error) {
+	if prefix, _ := r.Peek(len(fileHeaderPrefix)); !HasFileHeader(prefix) {
+		return nil, nil
+	}
+
+	// Read ahead up to the end of the age header, r must be able to buffer MaxHeaderSize
+	peeked, _ := r.Peek(MaxHeaderSize)
+	header, length, err := splitFileHeader(peeked)
+	if err != nil || header == nil {
+		return nil, err
+	}
+
+	hashes := markerArgs(peeked[length:], HeaderStanzaType)
+	if len(hashes) != 1 || hashes[0] != headerHash(peeked[:length]) {
+		return nil, fmt.Errorf("file header does not match the encrypted data (modified header?)")
+	}
+
+	if _, err := r.Discard(length); err != nil {
+		return nil, fmt.Errorf("error reading file header: %w", err)
+	}
+	return header, nil
+}
+
+// identityFor returns the key of the keyring matching the vault and generation of a file
+// header, with a precise error if the keyring cannot decrypt the file.
+func (vk *VaultKey) identityFor(header *FileHeader) (age.Identity, error) {
+	if header.VaultID != "" && header.VaultID != vk.ID() {
+		return nil, fmt.Errorf("file belongs to vault %s, not to this vault (%s)", header.VaultID, vk.ID())
+	}
+	if header.Generation <= 0 {
+		return nil, fmt.Errorf("file header has no key generation")
+	}
+
+	current := vk.Generation()
+	if header.Generation > current {
+		return nil, fmt.Errorf("file is encrypted with key generation %d, newer than the current vault key (generation %d), update your vault key", header.Generation, current)
+	}
+	generations := vk.KeyGenerations()
+	for i, generation := range generations {
+		if generation == header.Generation {
+			return vk.identities[i], nil
+		}
+	}
+	return nil, fmt.Errorf("file is encrypted with key generation %d, which is no longer in the keyring (oldest: generation %d)", header.Generation, generations[len(generations)-1])
+}
@@ -0,0 +1,121 @@
+package vault
+
+import (
+	"fmt"
+
+	"filippo.io/age"
+)
+
+// KeyType is the kind of age identity used as a vault key.
+type KeyType string
+
+const (
+	// KeyTypeX25519 is a classic age X25519 identity (AGE-SECRET-KEY-1...).
+	KeyTypeX25519 KeyType = "x25519"
+	// KeyTypeHybrid is a post-quantum ML-KEM-768 + X25519 hybrid identity
+	// (AGE-SECRET-KEY-PQ-1...). Data encrypted to it stays safe even if the ciphertext is
+	// stored now and attacked later with a quantum computer.
+	KeyTypeHybrid KeyType = "hybrid"
+)
+
+// ParseKeyType parses a key type name, as given on the command line.
+func ParseKeyType(s string) (KeyType, error) {
+	switch KeyType(s) {
+	case KeyTypeX25519, KeyTypeHybrid:
+		return KeyType(s), nil
+	default:
+		return "", fmt.Errorf("unknown vault key type %q (expected %s or %s)", s, KeyTypeX25519, KeyTypeHybrid)
+	}
+}
+
+// GenerateVaultKeyOfType generates a new age identity of the given type to serve as the vault key.
+func GenerateVaultKeyOfType(keyType KeyType) (age.Identity, error) {
+	switch keyType {
+	case KeyTypeX25519:
+		identity, err := age.GenerateX25519Identity()
+		if err != nil {
+			return nil, fmt.Errorf("error generating vault key: %w", err)
+		}
+		return identity, nil
+	case KeyTypeHybrid:
+		identity, err := age.GenerateHybridIdentity()
+		if err != nil {
+			return nil, fmt.Errorf("error generating vault key: %w", err)
+		}
+		return identity, nil
+	default:
+		return nil, fmt.Errorf("unknown vault key type %q", keyType)
+	}
+}
+
+// KeyTypeOf returns the type of a vault key identity.
+func KeyTypeOf(identity age.Identity) (KeyType, error) {
+	switch identity.(type) {
+	case *age.X25519Identity:
+		return KeyTypeX25519, nil
+	case *age.HybridIdentity:
+		return KeyTypeHybrid, nil
+	default:
+		return "", fmt.Errorf("unsupported vault key type: %T", identity)
+	}
+}
+
+// IdentityString returns the age encoding of a vault key identity (its private key).
+func IdentityString(identity age.Identity) (string, error) {
+	switch id := identity.(type) {
+	case *age.X25519Identity:
+		return id.String(), nil
+	case *age.HybridIdentity:
+		return id.String(), nil
+	default:
+		return "", fmt.Errorf("unsupported vault key type: %T", identity)
+	}
+}
+
+// RecipientOf returns the recipient (public key) of a vault key identity and its encoding.
+func RecipientOf(identity age.Identity) (age.Recipient, string, error) {
+	switch id := identity.(type) {
+	case *age.X25519Identity:
+		recipient := id.Recipient()
+		return recipient, recipient.String(), nil
+	case *age.HybridIdentity:
+		recipient := id.Recipient()
+		return recipient, recipient.String(), nil
+	default:
+		return nil, "", fmt.Errorf("unsupported vault key type: %T", identity)
+	}
+}
+
+// IsPostQuantum reports whether a recipient is post-quantum, looking through the escrow and
+// sub-vault markers added by age-vault.
+func IsPostQuantum(recipient age.Recipient) bool {
+	switch r := recipient.(type) {
+	case *age.HybridRecipient:
+		return true
+	case *markerRecipient:
+		return IsPostQuantum(r.recipient)
+	default:
+		return false
+	}
+}
+
+// CheckRecipientKinds checks that recipients can be encrypted to together. age refuses to
+// mix post-quantum recipients with classic ones, which would defeat their post-quantum
+// security. Recovery passphrases go with either kind.
+func CheckRecipientKinds(recipients []age.Recipient) error {
+	postQuantum, classic := 0, 0
+	for _, r := range recipients {
+		if _, ok := r.(*passphraseRecipient); ok {
+			continue
+		}
+		if IsPostQuantum(r) {
+			postQuantum++
+		} else {
+			classic++
+		}
+	}
+	if postQuantum > 0 && classic > 0 {
+		return fmt.Errorf("cannot encrypt to %d post-quantum (hybrid) and %d classic recipient(s) together, use recipients of a single kind", postQuantum, classic)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package vault
+
+import (
+	"filippo.io/age"
+)
+
+// markerRecipient wraps file keys for a recipient and adds a marker stanza to the header.
+// Markers carry no key material and are ignored by identities, including the age CLI.
+type markerRecipient struct {
+	recipient age.Recipient
+	marker    *age.Stanza
+}
+
+// Wrap implements age.Recipient.
+func (r *markerRecipient) Wrap(fileKey []byte) ([]*age.Stanza, error) {
+	stanzas, err := r.recipient.Wrap(fileKey)
+	if err != nil {
+		return nil, err
+	}
+	return append(stanzas, r.marker), nil
+}
+
+// WrapWithLabels implements age.RecipientWithLabels, passing on the labels of the
+// underlying recipient.
+func (r *markerRecipient) WrapWithLabels(fileKey []byte) ([]*age.Stanza, []string, error) {
+	withLabels, ok := r.recipient.(age.RecipientWithLabels)
+	if !ok {
+		stanzas, err := r.Wrap(fileKey)
+		return stanzas, nil, err
+	}
+	stanzas, labels, err := withLabels.WrapWithLabels(fileKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return append(stanzas, r.marker), labels, nil
+}
+
+// markerArgs returns the arguments of the marker stanzas of type stanzaType in the header
+// of an encrypted file.
+func markerArgs(encrypted []byte, stanzaType string) []string {
+	var args []string
+	for _, stanza := range headerStanzas(encrypted) {
+		if stanza[0] == stanzaType && len(stanza) == 2 {
+			args = append(args, stanza[1])
+		}
+	}
+	return args
+}
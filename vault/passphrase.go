@@ -0,0 +1,157 @@
+package vault
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"filippo.io/age"
+)
+
+// PassphraseStanzaType is the stanza type of recovery passphrases in vault key files.
+//
+// age refuses to mix its scrypt stanzas with other recipients, because a passphrase
+// normally guarantees that the file was encrypted by whoever knows it. A vault key file
+// does not rely on that property: it is only ever written by members of the vault. The
+// recovery passphrase therefore uses the same scrypt construction under a different
+// stanza type, so it can sit next to the machine's own (e.g. hardware) recipient.
+const PassphraseStanzaType = "age-vault-scrypt"
+
+// passphraseRecipient wraps file keys with a passphrase, as an age-vault-scrypt stanza.
+type passphraseRecipient struct {
+	scrypt      *age.ScryptRecipient
+	postQuantum bool // Labeled like the post-quantum recipients it is encrypted next to
+}
+
+// NewPassphraseRecipient returns a recipient that encrypts to a recovery passphrase and,
+// unlike age.ScryptRecipient, can be combined with other recipients.
+func NewPassphraseRecipient(passphrase string) (age.Recipient, error) {
+	r, err := age.NewScryptRecipient(passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("error creating passphrase recipient: %w", err)
+	}
+	return &passphraseRecipient{scrypt: r}, nil
+}
+
+// Wrap implements age.Recipient.
+func (r *passphraseRecipient) Wrap(fileKey []byte) ([]*age.Stanza, error) {
+	stanzas, err := r.scrypt.Wrap(fileKey)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range stanzas {
+		s.Type = PassphraseStanzaType
+	}
+	return stanzas, nil
+}
+
+// WrapWithLabels implements age.RecipientWithLabels. scrypt is symmetric, so a recovery
+// passphrase does not weaken post-quantum recipients and takes on their label when encrypted
+// next to them (see withPassphraseLabels).
+func (r *passphraseRecipient) WrapWithLabels(fileKey []byte) ([]*age.Stanza, []string, error) {
+	stanzas, err := r.Wrap(fileKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	if r.postQuantum {
+		return stanzas, []string{postQuantumLabel}, nil
+	}
+	return stanzas, nil, nil
+}
+
+// postQuantumLabel is the label of post-quantum recipients (see age.RecipientWithLabels).
+const postQuantumLabel = "postquantum"
+
+// withPassphraseLabels returns recipients with their recovery passphrases labeled
+// post-quantum if the other recipients are, so age accepts them together.
+func withPassphraseLabels(recipients []age.Recipient) []age.Recipient {
+	postQuantum := false
+	for _, r := range recipients {
+		if IsPostQuantum(r) {
+			postQuantum = true
+		}
+	}
+	if !postQuantum {
+		return recipients
+	}
+
+	labeled := make([]age.Recipient, len(recipients))
+	for i, r := range recipients {
+		if p, ok := r.(*passphraseRecipient); ok {
+			r = &passphraseRecipient{scrypt: p.scrypt, postQuantum: true}
+		}
+		labeled[i] = r
+	}
+	return labeled
+}
+
+// passphraseIdentity unwraps age-vault-scrypt stanzas with a passphrase.
+type passphraseIdentity struct {
+	scrypt *age.ScryptIdentity
+}
+
+// NewPassphraseIdentity returns an identity that decrypts vault keys encrypted to a
+// recovery passphrase with NewPassphraseRecipient.
+func NewPassphraseIdentity(passphrase string) (age.Identity, error) {
+	i, err := age.NewScryptIdentity(passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("error creating passphrase identity: %w", err)
+	}
+	return &passphraseIdentity{scrypt: i}, nil
+}
+
+// Unwrap implements age.Identity.
+func (i *passphraseIdentity) Unwrap(stanzas []*age.Stanza) ([]byte, error) {
+	for _, s := range stanzas {
+		if s.Type != PassphraseStanzaType {
+			continue
+		}
+		// Present the stanza to the scrypt identity on its own, under its original type
+		scryptStanza := &age.Stanza{Type: "scrypt", Args: s.Args, Body: s.Body}
+		fileKey, err := i.scrypt.Unwrap([]*age.Stanza{scryptStanza})
+		if err == nil {
+			return fileKey, nil
+		}
+		if !errors.Is(err, age.ErrIncorrectIdentity) {
+			return nil, err
+		}
+	}
+	return nil, age.ErrIncorrectIdentity
+}
+
+// HasPassphraseStanza reports whether an encrypted vault key can be decrypted with a
+// recovery passphrase.
+func HasPassphraseStanza(encryptedKey []byte) bool {
+	for _, stanza := range headerStanzas(encryptedKey) {
+		if stanza[0] == PassphraseStanzaType {
+			return true
+		}
+	}
+	return false
+}
+
+// headerStanzas returns the type and arguments of every stanza in the header of an
+// encrypted vault key, without decrypting it.
+func headerStanzas(encryptedKey []byte) [][]string {
+	if _, payload, err := OpenEnvelope(encryptedKey); err == nil {
+		encryptedKey = payload
+	}
+
+	var stanzas [][]string
+	scanner := bufio.NewScanner(bytes.NewReader(encryptedKey))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "---") {
+			break
+		}
+		if !strings.HasPrefix(line, "-> ") {
+			continue
+		}
+		if fields := strings.Fields(strings.TrimPrefix(line, "-> ")); len(fields) > 0 {
+			stanzas = append(stanzas, fields)
+		}
+	}
+	return stanzas
+}
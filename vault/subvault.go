@@ -0,0 +1,54 @@
+package vault
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// SubvaultStanzaType is the stanza type of the marker naming the sub-vault whose key
+// encrypted a file.
+//
+// A sub-vault has its own vault key, encrypted to selected members and optionally to the
+// key of its parent (the vault key, or the key of the parent sub-vault). Data encrypted with
+// a sub-vault key carries the marker, so it can be decrypted without naming the sub-vault.
+const SubvaultStanzaType = "age-vault-subvault"
+
+// subvaultNamePattern matches a sub-vault name: path segments separated by "/", each
+// naming a child of the previous one (e.g. "prod/db").
+var subvaultNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+(/[A-Za-z0-9_-]+)*$`)
+
+// ValidateSubvaultName checks that name is a valid sub-vault name.
+func ValidateSubvaultName(name string) error {
+	if !subvaultNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid sub-vault name %q (use letters, digits, - and _, with / separating nested sub-vaults)", name)
+	}
+	return nil
+}
+
+// SubvaultParent returns the name of the parent sub-vault of name, or "" if its parent is
+// the vault itself.
+func SubvaultParent(name string) string {
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		return name[:i]
+	}
+	return ""
+}
+
+// WithSubvault makes VaultKey.Encrypt mark the header with the name of the sub-vault whose
+// key is used (see SubvaultName).
+func WithSubvault(name string) EncryptOption {
+	return func(opts *encryptOptions) {
+		opts.subvault = name
+	}
+}
+
+// SubvaultName returns the name of the sub-vault an encrypted file was encrypted for, or
+// "" if it was encrypted with the vault key itself. Only the header is needed.
+func SubvaultName(encrypted []byte) string {
+	names := markerArgs(encrypted, SubvaultStanzaType)
+	if len(names) == 0 {
+		return ""
+	}
+	return names[0]
+}
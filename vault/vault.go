@@ -3,93 +3,173 @@
 package vault
 
 import (
+	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
-	"os"
+	"strconv"
+	"strings"
 
 	"filippo.io/age"
+	"github.com/leolimasa/age-vault/fingerprint"
 )
 
 // VaultKey wraps the decrypted vault key and ensures it stays in memory only.
+// It holds an ordered keyring: the current key first, followed by retired keys
+// (newest first) that are kept so data encrypted before a rotation stays decryptable.
+// The keyring also records the ID of the vault and the generation of each key, which are
+// kept across rotations.
 type VaultKey struct {
-	identity age.Identity
+	identities  []age.Identity
+	id          string // Vault ID, derived from the first key of the vault if empty
+	generations []int  // Generation of each key, 0 (or missing) where not recorded
+}
+
+// Keyring metadata lines, as comments of the serialized keyring. The generation of a key is
+// the comment right before it: generationComment for the current key, keyGenerationComment
+// for retired ones, which older versions ignore.
+const (
+	vaultIDComment       = "# vault-id: "
+	generationComment    = "# generation: "
+	keyGenerationComment = "# key-generation: "
+)
+
+// NewVaultKey creates a VaultKey from the current vault key identity and,
+// optionally, retired vault key identities ordered from newest to oldest.
+func NewVaultKey(current age.Identity, retired ...age.Identity) *VaultKey {
+	identities := make([]age.Identity, 0, len(retired)+1)
+	identities = append(identities, current)
+	identities = append(identities, retired...)
+	return &VaultKey{identities: identities}
 }
 
 // GenerateVaultKey generates a new X25519 age identity to serve as the vault key.
 // This vault key will be encrypted per-user and used to encrypt/decrypt secrets.
 func GenerateVaultKey() (age.Identity, error) {
-	identity, err := age.GenerateX25519Identity()
-	if err != nil {
-		return nil, fmt.Errorf("error generating vault key: %w", err)
-	}
-	return identity, nil
+	return GenerateVaultKeyOfType(KeyTypeX25519)
 }
 
-// EncryptVaultKey encrypts a vault key identity for a specific recipient (user's public key).
+// EncryptVaultKey encrypts a vault key identity for one or more recipients (users' public keys).
 // Returns the encrypted vault key bytes that can be stored and distributed to users.
-func EncryptVaultKey(vaultKey age.Identity, recipientPubKey age.Recipient) ([]byte, error) {
-	fmt.Fprintf(os.Stderr, "[DEBUG vault.EncryptVaultKey] Starting encryption\n")
-	fmt.Fprintf(os.Stderr, "[DEBUG vault.EncryptVaultKey] Recipient type: %T\n", recipientPubKey)
+func EncryptVaultKey(vaultKey age.Identity, recipients ...age.Recipient) ([]byte, error) {
+	return EncryptVaultKeyring(NewVaultKey(vaultKey), recipients...)
+}
 
-	// Convert the vault key identity to its string representation
-	// X25519Identity has a String() method
-	x25519Identity, ok := vaultKey.(*age.X25519Identity)
-	if !ok {
-		return nil, fmt.Errorf("vault key must be an X25519Identity")
+// EncryptVaultKeyring encrypts the whole keyring of a vault key (current and retired keys)
+// for one or more recipients (users' public keys). Each recipient adds a stanza to the
+// header, and any of them can decrypt the result.
+// Returns the encrypted vault key bytes that can be stored and distributed to users.
+func EncryptVaultKeyring(vaultKey *VaultKey, recipients ...age.Recipient) ([]byte, error) {
+	return EncryptVaultKeyringLayers(vaultKey, recipients)
+}
+
+// EncryptVaultKeyringLayers encrypts the whole keyring of a vault key in nested age
+// envelopes, one per layer of recipients. The first layer is the outermost one, so
+// decrypting requires an identity of each layer, in order (see DecryptVaultKey).
+// This lets a vault key require several hardware keys at once.
+func EncryptVaultKeyringLayers(vaultKey *VaultKey, layers ...[]age.Recipient) ([]byte, error) {
+	if len(layers) == 0 {
+		return nil, fmt.Errorf("no recipients specified for vault key")
 	}
-	vaultKeyStr := x25519Identity.String()
-	fmt.Fprintf(os.Stderr, "[DEBUG vault.EncryptVaultKey] Vault key converted to string\n")
+
+	// Serialize the keyring, one identity per line
+	payload, err := vaultKey.Marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	// Encrypt from the innermost layer out
+	for i := len(layers) - 1; i >= 0; i-- {
+		payload, err = encrypt(payload, layers[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return payload, nil
+}
+
+// encrypt encrypts payload for the recipients in a single age envelope.
+func encrypt(payload []byte, recipients []age.Recipient) ([]byte, error) {
+	if len(recipients) == 0 {
+		return nil, fmt.Errorf("no recipients specified for vault key")
+	}
+	if err := CheckRecipientKinds(recipients); err != nil {
+		return nil, err
+	}
+	recipients = withPassphraseLabels(recipients)
 
 	// Create a buffer to hold the encrypted vault key
 	var encryptedBuf bytes.Buffer
 
-	// Create an encryptor for the recipient
-	fmt.Fprintf(os.Stderr, "[DEBUG vault.EncryptVaultKey] Creating encryptor...\n")
-	w, err := age.Encrypt(&encryptedBuf, recipientPubKey)
+	// Create an encryptor for the recipients
+	w, err := age.Encrypt(&encryptedBuf, recipients...)
 	if err != nil {
 		return nil, fmt.Errorf("error creating encryptor: %w", err)
 	}
-	fmt.Fprintf(os.Stderr, "[DEBUG vault.EncryptVaultKey] Encryptor created\n")
 
-	// Write the vault key to the encryptor
-	fmt.Fprintf(os.Stderr, "[DEBUG vault.EncryptVaultKey] Writing vault key to encryptor...\n")
-	if _, err := io.WriteString(w, vaultKeyStr); err != nil {
+	// Write the payload to the encryptor
+	if _, err := w.Write(payload); err != nil {
 		return nil, fmt.Errorf("error writing vault key: %w", err)
 	}
-	fmt.Fprintf(os.Stderr, "[DEBUG vault.EncryptVaultKey] Vault key written\n")
 
 	// Close the encryptor to finalize encryption
-	fmt.Fprintf(os.Stderr, "[DEBUG vault.EncryptVaultKey] Closing encryptor...\n")
 	if err := w.Close(); err != nil {
 		return nil, fmt.Errorf("error closing encryptor: %w", err)
 	}
-	fmt.Fprintf(os.Stderr, "[DEBUG vault.EncryptVaultKey] Encryptor closed successfully\n")
 
 	return encryptedBuf.Bytes(), nil
 }
 
 // DecryptVaultKey decrypts an encrypted vault key using the user's identity (private key).
-// Supports both native X25519 identities and plugin-based identities.
+// Supports both native X25519 identities and plugin-based identities, and vault key files
+// with or without an envelope (see OpenEnvelope).
+// Vault keys encrypted in layers (see EncryptVaultKeyringLayers) are unwrapped in sequence,
+// with one identity per layer; if there are more layers than identities, the last identity
+// is used for the remaining ones.
 // Returns a VaultKey wrapper containing the decrypted vault key ready for use.
-func DecryptVaultKey(encryptedKey []byte, userIdentity age.Identity) (*VaultKey, error) {
-	// Create a reader for the encrypted vault key
-	r := bytes.NewReader(encryptedKey)
+func DecryptVaultKey(encryptedKey []byte, userIdentities ...age.Identity) (*VaultKey, error) {
+	if len(userIdentities) == 0 {
+		return nil, fmt.Errorf("no identities specified to decrypt vault key")
+	}
 
-	// Decrypt the vault key
-	decryptor, err := age.Decrypt(r, userIdentity)
+	_, data, err := OpenEnvelope(encryptedKey)
 	if err != nil {
-		return nil, fmt.Errorf("error creating decryptor: %w", err)
+		return nil, err
 	}
+	for layer := 0; layer == 0 || isEncrypted(data); layer++ {
+		userIdentity := userIdentities[min(layer, len(userIdentities)-1)]
 
-	// Read the decrypted vault key
-	var decryptedBuf bytes.Buffer
-	if _, err := io.Copy(&decryptedBuf, decryptor); err != nil {
-		return nil, fmt.Errorf("error reading decrypted vault key: %w", err)
+		// Decrypt the layer
+		decryptor, err := age.Decrypt(bytes.NewReader(data), userIdentity)
+		if err != nil {
+			if layer > 0 {
+				return nil, fmt.Errorf("error creating decryptor for layer %d: %w", layer+1, err)
+			}
+			return nil, fmt.Errorf("error creating decryptor: %w", err)
+		}
+
+		// Read the decrypted layer
+		var decryptedBuf bytes.Buffer
+		if _, err := io.Copy(&decryptedBuf, decryptor); err != nil {
+			return nil, fmt.Errorf("error reading decrypted vault key: %w", err)
+		}
+		data = decryptedBuf.Bytes()
 	}
 
-	// Parse the decrypted vault key as an age identity
-	identities, err := age.ParseIdentities(bytes.NewReader(decryptedBuf.Bytes()))
+	return ParseVaultKey(data)
+}
+
+// isEncrypted reports whether data is an age file, i.e. another layer of a vault key.
+func isEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte("age-encryption.org/"))
+}
+
+// ParseVaultKey parses a keyring serialized with Marshal.
+func ParseVaultKey(data []byte) (*VaultKey, error) {
+	// Parse the keyring, the current vault key comes first
+	identities, err := age.ParseIdentities(bytes.NewReader(data))
 	if err != nil {
 		return nil, fmt.Errorf("error parsing vault key identity: %w", err)
 	}
@@ -98,18 +178,170 @@ func DecryptVaultKey(encryptedKey []byte, userIdentity age.Identity) (*VaultKey,
 		return nil, fmt.Errorf("no identities found in decrypted vault key")
 	}
 
-	return &VaultKey{identity: identities[0]}, nil
+	// Restore the metadata, absent from keyrings serialized by older versions. Identity lines
+	// are counted like age.ParseIdentities does, to match generations with their keys.
+	vk := &VaultKey{identities: identities, generations: make([]int, len(identities))}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	key := 0
+	pending := 0
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if id, ok := strings.CutPrefix(line, vaultIDComment); ok {
+			vk.id = strings.TrimSpace(id)
+		} else if generation, ok := strings.CutPrefix(line, generationComment); ok {
+			pending = parseGeneration(generation)
+		} else if generation, ok := strings.CutPrefix(line, keyGenerationComment); ok {
+			pending = parseGeneration(generation)
+		} else if line != "" && !strings.HasPrefix(line, "#") {
+			if key < len(vk.generations) {
+				vk.generations[key] = pending
+			}
+			key++
+			pending = 0
+		}
+	}
+
+	return vk, nil
+}
+
+// parseGeneration parses the value of a generation comment, 0 if invalid.
+func parseGeneration(value string) int {
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || n <= 0 {
+		return 0
+	}
+	return n
+}
+
+// Marshal serializes the keyring as an age identity file, one identity per line,
+// current key first, preceded by the vault ID and each key by its generation as comments.
+// This is the plaintext that EncryptVaultKeyring encrypts.
+func (vk *VaultKey) Marshal() ([]byte, error) {
+	var buf bytes.Buffer
+	if id := vk.ID(); id != "" {
+		buf.WriteString(vaultIDComment + id + "\n")
+	}
+	generations := vk.KeyGenerations()
+	for i, identity := range vk.identities {
+		identityStr, err := IdentityString(identity)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			buf.WriteString(generationComment + strconv.Itoa(generations[i]) + "\n")
+		} else {
+			buf.WriteString(keyGenerationComment + strconv.Itoa(generations[i]) + "\n")
+		}
+		buf.WriteString(identityStr)
+		buf.WriteString("\n")
+	}
+	return buf.Bytes(), nil
+}
+
+// Rotate returns a new VaultKey with newIdentity as the current key, of the next generation
+// of the same vault. The keys of this vault key become retired keys of the new one.
+func (vk *VaultKey) Rotate(newIdentity age.Identity) *VaultKey {
+	rotated := NewVaultKey(newIdentity, vk.identities...)
+	rotated.id = vk.ID()
+	rotated.generations = append([]int{vk.Generation() + 1}, vk.KeyGenerations()...)
+	return rotated
+}
+
+// DropRetired returns a VaultKey holding only the current key, of the same vault and generation.
+func (vk *VaultKey) DropRetired() *VaultKey {
+	current := NewVaultKey(vk.identities[0])
+	current.id = vk.ID()
+	current.generations = []int{vk.Generation()}
+	return current
+}
+
+// ID returns the ID of the vault the key belongs to. It is the fingerprint of the first key
+// of the vault, as found at the end of the keyring when the ID was not recorded.
+func (vk *VaultKey) ID() string {
+	if vk.id != "" {
+		return vk.id
+	}
+	_, recipient, err := RecipientOf(vk.identities[len(vk.identities)-1])
+	if err != nil {
+		return ""
+	}
+	return strings.ReplaceAll(fingerprint.Of(recipient).Hex(), " ", "")
+}
+
+// Generation returns the generation of the current key: 1 for the first key of the vault,
+// incremented by each rotation. When it was not recorded, the keyring size is used.
+func (vk *VaultKey) Generation() int {
+	if len(vk.generations) > 0 && vk.generations[0] > 0 {
+		return vk.generations[0]
+	}
+	return len(vk.identities)
+}
+
+// KeyGenerations returns the generation of each key, in the order of Identities. Retired keys
+// whose generation was not recorded are taken to be one generation older than the previous key.
+func (vk *VaultKey) KeyGenerations() []int {
+	generations := make([]int, len(vk.identities))
+	generation := vk.Generation()
+	for i := range vk.identities {
+		if i < len(vk.generations) && vk.generations[i] > 0 {
+			generation = vk.generations[i]
+		}
+		generations[i] = generation
+		generation--
+	}
+	return generations
+}
+
+// EncryptOption configures VaultKey.Encrypt.
+type EncryptOption func(*encryptOptions)
+
+// encryptOptions holds the settings of EncryptOption.
+type encryptOptions struct {
+	subvault string      // Sub-vault marker, see WithSubvault
+	header   *FileHeader // Metadata header, see WithFileHeader
 }
 
 // Encrypt encrypts data from input to output using the vault key.
-func (vk *VaultKey) Encrypt(input io.Reader, output io.Writer) error {
-	// Get the recipient from the vault key identity
-	x25519Identity, ok := vk.identity.(*age.X25519Identity)
-	if !ok {
-		return fmt.Errorf("vault key must be an X25519Identity")
+// Data is always encrypted with the current (newest) key of the keyring.
+func (vk *VaultKey) Encrypt(input io.Reader, output io.Writer, options ...EncryptOption) error {
+	var opts encryptOptions
+	for _, option := range options {
+		option(&opts)
+	}
+
+	// Get the recipient from the current vault key identity
+	recipient, _, err := RecipientOf(vk.identities[0])
+	if err != nil {
+		return err
 	}
-	recipient := x25519Identity.Recipient()
 
+	if opts.subvault != "" {
+		recipient = &markerRecipient{
+			recipient: recipient,
+			marker:    &age.Stanza{Type: SubvaultStanzaType, Args: []string{opts.subvault}},
+		}
+	}
+
+	// Write the metadata header, bound to the age header by its hash
+	if opts.header != nil {
+		header := *opts.header
+		header.VaultID = vk.ID()
+		header.Generation = vk.Generation()
+		headerData := header.marshal()
+		recipient = &markerRecipient{
+			recipient: recipient,
+			marker:    &age.Stanza{Type: HeaderStanzaType, Args: []string{headerHash(headerData)}},
+		}
+		if _, err := output.Write(headerData); err != nil {
+			return fmt.Errorf("error writing file header: %w", err)
+		}
+	}
+
+	return encryptData(recipient, input, output)
+}
+
+// encryptData encrypts data from input to output for a single recipient.
+func encryptData(recipient age.Recipient, input io.Reader, output io.Writer) error {
 	// Create an encryptor
 	w, err := age.Encrypt(output, recipient)
 	if err != nil {
@@ -130,10 +362,31 @@ func (vk *VaultKey) Encrypt(input io.Reader, output io.Writer) error {
 }
 
 // Decrypt decrypts data from input to output using the vault key.
+// Every key of the keyring is tried, so data encrypted with retired keys can still be decrypted.
+// Files with a metadata header (see WithFileHeader) are decrypted with the key of their
+// vault and generation, and fail with a precise error if the keyring doesn't have it.
 func (vk *VaultKey) Decrypt(input io.Reader, output io.Writer) error {
+	bufferedInput := bufio.NewReaderSize(input, MaxHeaderSize)
+	header, err := readFileHeader(bufferedInput)
+	if err != nil {
+		return err
+	}
+
+	identities := vk.identities
+	if header != nil {
+		identity, err := vk.identityFor(header)
+		if err != nil {
+			return err
+		}
+		identities = []age.Identity{identity}
+	}
+
 	// Create a decryptor
-	r, err := age.Decrypt(input, vk.identity)
+	r, err := age.Decrypt(bufferedInput, identities...)
 	if err != nil {
+		if header != nil {
+			return fmt.Errorf("error decrypting file encrypted with key generation %d, the file may be corrupt: %w", header.Generation, err)
+		}
 		return fmt.Errorf("error creating decryptor: %w", err)
 	}
 
@@ -145,11 +398,53 @@ func (vk *VaultKey) Decrypt(input io.Reader, output io.Writer) error {
 	return nil
 }
 
-// GetIdentity returns the underlying age identity from the vault key.
-// This is used when re-encrypting the vault key for new recipients.
+// Unwrap implements age.Identity with every key of the keyring, so a vault key can decrypt
+// the keys of its sub-vaults with DecryptVaultKey.
+func (vk *VaultKey) Unwrap(stanzas []*age.Stanza) ([]byte, error) {
+	for _, identity := range vk.identities {
+		fileKey, err := identity.Unwrap(stanzas)
+		if errors.Is(err, age.ErrIncorrectIdentity) {
+			continue
+		}
+		return fileKey, err
+	}
+	return nil, age.ErrIncorrectIdentity
+}
+
+// GetIdentity returns the underlying age identity of the current vault key.
 func (vk *VaultKey) GetIdentity() (age.Identity, error) {
-	if vk.identity == nil {
+	if len(vk.identities) == 0 || vk.identities[0] == nil {
 		return nil, fmt.Errorf("vault key identity is nil")
 	}
-	return vk.identity, nil
+	return vk.identities[0], nil
+}
+
+// Identities returns every identity of the keyring, current key first.
+func (vk *VaultKey) Identities() []age.Identity {
+	identities := make([]age.Identity, len(vk.identities))
+	copy(identities, vk.identities)
+	return identities
+}
+
+// Recipient returns the recipient (public key) of the current vault key and its encoding.
+func (vk *VaultKey) Recipient() (age.Recipient, string, error) {
+	identity, err := vk.GetIdentity()
+	if err != nil {
+		return nil, "", err
+	}
+	return RecipientOf(identity)
+}
+
+// Contains reports whether identity is one of the keys of the keyring.
+func (vk *VaultKey) Contains(identity age.Identity) bool {
+	target, err := IdentityString(identity)
+	if err != nil {
+		return false
+	}
+	for _, id := range vk.identities {
+		if identityStr, err := IdentityString(id); err == nil && identityStr == target {
+			return true
+		}
+	}
+	return false
 }
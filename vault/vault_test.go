@@ -65,7 +65,7 @@ func TestEncryptDecryptVaultKey(t *testing.T) {
 
 	// Verify that the decrypted vault key matches the original
 	origX25519, _ := vaultKey.(*age.X25519Identity)
-	decryptedX25519, ok := decryptedVaultKey.identity.(*age.X25519Identity)
+	decryptedX25519, ok := decryptedVaultKey.identities[0].(*age.X25519Identity)
 	if !ok {
 		t.Error("Decrypted vault key is not an X25519Identity")
 	}
@@ -82,7 +82,7 @@ func TestVaultKeyEncryptDecrypt(t *testing.T) {
 	}
 
 	// Create a VaultKey wrapper
-	vk := &VaultKey{identity: vaultKey}
+	vk := NewVaultKey(vaultKey)
 
 	// Test data
 	plaintext := "This is a secret message!"
@@ -119,7 +119,7 @@ func TestVaultKeyEncryptDecryptLargeData(t *testing.T) {
 	}
 
 	// Create a VaultKey wrapper
-	vk := &VaultKey{identity: vaultKey}
+	vk := NewVaultKey(vaultKey)
 
 	// Create large test data (1 MB)
 	plaintext := strings.Repeat("This is a secret message! ", 40000)
@@ -167,7 +167,7 @@ func TestVaultKeyDecryptInvalidData(t *testing.T) {
 	}
 
 	// Create a VaultKey wrapper
-	vk := &VaultKey{identity: vaultKey}
+	vk := NewVaultKey(vaultKey)
 
 	// Try to decrypt invalid data
 	invalidData := bytes.NewReader([]byte("this is not encrypted data"))
@@ -177,3 +177,603 @@ func TestVaultKeyDecryptInvalidData(t *testing.T) {
 		t.Error("Decrypt() should fail with invalid data")
 	}
 }
+
+func TestVaultKeyringDecryptsRetiredKeys(t *testing.T) {
+	oldKey, err := GenerateVaultKey()
+	if err != nil {
+		t.Fatalf("GenerateVaultKey() failed: %v", err)
+	}
+	newKey, err := GenerateVaultKey()
+	if err != nil {
+		t.Fatalf("GenerateVaultKey() failed: %v", err)
+	}
+
+	// Encrypt data with the old key only
+	var encryptedBuf bytes.Buffer
+	if err := NewVaultKey(oldKey).Encrypt(strings.NewReader("old secret"), &encryptedBuf); err != nil {
+		t.Fatalf("Encrypt() failed: %v", err)
+	}
+
+	// The rotated keyring must still decrypt it
+	vk := NewVaultKey(oldKey).Rotate(newKey)
+	var decryptedBuf bytes.Buffer
+	if err := vk.Decrypt(&encryptedBuf, &decryptedBuf); err != nil {
+		t.Fatalf("Decrypt() with retired key failed: %v", err)
+	}
+	if decryptedBuf.String() != "old secret" {
+		t.Errorf("Decrypted data does not match original, got: %s", decryptedBuf.String())
+	}
+
+	// New data must be encrypted with the newest key only
+	encryptedBuf.Reset()
+	if err := vk.Encrypt(strings.NewReader("new secret"), &encryptedBuf); err != nil {
+		t.Fatalf("Encrypt() failed: %v", err)
+	}
+	decryptedBuf.Reset()
+	if err := NewVaultKey(newKey).Decrypt(bytes.NewReader(encryptedBuf.Bytes()), &decryptedBuf); err != nil {
+		t.Fatalf("Data was not encrypted with the current key: %v", err)
+	}
+	if err := NewVaultKey(oldKey).Decrypt(bytes.NewReader(encryptedBuf.Bytes()), &decryptedBuf); err == nil {
+		t.Error("Data should not be decryptable with the retired key")
+	}
+}
+
+func TestEncryptDecryptVaultKeyring(t *testing.T) {
+	oldKey, err := GenerateVaultKey()
+	if err != nil {
+		t.Fatalf("GenerateVaultKey() failed: %v", err)
+	}
+	newKey, err := GenerateVaultKey()
+	if err != nil {
+		t.Fatalf("GenerateVaultKey() failed: %v", err)
+	}
+	userIdentity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("GenerateX25519Identity() failed: %v", err)
+	}
+
+	vk := NewVaultKey(newKey, oldKey)
+	encryptedKey, err := EncryptVaultKeyring(vk, userIdentity.Recipient())
+	if err != nil {
+		t.Fatalf("EncryptVaultKeyring() failed: %v", err)
+	}
+
+	decrypted, err := DecryptVaultKey(encryptedKey, userIdentity)
+	if err != nil {
+		t.Fatalf("DecryptVaultKey() failed: %v", err)
+	}
+
+	identities := decrypted.Identities()
+	if len(identities) != 2 {
+		t.Fatalf("Expected 2 keys in keyring, got %d", len(identities))
+	}
+	if identities[0].(*age.X25519Identity).String() != newKey.(*age.X25519Identity).String() {
+		t.Error("Current key is not first in the decrypted keyring")
+	}
+	if identities[1].(*age.X25519Identity).String() != oldKey.(*age.X25519Identity).String() {
+		t.Error("Retired key is missing from the decrypted keyring")
+	}
+}
+
+func TestEncryptVaultKeyWithRecoveryPassphrase(t *testing.T) {
+	vaultKey, err := GenerateVaultKey()
+	if err != nil {
+		t.Fatalf("GenerateVaultKey() failed: %v", err)
+	}
+	userIdentity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("GenerateX25519Identity() failed: %v", err)
+	}
+	passphraseRecipient, err := NewPassphraseRecipient("correct horse battery staple")
+	if err != nil {
+		t.Fatalf("NewPassphraseRecipient() failed: %v", err)
+	}
+
+	// The passphrase can be mixed with a public key recipient
+	encryptedKey, err := EncryptVaultKey(vaultKey, userIdentity.Recipient(), passphraseRecipient)
+	if err != nil {
+		t.Fatalf("EncryptVaultKey() failed: %v", err)
+	}
+	if !HasPassphraseStanza(encryptedKey) {
+		t.Error("HasPassphraseStanza() = false, want true")
+	}
+
+	// Both the identity and the passphrase decrypt it
+	passphraseIdentity, err := NewPassphraseIdentity("correct horse battery staple")
+	if err != nil {
+		t.Fatalf("NewPassphraseIdentity() failed: %v", err)
+	}
+	for _, identity := range []age.Identity{userIdentity, passphraseIdentity} {
+		decrypted, err := DecryptVaultKey(encryptedKey, identity)
+		if err != nil {
+			t.Fatalf("DecryptVaultKey() failed: %v", err)
+		}
+		if !decrypted.Contains(vaultKey) {
+			t.Error("decrypted vault key does not match original")
+		}
+	}
+
+	// A wrong passphrase does not
+	wrongIdentity, err := NewPassphraseIdentity("wrong passphrase")
+	if err != nil {
+		t.Fatalf("NewPassphraseIdentity() failed: %v", err)
+	}
+	if _, err := DecryptVaultKey(encryptedKey, wrongIdentity); err == nil {
+		t.Error("DecryptVaultKey() should fail with a wrong passphrase")
+	}
+
+	// Without the passphrase recipient there is no passphrase stanza
+	encryptedKey, err = EncryptVaultKey(vaultKey, userIdentity.Recipient())
+	if err != nil {
+		t.Fatalf("EncryptVaultKey() failed: %v", err)
+	}
+	if HasPassphraseStanza(encryptedKey) {
+		t.Error("HasPassphraseStanza() = true, want false")
+	}
+}
+
+func TestEncryptVaultKeyNoRecipients(t *testing.T) {
+	vaultKey, err := GenerateVaultKey()
+	if err != nil {
+		t.Fatalf("GenerateVaultKey() failed: %v", err)
+	}
+	if _, err := EncryptVaultKey(vaultKey); err == nil {
+		t.Error("EncryptVaultKey() should fail without recipients")
+	}
+}
+
+func TestEncryptVaultKeyWithEscrowRecipient(t *testing.T) {
+	vaultKey, err := GenerateVaultKey()
+	if err != nil {
+		t.Fatalf("GenerateVaultKey() failed: %v", err)
+	}
+	userIdentity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("GenerateX25519Identity() failed: %v", err)
+	}
+	escrowIdentity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("GenerateX25519Identity() failed: %v", err)
+	}
+
+	encryptedKey, err := EncryptVaultKey(vaultKey, userIdentity.Recipient(), NewEscrowRecipient(escrowIdentity.Recipient(), "safe"))
+	if err != nil {
+		t.Fatalf("EncryptVaultKey() failed: %v", err)
+	}
+
+	ids := EscrowIDs(encryptedKey)
+	if len(ids) != 1 || ids[0] != "safe" {
+		t.Errorf("EscrowIDs() = %v, want [safe]", ids)
+	}
+
+	// Both the user and the escrow identity decrypt it, the marker doesn't get in the way
+	for _, identity := range []age.Identity{userIdentity, escrowIdentity} {
+		decrypted, err := DecryptVaultKey(encryptedKey, identity)
+		if err != nil {
+			t.Fatalf("DecryptVaultKey() failed: %v", err)
+		}
+		if !decrypted.Contains(vaultKey) {
+			t.Error("decrypted vault key does not match original")
+		}
+	}
+}
+
+func TestEncryptDecryptVaultKeyringLayers(t *testing.T) {
+	vaultKey, err := GenerateVaultKey()
+	if err != nil {
+		t.Fatalf("GenerateVaultKey() failed: %v", err)
+	}
+	outer, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("GenerateX25519Identity() failed: %v", err)
+	}
+	inner, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("GenerateX25519Identity() failed: %v", err)
+	}
+
+	encryptedKey, err := EncryptVaultKeyringLayers(NewVaultKey(vaultKey),
+		[]age.Recipient{outer.Recipient()}, []age.Recipient{inner.Recipient()})
+	if err != nil {
+		t.Fatalf("EncryptVaultKeyringLayers() failed: %v", err)
+	}
+
+	// Both identities are required, in order
+	decrypted, err := DecryptVaultKey(encryptedKey, outer, inner)
+	if err != nil {
+		t.Fatalf("DecryptVaultKey() failed: %v", err)
+	}
+	if !decrypted.Contains(vaultKey) {
+		t.Error("decrypted vault key does not match original")
+	}
+
+	if _, err := DecryptVaultKey(encryptedKey, outer); err == nil {
+		t.Error("DecryptVaultKey() should fail with only the outer identity")
+	}
+	if _, err := DecryptVaultKey(encryptedKey, inner, outer); err == nil {
+		t.Error("DecryptVaultKey() should fail with the identities in the wrong order")
+	}
+}
+
+func TestHybridVaultKey(t *testing.T) {
+	hybridKey, err := GenerateVaultKeyOfType(KeyTypeHybrid)
+	if err != nil {
+		t.Fatalf("GenerateVaultKeyOfType() failed: %v", err)
+	}
+	if keyType, err := KeyTypeOf(hybridKey); err != nil || keyType != KeyTypeHybrid {
+		t.Errorf("KeyTypeOf() = %q, %v, want %q", keyType, err, KeyTypeHybrid)
+	}
+
+	// A keyring mixing a hybrid current key with a retired X25519 key
+	oldKey, err := GenerateVaultKey()
+	if err != nil {
+		t.Fatalf("GenerateVaultKey() failed: %v", err)
+	}
+	vaultKey := NewVaultKey(hybridKey, oldKey)
+
+	_, recipientStr, err := vaultKey.Recipient()
+	if err != nil {
+		t.Fatalf("Recipient() failed: %v", err)
+	}
+	if !strings.HasPrefix(recipientStr, "age1pq1") {
+		t.Errorf("unexpected hybrid public key: %s", recipientStr)
+	}
+
+	// Data is encrypted to the hybrid key
+	var encrypted bytes.Buffer
+	if err := vaultKey.Encrypt(strings.NewReader("post-quantum secret"), &encrypted); err != nil {
+		t.Fatalf("Encrypt() failed: %v", err)
+	}
+	if _, err := age.Decrypt(bytes.NewReader(encrypted.Bytes()), oldKey); err == nil {
+		t.Error("data should not be decryptable with the retired key")
+	}
+
+	// The keyring survives being encrypted for a user
+	userIdentity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("GenerateX25519Identity() failed: %v", err)
+	}
+	encryptedKey, err := EncryptVaultKeyring(vaultKey, userIdentity.Recipient())
+	if err != nil {
+		t.Fatalf("EncryptVaultKeyring() failed: %v", err)
+	}
+	decryptedKey, err := DecryptVaultKey(encryptedKey, userIdentity)
+	if err != nil {
+		t.Fatalf("DecryptVaultKey() failed: %v", err)
+	}
+	if !decryptedKey.Contains(hybridKey) || !decryptedKey.Contains(oldKey) {
+		t.Error("decrypted keyring is missing keys")
+	}
+
+	var decrypted bytes.Buffer
+	if err := decryptedKey.Decrypt(bytes.NewReader(encrypted.Bytes()), &decrypted); err != nil {
+		t.Fatalf("Decrypt() failed: %v", err)
+	}
+	if decrypted.String() != "post-quantum secret" {
+		t.Errorf("decrypted %q", decrypted.String())
+	}
+}
+
+func TestEncryptVaultKeyRecipientKinds(t *testing.T) {
+	vaultKey, err := GenerateVaultKeyOfType(KeyTypeHybrid)
+	if err != nil {
+		t.Fatalf("GenerateVaultKeyOfType() failed: %v", err)
+	}
+	hybridIdentity, err := age.GenerateHybridIdentity()
+	if err != nil {
+		t.Fatalf("GenerateHybridIdentity() failed: %v", err)
+	}
+	classicIdentity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("GenerateX25519Identity() failed: %v", err)
+	}
+	passphraseRecipient, err := NewPassphraseRecipient("correct horse battery staple")
+	if err != nil {
+		t.Fatalf("NewPassphraseRecipient() failed: %v", err)
+	}
+
+	escrow := NewEscrowRecipient(hybridIdentity.Recipient(), "safe")
+	if !IsPostQuantum(escrow) || IsPostQuantum(classicIdentity.Recipient()) {
+		t.Error("IsPostQuantum() does not tell hybrid and classic recipients apart")
+	}
+
+	// Post-quantum and classic recipients are rejected before age gets to them
+	_, err = EncryptVaultKey(vaultKey, hybridIdentity.Recipient(), NewEscrowRecipient(classicIdentity.Recipient(), "safe"))
+	if err == nil || !strings.Contains(err.Error(), "post-quantum") {
+		t.Errorf("EncryptVaultKey() should reject mixed recipient kinds, got: %v", err)
+	}
+
+	// A recovery passphrase goes with either kind
+	for _, recipient := range []age.Recipient{hybridIdentity.Recipient(), classicIdentity.Recipient()} {
+		if _, err := EncryptVaultKey(vaultKey, recipient, passphraseRecipient); err != nil {
+			t.Errorf("EncryptVaultKey() with a recovery passphrase failed: %v", err)
+		}
+	}
+}
+
+func TestParseKeyType(t *testing.T) {
+	for _, name := range []string{"x25519", "hybrid"} {
+		if _, err := ParseKeyType(name); err != nil {
+			t.Errorf("ParseKeyType(%q) failed: %v", name, err)
+		}
+	}
+	if _, err := ParseKeyType("rsa"); err == nil {
+		t.Error("ParseKeyType() should fail for an unknown type")
+	}
+}
+
+func TestSubvault(t *testing.T) {
+	parentIdentity, err := GenerateVaultKey()
+	if err != nil {
+		t.Fatalf("GenerateVaultKey() failed: %v", err)
+	}
+	subvaultIdentity, err := GenerateVaultKey()
+	if err != nil {
+		t.Fatalf("GenerateVaultKey() failed: %v", err)
+	}
+	parentKey := NewVaultKey(parentIdentity)
+	parentRecipient, _, err := parentKey.Recipient()
+	if err != nil {
+		t.Fatalf("Recipient() failed: %v", err)
+	}
+
+	// The parent vault key opens a sub-vault key encrypted to it
+	encryptedKey, err := EncryptVaultKey(subvaultIdentity, parentRecipient)
+	if err != nil {
+		t.Fatalf("EncryptVaultKey() failed: %v", err)
+	}
+	subvaultKey, err := DecryptVaultKey(encryptedKey, parentKey)
+	if err != nil {
+		t.Fatalf("DecryptVaultKey() with parent vault key failed: %v", err)
+	}
+	if !subvaultKey.Contains(subvaultIdentity) {
+		t.Error("decrypted sub-vault key does not match original")
+	}
+
+	// Data encrypted for the sub-vault names it in its header
+	var encrypted bytes.Buffer
+	if err := subvaultKey.Encrypt(strings.NewReader("secret"), &encrypted, WithSubvault("prod")); err != nil {
+		t.Fatalf("Encrypt() failed: %v", err)
+	}
+	if name := SubvaultName(encrypted.Bytes()); name != "prod" {
+		t.Errorf("SubvaultName() = %q, want prod", name)
+	}
+	var decrypted bytes.Buffer
+	if err := subvaultKey.Decrypt(&encrypted, &decrypted); err != nil {
+		t.Fatalf("Decrypt() failed: %v", err)
+	}
+	if decrypted.String() != "secret" {
+		t.Errorf("decrypted %q, want secret", decrypted.String())
+	}
+
+	if SubvaultParent("prod/db") != "prod" || SubvaultParent("prod") != "" {
+		t.Error("SubvaultParent() returned the wrong parent")
+	}
+	for _, name := range []string{"", "/prod", "prod/", "../prod", "prod db"} {
+		if ValidateSubvaultName(name) == nil {
+			t.Errorf("ValidateSubvaultName(%q) succeeded, want error", name)
+		}
+	}
+}
+
+func TestVaultKeyMetadata(t *testing.T) {
+	first, err := GenerateVaultKey()
+	if err != nil {
+		t.Fatalf("GenerateVaultKey() failed: %v", err)
+	}
+	second, err := GenerateVaultKey()
+	if err != nil {
+		t.Fatalf("GenerateVaultKey() failed: %v", err)
+	}
+
+	vaultKey := NewVaultKey(first)
+	if vaultKey.ID() == "" || vaultKey.Generation() != 1 {
+		t.Fatalf("new vault key has ID %q and generation %d", vaultKey.ID(), vaultKey.Generation())
+	}
+
+	// Rotating keeps the ID and increments the generation, even without the retired keys
+	rotated := vaultKey.Rotate(second).DropRetired()
+	if rotated.ID() != vaultKey.ID() {
+		t.Errorf("rotated vault key ID = %q, want %q", rotated.ID(), vaultKey.ID())
+	}
+	if rotated.Generation() != 2 {
+		t.Errorf("rotated vault key generation = %d, want 2", rotated.Generation())
+	}
+
+	// The metadata survives serialization
+	data, err := rotated.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	parsed, err := ParseVaultKey(data)
+	if err != nil {
+		t.Fatalf("ParseVaultKey() failed: %v", err)
+	}
+	if parsed.ID() != vaultKey.ID() || parsed.Generation() != 2 {
+		t.Errorf("parsed vault key has ID %q and generation %d", parsed.ID(), parsed.Generation())
+	}
+	if len(parsed.Identities()) != 1 || !parsed.Contains(second) {
+		t.Error("parsed vault key does not hold the current key only")
+	}
+}
+
+func TestEnvelope(t *testing.T) {
+	vaultKeyIdentity, err := GenerateVaultKey()
+	if err != nil {
+		t.Fatalf("GenerateVaultKey() failed: %v", err)
+	}
+	userIdentity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("GenerateX25519Identity() failed: %v", err)
+	}
+	vaultKey := NewVaultKey(vaultKeyIdentity)
+	encryptedKey, err := EncryptVaultKeyring(vaultKey, userIdentity.Recipient())
+	if err != nil {
+		t.Fatalf("EncryptVaultKeyring() failed: %v", err)
+	}
+
+	sealed := NewEnvelope(vaultKey, "alice\n@laptop").Seal(encryptedKey)
+	if !IsEnvelope(sealed) || IsEnvelope(encryptedKey) {
+		t.Error("IsEnvelope() does not tell the envelope from the raw file")
+	}
+
+	envelope, payload, err := OpenEnvelope(sealed)
+	if err != nil {
+		t.Fatalf("OpenEnvelope() failed: %v", err)
+	}
+	if envelope.VaultID != vaultKey.ID() || envelope.Generation != 1 || envelope.Label != "alice @laptop" || envelope.Created.IsZero() {
+		t.Errorf("unexpected envelope: %+v", envelope)
+	}
+	if !bytes.Equal(payload, encryptedKey) {
+		t.Error("OpenEnvelope() payload differs from the encrypted vault key")
+	}
+
+	// Raw files have no envelope
+	envelope, payload, err = OpenEnvelope(encryptedKey)
+	if err != nil || envelope != nil || !bytes.Equal(payload, encryptedKey) {
+		t.Errorf("OpenEnvelope() on a raw file = %v, %v", envelope, err)
+	}
+
+	// Unknown versions are rejected
+	if _, _, err := OpenEnvelope([]byte("age-vault-key/v9\nvault-id: x\n\n")); err == nil {
+		t.Error("expected error for an unsupported envelope version")
+	}
+}
+
+func TestFileHeader(t *testing.T) {
+	first, err := GenerateVaultKey()
+	if err != nil {
+		t.Fatalf("GenerateVaultKey() failed: %v", err)
+	}
+	second, err := GenerateVaultKey()
+	if err != nil {
+		t.Fatalf("GenerateVaultKey() failed: %v", err)
+	}
+	vaultKey := NewVaultKey(first)
+
+	var encrypted bytes.Buffer
+	if err := vaultKey.Encrypt(strings.NewReader("secret"), &encrypted, WithFileHeader("text/plain", "secret.txt")); err != nil {
+		t.Fatalf("Encrypt() failed: %v", err)
+	}
+	data := encrypted.Bytes()
+
+	header, _, err := ParseFileHeader(data)
+	if err != nil {
+		t.Fatalf("ParseFileHeader() failed: %v", err)
+	}
+	want := FileHeader{VaultID: vaultKey.ID(), Generation: 1, ContentType: "text/plain", Filename: "secret.txt"}
+	if header == nil || *header != want {
+		t.Errorf("ParseFileHeader() = %+v, want %+v", header, want)
+	}
+
+	decrypt := func(vk *VaultKey, data []byte) (string, error) {
+		var decrypted bytes.Buffer
+		err := vk.Decrypt(bytes.NewReader(data), &decrypted)
+		return decrypted.String(), err
+	}
+
+	// The rotated keyring picks the retired key of the file's generation
+	rotated := vaultKey.Rotate(second)
+	if plaintext, err := decrypt(rotated, data); err != nil || plaintext != "secret" {
+		t.Errorf("Decrypt() with rotated keyring = %q, %v", plaintext, err)
+	}
+
+	// Precise errors when the keyring cannot have the key
+	other, err := GenerateVaultKey()
+	if err != nil {
+		t.Fatalf("GenerateVaultKey() failed: %v", err)
+	}
+	tests := []struct {
+		name     string
+		vaultKey *VaultKey
+		data     []byte
+		wantErr  string
+	}{
+		{"other vault", NewVaultKey(other), data, "belongs to vault"},
+		{"dropped generation", rotated.DropRetired(), data, "no longer in the keyring"},
+		{"modified header", vaultKey, bytes.Replace(data, []byte("secret.txt"), []byte("public.txt"), 1), "does not match"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decrypt(tt.vaultKey, tt.data); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got: %v", tt.wantErr, err)
+			}
+		})
+	}
+
+	var newer bytes.Buffer
+	if err := rotated.Encrypt(strings.NewReader("secret"), &newer, WithFileHeader("", "")); err != nil {
+		t.Fatalf("Encrypt() failed: %v", err)
+	}
+	if _, err := decrypt(vaultKey, newer.Bytes()); err == nil || !strings.Contains(err.Error(), "newer than the current vault key") {
+		t.Errorf("expected newer generation error, got: %v", err)
+	}
+
+	// Plain age files still decrypt
+	var plain bytes.Buffer
+	if err := vaultKey.Encrypt(strings.NewReader("secret"), &plain); err != nil {
+		t.Fatalf("Encrypt() failed: %v", err)
+	}
+	if header, _, err := ParseFileHeader(plain.Bytes()); err != nil || header != nil {
+		t.Errorf("ParseFileHeader() on a plain age file = %+v, %v", header, err)
+	}
+	if plaintext, err := decrypt(rotated, plain.Bytes()); err != nil || plaintext != "secret" {
+		t.Errorf("Decrypt() of a plain age file = %q, %v", plaintext, err)
+	}
+}
+
+func TestFileHeader_NonContiguousGenerations(t *testing.T) {
+	var keys []age.Identity
+	for i := 0; i < 3; i++ {
+		key, err := GenerateVaultKey()
+		if err != nil {
+			t.Fatalf("GenerateVaultKey() failed: %v", err)
+		}
+		keys = append(keys, key)
+	}
+
+	// A file encrypted with generation 2
+	vaultKey := NewVaultKey(keys[0]).Rotate(keys[1])
+	var encrypted bytes.Buffer
+	if err := vaultKey.Encrypt(strings.NewReader("secret"), &encrypted, WithFileHeader("", "")); err != nil {
+		t.Fatalf("Encrypt() failed: %v", err)
+	}
+
+	// A keyring at generation 5 that only kept the key of generation 2
+	current, err := IdentityString(keys[2])
+	if err != nil {
+		t.Fatalf("IdentityString() failed: %v", err)
+	}
+	retired, err := IdentityString(keys[1])
+	if err != nil {
+		t.Fatalf("IdentityString() failed: %v", err)
+	}
+	keyring := "# vault-id: " + vaultKey.ID() + "\n# generation: 5\n" + current + "\n# key-generation: 2\n" + retired + "\n"
+	parsed, err := ParseVaultKey([]byte(keyring))
+	if err != nil {
+		t.Fatalf("ParseVaultKey() failed: %v", err)
+	}
+
+	// The generations survive serialization and rotation
+	data, err := parsed.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	reparsed, err := ParseVaultKey(data)
+	if err != nil {
+		t.Fatalf("ParseVaultKey() failed: %v", err)
+	}
+	other, err := GenerateVaultKey()
+	if err != nil {
+		t.Fatalf("GenerateVaultKey() failed: %v", err)
+	}
+
+	for name, vk := range map[string]*VaultKey{"parsed": parsed, "reparsed": reparsed, "rotated": reparsed.Rotate(other)} {
+		t.Run(name, func(t *testing.T) {
+			var decrypted bytes.Buffer
+			if err := vk.Decrypt(bytes.NewReader(encrypted.Bytes()), &decrypted); err != nil || decrypted.String() != "secret" {
+				t.Errorf("Decrypt() = %q, %v", decrypted.String(), err)
+			}
+		})
+	}
+}